@@ -0,0 +1,359 @@
+package webrtc
+
+import (
+	"errors"
+	"hash/crc32"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	log "github.com/PeterXu/xrtc/util"
+	util "github.com/PeterXu/xrtc/util"
+)
+
+// strategies of selecting backend
+const (
+	kBackendRoundRobin    = "round_robin"    // smooth weighted round-robin
+	kBackendLeastSessions = "least_sessions" // min sessions/weight
+	kBackendRoomHash      = "room_hash"      // consistent hash on room id
+)
+
+// virtual nodes of one weight in hash ring
+const kBackendHashReplicas = 100
+
+var errNoBackend = errors.New("no available backend")
+var errBackendUnhealthy = errors.New("backend unhealthy")
+
+// backendAddr is the transport address of one candidate.
+type backendAddr struct {
+	proto string // udp/tcp
+	addr  string // "ip:port"
+}
+
+func parseBackendAddr(candidate string) *backendAddr {
+	cand := util.ParseCandidate(candidate)
+	if cand == nil {
+		return nil
+	}
+	return &backendAddr{cand.Transport, net.JoinHostPort(util.LookupIP(cand.Address), util.Itoa(cand.Port))}
+}
+
+// Backend is one webrtc server in pool.
+type Backend struct {
+	Name       string
+	Weight     int
+	Capacity   int // 0 for unlimited
+	Candidates []string
+	HealthUrl  string
+
+	addrs    []backendAddr
+	healthy  bool
+	sessions int
+	current  int    // for smooth weighted round-robin
+	fails    int    // consecutive check failures
+	rises    int    // consecutive check successes
+	lastErr  string // error of last check
+}
+
+func (b *Backend) isAvailable() bool {
+	return b.healthy && (b.Capacity <= 0 || b.sessions < b.Capacity)
+}
+
+// BackendInfo is the status of one backend.
+type BackendInfo struct {
+	Name     string `json:"name"`
+	Weight   int    `json:"weight"`
+	Capacity int    `json:"capacity"`
+	Healthy  bool   `json:"healthy"`
+	Sessions int    `json:"sessions"`
+	Error    string `json:"error,omitempty"` // last check error
+}
+
+// backendSession is one session on backend, bound when the user is created.
+type backendSession struct {
+	backend *Backend
+	bound   bool
+	ttl     int // ms, released if not bound
+	objtime *ObjTime
+}
+
+type backendHashNode struct {
+	hash    uint32
+	backend *Backend
+}
+
+// BackendPool selects backend for a logical room.
+type BackendPool struct {
+	TAG      string
+	strategy string
+	backends []*Backend
+	ring     []backendHashNode          // sorted by hash
+	sessions map[string]*backendSession // ice key => session
+	checker  *HealthChecker
+
+	sync.Mutex
+}
+
+// NewBackendPool returns nil if no backend servers.
+func NewBackendPool(params *BackendParams) *BackendPool {
+	if params == nil || len(params.Servers) == 0 {
+		return nil
+	}
+
+	p := &BackendPool{
+		TAG:      "[BACKEND]",
+		strategy: params.Strategy,
+		sessions: make(map[string]*backendSession),
+	}
+	for _, sp := range params.Servers {
+		p.backends = append(p.backends, &Backend{
+			Name:       sp.Name,
+			Weight:     sp.Weight,
+			Capacity:   sp.Capacity,
+			Candidates: sp.Candidates,
+			HealthUrl:  sp.HealthUrl,
+			healthy:    true,
+		})
+	}
+	for _, b := range p.backends {
+		for _, candidate := range b.Candidates {
+			if addr := parseBackendAddr(candidate); addr != nil {
+				b.addrs = append(b.addrs, *addr)
+			}
+		}
+	}
+
+	for _, b := range p.backends {
+		for i := 0; i < b.Weight*kBackendHashReplicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(b.Name + "#" + strconv.Itoa(i)))
+			p.ring = append(p.ring, backendHashNode{hash, b})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool {
+		return p.ring[i].hash < p.ring[j].hash
+	})
+
+	log.Println(p.TAG, "strategy:", p.strategy, ", backends:", len(p.backends))
+	if params.Health.Enable {
+		p.checker = NewHealthChecker(p, &params.Health)
+		go p.checker.Run()
+	}
+	return p
+}
+
+func (p *BackendPool) Close() {
+	if p != nil && p.checker != nil {
+		p.checker.Close()
+	}
+}
+
+// Select returns one available backend for room, by strategy.
+func (p *BackendPool) Select(room string) (*Backend, error) {
+	if p == nil {
+		return nil, errNoBackend
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.clearSessions()
+
+	var b *Backend
+	switch p.strategy {
+	case kBackendLeastSessions:
+		b = p.selectLeastSessions()
+	case kBackendRoomHash:
+		b = p.selectRoomHash(room)
+	default:
+		b = p.selectRoundRobin()
+	}
+	if b == nil {
+		return nil, errNoBackend
+	}
+	return b, nil
+}
+
+// selectRoundRobin is the smooth weighted round-robin of nginx.
+func (p *BackendPool) selectRoundRobin() *Backend {
+	var best *Backend
+	total := 0
+	for _, b := range p.backends {
+		if !b.isAvailable() {
+			continue
+		}
+		b.current += b.Weight
+		total += b.Weight
+		if best == nil || b.current > best.current {
+			best = b
+		}
+	}
+	if best != nil {
+		best.current -= total
+	}
+	return best
+}
+
+func (p *BackendPool) selectLeastSessions() *Backend {
+	var best *Backend
+	for _, b := range p.backends {
+		if !b.isAvailable() {
+			continue
+		}
+		// compare sessions/weight
+		if best == nil || b.sessions*best.Weight < best.sessions*b.Weight {
+			best = b
+		}
+	}
+	return best
+}
+
+// selectRoomHash returns the first available backend from the room's position in ring.
+func (p *BackendPool) selectRoomHash(room string) *Backend {
+	if len(p.ring) == 0 {
+		return nil
+	}
+	hash := crc32.ChecksumIEEE([]byte(room))
+	start := sort.Search(len(p.ring), func(i int) bool {
+		return p.ring[i].hash >= hash
+	})
+	for i := 0; i < len(p.ring); i++ {
+		node := p.ring[(start+i)%len(p.ring)]
+		if node.backend.isAvailable() {
+			return node.backend
+		}
+	}
+	return nil
+}
+
+// Acquire counts one session(ice key) on backend, with the registration ttl(ms).
+func (p *BackendPool) Acquire(key string, b *Backend, ttl int) {
+	if p == nil || b == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if s, ok := p.sessions[key]; ok {
+		s.backend.sessions--
+	}
+	b.sessions++
+	p.sessions[key] = &backendSession{backend: b, ttl: ttl, objtime: NewObjTime()}
+}
+
+// Bind marks the session in use, which is kept until Release.
+func (p *BackendPool) Bind(key string) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if s, ok := p.sessions[key]; ok {
+		s.bound = true
+	}
+}
+
+// Release removes the session from its backend.
+func (p *BackendPool) Release(key string) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if s, ok := p.sessions[key]; ok {
+		s.backend.sessions--
+		delete(p.sessions, key)
+	}
+}
+
+// clearSessions removes the unbound sessions(no stun from client) after registration ttl.
+func (p *BackendPool) clearSessions() {
+	for key, s := range p.sessions {
+		if !s.bound && s.objtime.checkTimeout(s.ttl) {
+			s.backend.sessions--
+			delete(p.sessions, key)
+		}
+	}
+}
+
+// SetHealthy updates the health status of backend by name.
+func (p *BackendPool) SetHealthy(name string, healthy bool) bool {
+	if p == nil {
+		return false
+	}
+	p.Lock()
+	defer p.Unlock()
+	for _, b := range p.backends {
+		if b.Name == name {
+			if b.healthy != healthy {
+				log.Println(p.TAG, "backend", name, "healthy:", healthy)
+			}
+			b.healthy = healthy
+			return true
+		}
+	}
+	return false
+}
+
+// onHealthResult updates backend status after fails/rises consecutive results.
+func (p *BackendPool) onHealthResult(b *Backend, err error, fails, rises int) {
+	p.Lock()
+	defer p.Unlock()
+
+	healthy := b.healthy
+	if err != nil {
+		b.lastErr = err.Error()
+		b.rises = 0
+		if b.fails++; b.fails >= fails {
+			healthy = false
+		}
+	} else {
+		b.lastErr = ""
+		b.fails = 0
+		if b.rises++; b.rises >= rises {
+			healthy = true
+		}
+	}
+	if healthy != b.healthy {
+		log.Warnln(p.TAG, "backend", b.Name, "healthy:", healthy, b.lastErr)
+		b.healthy = healthy
+	}
+}
+
+// CheckCandidates returns errBackendUnhealthy if candidates belong to an unhealthy backend.
+// The unknown candidates(not in pool) are always ok.
+func (p *BackendPool) CheckCandidates(candidates []string) error {
+	if p == nil || len(candidates) == 0 {
+		return nil
+	}
+	addr := parseBackendAddr(candidates[0])
+	if addr == nil {
+		return nil
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	for _, b := range p.backends {
+		for _, baddr := range b.addrs {
+			if baddr == *addr {
+				if !b.healthy {
+					return errBackendUnhealthy
+				}
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Infos returns the status of all backends.
+func (p *BackendPool) Infos() []BackendInfo {
+	if p == nil {
+		return nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	var infos []BackendInfo
+	for _, b := range p.backends {
+		infos = append(infos, BackendInfo{b.Name, b.Weight, b.Capacity, b.healthy, b.sessions, b.lastErr})
+	}
+	return infos
+}
@@ -0,0 +1,194 @@
+package webrtc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	util "github.com/PeterXu/xrtc/util"
+)
+
+func newTestBackendPool(strategy string) *BackendPool {
+	return NewBackendPool(&BackendParams{
+		Strategy: strategy,
+		Servers: []*BackendServerParams{
+			{Name: "a", Weight: 2, Candidates: []string{"a=candidate:1 1 udp 2013266431 10.0.0.1 5000 typ host"}},
+			{Name: "b", Weight: 1, Candidates: []string{"a=candidate:1 1 udp 2013266431 10.0.0.2 5000 typ host"}},
+		},
+	})
+}
+
+func TestBackendRoundRobin(t *testing.T) {
+	pool := newTestBackendPool(kBackendRoundRobin)
+	counts := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		b, err := pool.Select("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[b.Name]++
+	}
+	if counts["a"] != 4 || counts["b"] != 2 {
+		t.Errorf("wrong weighted round-robin: %v", counts)
+	}
+
+	pool.SetHealthy("a", false)
+	for i := 0; i < 3; i++ {
+		if b, _ := pool.Select(""); b.Name != "b" {
+			t.Errorf("unhealthy backend selected: %s", b.Name)
+		}
+	}
+	pool.SetHealthy("b", false)
+	if _, err := pool.Select(""); err != errNoBackend {
+		t.Errorf("expect no backend, got %v", err)
+	}
+}
+
+func TestBackendLeastSessions(t *testing.T) {
+	pool := newTestBackendPool(kBackendLeastSessions)
+	for i, want := range []string{"a", "b", "a", "a", "b"} {
+		b, _ := pool.Select("")
+		if b.Name != want {
+			t.Errorf("select %d: want %s, got %s", i, want, b.Name)
+		}
+		pool.Acquire(string(rune('0'+i)), b, kDefaultCacheTimeout)
+	}
+
+	pool.Release("0")
+	pool.Release("2")
+	if b, _ := pool.Select(""); b.Name != "a" {
+		t.Errorf("want a after release, got %s", b.Name)
+	}
+}
+
+func TestBackendSessionTtl(t *testing.T) {
+	pool := newTestBackendPool(kBackendLeastSessions)
+	b, _ := pool.Select("")
+	pool.Acquire("short", b, 1000)
+	pool.Acquire("long", b, 60*1000)
+	for _, s := range pool.sessions {
+		s.objtime.utime -= 40 * 1000
+	}
+
+	// only the unbound session after its ttl is removed
+	pool.Select("")
+	if _, ok := pool.sessions["short"]; ok || b.sessions != 1 {
+		t.Errorf("session not removed after ttl: %d", b.sessions)
+	}
+	if _, ok := pool.sessions["long"]; !ok {
+		t.Errorf("session removed before ttl")
+	}
+}
+
+func TestBackendRoomHash(t *testing.T) {
+	pool := newTestBackendPool(kBackendRoomHash)
+	first, _ := pool.Select("room1")
+	for i := 0; i < 5; i++ {
+		if b, _ := pool.Select("room1"); b != first {
+			t.Errorf("room moved from %s to %s", first.Name, b.Name)
+		}
+	}
+
+	pool.SetHealthy(first.Name, false)
+	if b, _ := pool.Select("room1"); b == first {
+		t.Errorf("unhealthy backend selected: %s", b.Name)
+	}
+}
+
+func TestBackendCapacity(t *testing.T) {
+	pool := newTestBackendPool(kBackendRoundRobin)
+	pool.backends[0].Capacity = 1
+	b, _ := pool.Select("")
+	pool.Acquire("key", b, kDefaultCacheTimeout)
+	for i := 0; i < 3; i++ {
+		if b, _ := pool.Select(""); b.Name != "b" {
+			t.Errorf("full backend selected: %s", b.Name)
+		}
+	}
+}
+
+func TestBackendHealthCheck(t *testing.T) {
+	// stun responder for backend "a"
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		data := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(data)
+			if err != nil {
+				return
+			}
+			var msg util.StunMessage
+			if !msg.Read(data[:n]) {
+				continue
+			}
+			var buf bytes.Buffer
+			util.GenStunMessageResponse(&buf, "pwd", msg.TransId, addr)
+			pc.WriteTo(buf.Bytes(), addr)
+		}
+	}()
+
+	// closed tcp port for backend "b"
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	stunAddr := pc.LocalAddr().(*net.UDPAddr)
+	params := &BackendParams{
+		Strategy: kBackendRoundRobin,
+		Servers: []*BackendServerParams{
+			{Name: "a", Weight: 1, Candidates: []string{
+				makeHostCandidate(1, "udp", "127.0.0.1", util.Itoa(stunAddr.Port))}},
+			{Name: "b", Weight: 1, Candidates: []string{
+				makeHostCandidate(1, "tcp", "127.0.0.1", util.Itoa(deadAddr.Port))}},
+		},
+	}
+	pool := NewBackendPool(params)
+	checker := NewHealthChecker(pool, &HealthParams{Timeout: time.Second, Fails: 1, Rises: 1, Stun: true})
+	checker.checkAll()
+
+	for _, info := range pool.Infos() {
+		if info.Healthy != (info.Name == "a") {
+			t.Errorf("wrong health of %s: %v, %s", info.Name, info.Healthy, info.Error)
+		}
+	}
+	if err := pool.CheckCandidates(params.Servers[1].Candidates); err != errBackendUnhealthy {
+		t.Errorf("expect unhealthy candidates, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if b, _ := pool.Select(""); b.Name != "a" {
+			t.Errorf("unhealthy backend selected: %s", b.Name)
+		}
+	}
+}
+
+func TestBackendDeleteSession(t *testing.T) {
+	hub := newStunTestHub(t)
+	hub.backends = newTestBackendPool(kBackendRoundRobin)
+	request := &RegisterRequest{
+		SessionKey: "s1",
+		OfferIce:   SdpIceInfo{Ufrag: "offer", Pwd: "offerpwd"},
+		AnswerIce:  SdpIceInfo{Ufrag: "answer", Pwd: "answerpwd"},
+	}
+	b, _ := hub.backends.Select("")
+	hub.cache.Set(request.iceKey(), NewCacheItem(request))
+	hub.backends.Acquire(request.iceKey(), b, kDefaultCacheTimeout)
+
+	// deleted before stun, only in cache
+	if err := hub.deleteSession("s1"); err != nil {
+		t.Fatal(err)
+	}
+	if b.sessions != 0 || len(hub.backends.sessions) != 0 {
+		t.Errorf("backend session not released: %d", b.sessions)
+	}
+	if err := hub.deleteSession("s1"); err == nil {
+		t.Errorf("expect error for unknown session")
+	}
+}
@@ -2,6 +2,7 @@ package webrtc
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/PeterXu/xrtc/util"
 )
@@ -23,7 +24,12 @@ func NewObjTime() *ObjTime {
 }
 
 func (o *ObjTime) update() {
-	o.utime = util.NowMs64()
+	atomic.StoreUint64(&o.utime, util.NowMs64())
+}
+
+// updateTime returns the last update time, safe for other goroutines.
+func (o *ObjTime) updateTime() uint64 {
+	return atomic.LoadUint64(&o.utime)
 }
 
 func (o *ObjTime) checkTimeout(timeout int) bool {
@@ -31,7 +37,7 @@ func (o *ObjTime) checkTimeout(timeout int) bool {
 		timeout = kDefaultTimeout
 	}
 	now := util.NowMs64()
-	return now >= o.utime+uint64(timeout)
+	return now >= o.updateTime()+uint64(timeout)
 }
 
 /// net stat
@@ -75,3 +81,15 @@ func (n NetStat) String() string {
 	return fmt.Sprintf("send:%d/%d_recv:%d/%d",
 		n.sendPackets, n.sendBytes, n.recvPackets, n.recvBytes)
 }
+
+// NetStatInfo is the exported totals of NetStat.
+type NetStatInfo struct {
+	SendPackets int    `json:"send_packets"`
+	SendBytes   uint64 `json:"send_bytes"`
+	RecvPackets int    `json:"recv_packets"`
+	RecvBytes   uint64 `json:"recv_bytes"`
+}
+
+func (n *NetStat) Info() *NetStatInfo {
+	return &NetStatInfo{n.sendPackets, n.sendBytes, n.recvPackets, n.recvBytes}
+}
@@ -7,27 +7,51 @@ import (
 	log "github.com/PeterXu/xrtc/util"
 )
 
-type Cache struct {
-	TAG      string
-	items    map[string]*CacheItem
-	exitTick chan bool
+// cache backend types
+const (
+	kCacheMemory = "memory"
+	kCacheBolt   = "bolt"
+)
 
-	sync.RWMutex
+// default 30s
+const kDefaultCacheTimeout = 30 * 1000 // ms
+
+// default sweep interval of timeout items
+const kDefaultCacheSweep = 30 * time.Second
+
+// CacheExpiredFunc is called when one item is expired(not deleted).
+type CacheExpiredFunc func(key string, item *CacheItem)
+
+// Cache stores items with ttl(timeout since last access).
+// The Get/Update refresh the access time of item.
+type Cache interface {
+	Get(key string) *CacheItem
+	Set(key string, item *CacheItem)
+	Update(key string) bool
+	Delete(key string) bool
+
+	// Range calls fn for each item until fn returns false.
+	Range(fn func(key string, item *CacheItem) bool)
+
+	// OnExpired adds a callback for expired items.
+	OnExpired(fn CacheExpiredFunc)
+
+	Close()
 }
 
-func NewCache() *Cache {
-	c := &Cache{
-		TAG:      "[CACHE]",
-		items:    make(map[string]*CacheItem),
-		exitTick: make(chan bool),
+// NewCache returns the cache backend by params, or memory cache as default.
+func NewCache(params *CacheParams) Cache {
+	switch params.Type {
+	case kCacheBolt:
+		if cache, err := NewBoltCache(params.File, params.Sweep); err == nil {
+			return cache
+		} else {
+			log.Warnln("[CACHE]", "open bolt cache failed:", err, ", use memory")
+		}
 	}
-	go c.Run()
-	return c
+	return NewMemCache(params.Sweep)
 }
 
-// default 30s
-const kDefaultCacheTimeout = 30 * 1000 // ms
-
 type CacheItem struct {
 	data    interface{} //
 	timeout int         // default(30s) if 0
@@ -49,7 +73,36 @@ func NewCacheItemEx(data interface{}, timeout int) *CacheItem {
 	}
 }
 
-func (h *Cache) Get(key string) *CacheItem {
+// MemCache is the in-memory cache.
+type MemCache struct {
+	TAG      string
+	items    map[string]*CacheItem
+	expired  []CacheExpiredFunc
+	sweep    time.Duration
+	exitTick chan bool
+
+	sync.RWMutex
+}
+
+func NewMemCache(sweep time.Duration) *MemCache {
+	return newMemCache("[CACHE]", sweep)
+}
+
+func newMemCache(tag string, sweep time.Duration) *MemCache {
+	if sweep <= 0 {
+		sweep = kDefaultCacheSweep
+	}
+	c := &MemCache{
+		TAG:      tag,
+		items:    make(map[string]*CacheItem),
+		sweep:    sweep,
+		exitTick: make(chan bool),
+	}
+	go c.Run()
+	return c
+}
+
+func (h *MemCache) Get(key string) *CacheItem {
 	h.RLock()
 	defer h.RUnlock()
 	if i, ok := h.items[key]; ok {
@@ -60,14 +113,14 @@ func (h *Cache) Get(key string) *CacheItem {
 	}
 }
 
-func (h *Cache) Set(key string, item *CacheItem) {
+func (h *MemCache) Set(key string, item *CacheItem) {
 	h.Lock()
 	defer h.Unlock()
 	item.objtime.update()
 	h.items[key] = item
 }
 
-func (h *Cache) Update(key string) bool {
+func (h *MemCache) Update(key string) bool {
 	h.Lock()
 	defer h.Unlock()
 	if i, ok := h.items[key]; ok {
@@ -78,7 +131,35 @@ func (h *Cache) Update(key string) bool {
 	}
 }
 
-func (h *Cache) ClearTimeout() {
+func (h *MemCache) Delete(key string) bool {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.items[key]; ok {
+		delete(h.items, key)
+		return true
+	} else {
+		return false
+	}
+}
+
+func (h *MemCache) Range(fn func(key string, item *CacheItem) bool) {
+	h.RLock()
+	defer h.RUnlock()
+	for k, v := range h.items {
+		if !fn(k, v) {
+			break
+		}
+	}
+}
+
+func (h *MemCache) OnExpired(fn CacheExpiredFunc) {
+	h.Lock()
+	defer h.Unlock()
+	h.expired = append(h.expired, fn)
+}
+
+// ClearTimeout removes the timeout items and calls the expired callbacks.
+func (h *MemCache) ClearTimeout() {
 	var desperated []string
 
 	h.RLock()
@@ -89,22 +170,36 @@ func (h *Cache) ClearTimeout() {
 	}
 	h.RUnlock()
 
-	if len(desperated) > 0 {
-		log.Println(h.TAG, "clear timeout, size=", len(desperated))
-		h.Lock()
-		for index := range desperated {
-			delete(h.items, desperated[index])
+	if len(desperated) == 0 {
+		return
+	}
+
+	log.Println(h.TAG, "clear timeout, size=", len(desperated))
+	expired := make(map[string]*CacheItem)
+	h.Lock()
+	for _, key := range desperated {
+		// maybe updated after checking
+		if item, ok := h.items[key]; ok && item.objtime.checkTimeout(item.timeout) {
+			expired[key] = item
+			delete(h.items, key)
+		}
+	}
+	callbacks := h.expired
+	h.Unlock()
+
+	for key, item := range expired {
+		for _, fn := range callbacks {
+			fn(key, item)
 		}
-		h.Unlock()
 	}
 }
 
-func (h *Cache) Close() {
+func (h *MemCache) Close() {
 	h.exitTick <- true
 }
 
-func (h *Cache) Run() {
-	tickChan := time.NewTicker(time.Second * 30).C
+func (h *MemCache) Run() {
+	tickChan := time.NewTicker(h.sweep).C
 	for {
 		select {
 		case <-h.exitTick:
@@ -0,0 +1,176 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/PeterXu/xrtc/util"
+	log "github.com/PeterXu/xrtc/util"
+	bolt "go.etcd.io/bbolt"
+)
+
+const kBoltCacheBucket = "cache"
+
+// the data types persisted by file-backed cache
+var gCacheTypes = struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+}{types: make(map[string]reflect.Type)}
+
+// RegisterCacheType registers the data type(pointer to struct) persisted by file-backed cache.
+// The data is encoded by json, and the other types are only kept in memory.
+func RegisterCacheType(sample interface{}) {
+	t := reflect.TypeOf(sample)
+	if t.Kind() != reflect.Ptr {
+		log.Warnln("[CACHE]", "cache type must be pointer:", t)
+		return
+	}
+	gCacheTypes.Lock()
+	defer gCacheTypes.Unlock()
+	gCacheTypes.types[t.String()] = t
+}
+
+func lookupCacheType(name string) reflect.Type {
+	gCacheTypes.RLock()
+	defer gCacheTypes.RUnlock()
+	return gCacheTypes.types[name]
+}
+
+func init() {
+	// pending registrations survive restart
+	RegisterCacheType(&RegisterRequest{})
+}
+
+// boltRecord is one persisted item.
+type boltRecord struct {
+	Type    string          `json:"type"`
+	Timeout int             `json:"timeout"`
+	Utime   uint64          `json:"utime"`
+	Ctime   uint64          `json:"ctime"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// BoltCache is a file-backed(bbolt) cache.
+// All items are in memory, and the registered types are also saved into file,
+// which are loaded when opened again. The access time in file is only updated by Set.
+type BoltCache struct {
+	*MemCache
+	db *bolt.DB
+}
+
+func NewBoltCache(fname string, sweep time.Duration) (*BoltCache, error) {
+	db, err := bolt.Open(fname, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(kBoltCacheBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	c := &BoltCache{MemCache: newMemCache("[BOLT]", sweep), db: db}
+	c.load()
+	c.MemCache.OnExpired(func(key string, item *CacheItem) {
+		c.remove(key)
+	})
+	return c, nil
+}
+
+// load restores the unexpired items from file.
+func (c *BoltCache) load() {
+	var expired [][]byte
+	now := util.NowMs64()
+
+	c.Lock()
+	defer c.Unlock()
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(kBoltCacheBucket)).ForEach(func(k, v []byte) error {
+			var record boltRecord
+			var data interface{}
+			if err := json.Unmarshal(v, &record); err == nil {
+				t := lookupCacheType(record.Type)
+				if t != nil && now < record.Utime+uint64(record.Timeout) {
+					data = reflect.New(t.Elem()).Interface()
+					if err := json.Unmarshal(record.Data, data); err != nil {
+						data = nil
+					}
+				}
+			}
+			if data == nil {
+				// k is only valid in tx
+				expired = append(expired, append([]byte(nil), k...))
+				return nil
+			}
+			c.items[string(k)] = &CacheItem{
+				data:    data,
+				timeout: record.Timeout,
+				objtime: &ObjTime{utime: record.Utime, ctime: record.Ctime},
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Warnln(c.TAG, "load failed:", err)
+	}
+	log.Println(c.TAG, "load items:", len(c.items), ", expired:", len(expired))
+
+	if len(expired) > 0 {
+		c.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte(kBoltCacheBucket))
+			for _, k := range expired {
+				bucket.Delete(k)
+			}
+			return nil
+		})
+	}
+}
+
+func (c *BoltCache) Set(key string, item *CacheItem) {
+	c.MemCache.Set(key, item)
+
+	name := reflect.TypeOf(item.data).String()
+	if lookupCacheType(name) == nil {
+		return
+	}
+	data, err := json.Marshal(item.data)
+	if err != nil {
+		log.Warnln(c.TAG, "encode failed:", key, err)
+		return
+	}
+	value, _ := json.Marshal(&boltRecord{
+		Type:    name,
+		Timeout: item.timeout,
+		Utime:   item.objtime.updateTime(),
+		Ctime:   item.objtime.ctime,
+		Data:    data,
+	})
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(kBoltCacheBucket)).Put([]byte(key), value)
+	})
+	if err != nil {
+		log.Warnln(c.TAG, "save failed:", key, err)
+	}
+}
+
+func (c *BoltCache) Delete(key string) bool {
+	ok := c.MemCache.Delete(key)
+	c.remove(key)
+	return ok
+}
+
+func (c *BoltCache) remove(key string) {
+	c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(kBoltCacheBucket)).Delete([]byte(key))
+	})
+}
+
+func (c *BoltCache) Close() {
+	c.MemCache.Close()
+	c.db.Close()
+}
@@ -0,0 +1,84 @@
+package webrtc
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemCacheExpired(t *testing.T) {
+	cache := NewMemCache(time.Hour)
+	defer cache.Close()
+
+	var expired []string
+	cache.OnExpired(func(key string, item *CacheItem) {
+		expired = append(expired, key)
+	})
+	cache.Set("short", NewCacheItemEx("a", 1))
+	cache.Set("long", NewCacheItemEx("b", 60*1000))
+	time.Sleep(5 * time.Millisecond)
+	cache.ClearTimeout()
+
+	if len(expired) != 1 || expired[0] != "short" {
+		t.Errorf("wrong expired items: %v", expired)
+	}
+	if cache.Get("short") != nil || cache.Get("long") == nil {
+		t.Errorf("wrong items after clearing")
+	}
+}
+
+func TestBoltCacheReload(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.db")
+	cache, err := NewBoltCache(fname, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &RegisterRequest{
+		SessionKey: "s1",
+		OfferIce:   SdpIceInfo{Ufrag: "offer", Pwd: "offerpwd"},
+		AnswerIce:  SdpIceInfo{Ufrag: "answer", Pwd: "answerpwd"},
+	}
+	cache.Set(request.iceKey(), NewCacheItemEx(request, 60*1000))
+	cache.Set("deleted", NewCacheItem(&RegisterRequest{SessionKey: "s2"}))
+	cache.Set("memory", NewCacheItem("not persisted"))
+	cache.Delete("deleted")
+	cache.Close()
+
+	cache, err = NewBoltCache(fname, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	item := cache.Get(request.iceKey())
+	if item == nil {
+		t.Fatal("no item after reload")
+	}
+	got := item.data.(*RegisterRequest)
+	if got.SessionKey != "s1" || got.AnswerIce.Pwd != "answerpwd" || item.timeout != 60*1000 {
+		t.Errorf("wrong item after reload: %v, %d", got, item.timeout)
+	}
+	if cache.Get("deleted") != nil || cache.Get("memory") != nil {
+		t.Errorf("unexpected items after reload")
+	}
+}
+
+func TestCacheExpiredUnreached(t *testing.T) {
+	cache := NewMemCache(time.Hour)
+	hub := NewMaxHub(cache)
+	defer hub.Close()
+
+	unreached := &RegisterRequest{SessionKey: "s1"}
+	stunned := &RegisterRequest{SessionKey: "s2"}
+	stunned.setStunned()
+	replica := &RegisterRequest{SessionKey: "s3", replica: true}
+	cache.Set("k1", NewCacheItemEx(unreached, 1))
+	cache.Set("k2", NewCacheItemEx(stunned, 1))
+	cache.Set("k3", NewCacheItemEx(replica, 1))
+	time.Sleep(5 * time.Millisecond)
+	cache.ClearTimeout()
+
+	if m := hub.Metrics().Snapshot(); m.Unreached != 1 {
+		t.Errorf("wrong unreached count: %d", m.Unreached)
+	}
+}
@@ -0,0 +1,440 @@
+package webrtc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PeterXu/xrtc/util"
+	log "github.com/PeterXu/xrtc/util"
+)
+
+// cluster(peer-forwarding) protocol between xrtc nodes
+const (
+	kClusterSessionPath    = "/cluster/session"
+	kClusterTokenHeader    = "X-Xrtc-Cluster-Token"
+	kDefaultClusterTimeout = 2 * time.Second
+	kClusterResolveGap     = 1000  // ms, min gap of resolving the same key
+	kClusterResolveRate    = 100   // max resolving per second of all keys
+	kClusterMissTtl        = 5000  // ms, skip resolving the key not found in peers
+	kClusterMissMax        = 10000 // max keys not found
+	kClusterWorkers        = 16
+	kClusterQueueSize      = 1024 // pending peer requests, dropped if full
+)
+
+var errClusterNotFound = errors.New("session not found in peers")
+var errClusterNoSecret = errors.New("no secret with peers")
+
+// SessionRegistry shares the registered sessions(ice key => RegisterRequest) between nodes,
+// so the stun of client can arrive at any node.
+type SessionRegistry interface {
+	// Publish shares one session registered at this node.
+	Publish(key string, request *RegisterRequest)
+
+	// Resolve looks up key in other nodes asynchronously,
+	// and the found session is added into local cache.
+	// It never blocks, and the invalid or too frequent keys are ignored.
+	Resolve(key string)
+
+	// Remove deletes the session(session key or ice key) in other nodes.
+	// It waits for all nodes, and returns errClusterNotFound if none has it.
+	Remove(key string) error
+
+	// Claim tells other nodes the session(ice key) is served by this node,
+	// and their copies are dropped silently. It never blocks.
+	Claim(key string)
+
+	Close()
+}
+
+// NewSessionRegistry returns nil if cluster is disabled or no secret.
+// The onRemove is called when other node removes one session.
+func NewSessionRegistry(params *ClusterParams, cache Cache, onRemove func(key string) error) SessionRegistry {
+	if len(params.Addr) == 0 || len(params.Peers) == 0 {
+		return nil
+	}
+	if len(params.Secret) == 0 {
+		log.Warnln("[CLUSTER]", "disabled:", errClusterNoSecret)
+		return nil
+	}
+	reg := NewPeerRegistry(params, cache, onRemove)
+	if err := reg.Start(); err != nil {
+		log.Warnln(reg.TAG, "start failed:", err)
+		return nil
+	}
+	return reg
+}
+
+// ClusterSession is the message of one session between nodes.
+// The replica expires with the remaining lifetime of registration.
+type ClusterSession struct {
+	Node     string           `json:"node"`
+	Key      string           `json:"key"`
+	Request  *RegisterRequest `json:"request"`
+	Lifetime int              `json:"lifetime"` // ms, remaining ttl
+}
+
+// addReplica caches the session from other node, returns false if invalid or expired.
+func (s *ClusterSession) addReplica(cache Cache) bool {
+	if len(s.Key) == 0 || s.Request == nil || s.Lifetime <= 0 {
+		return false
+	}
+	s.Request.replica = true
+	s.Request.setLifetime(s.Lifetime)
+	cache.Set(s.Key, NewCacheItemEx(s.Request, s.Lifetime))
+	return true
+}
+
+// PeerRegistry is a SessionRegistry over http between peers:
+//
+//	POST   /cluster/session       - publish one session
+//	GET    /cluster/session/{key} - resolve one session in local cache
+//	DELETE /cluster/session/{key} - remove one session
+//	DELETE /cluster/session/{key}?claim=1 - drop the copy of one session
+type PeerRegistry struct {
+	TAG      string
+	params   ClusterParams
+	cache    Cache
+	onRemove func(key string) error
+	client   *http.Client
+	queue    *util.TaskQueue
+	server   *http.Server
+
+	// resolving keys => start time
+	resolving map[string]uint64
+	// keys not found => expired time
+	missed map[string]uint64
+	// resolving count in current second
+	rateTime  uint64
+	rateCount int
+	sync.Mutex
+}
+
+func NewPeerRegistry(params *ClusterParams, cache Cache, onRemove func(key string) error) *PeerRegistry {
+	timeout := params.Timeout
+	if timeout <= 0 {
+		timeout = kDefaultClusterTimeout
+	}
+	return &PeerRegistry{
+		TAG:       "[CLUSTER]",
+		params:    *params,
+		cache:     cache,
+		onRemove:  onRemove,
+		client:    &http.Client{Timeout: timeout},
+		queue:     util.NewTaskQueue(kClusterWorkers, kClusterQueueSize),
+		resolving: make(map[string]uint64),
+		missed:    make(map[string]uint64),
+	}
+}
+
+// Start listens on cluster addr for peers.
+func (r *PeerRegistry) Start() error {
+	if len(r.params.Secret) == 0 {
+		return errClusterNoSecret
+	}
+	ln, err := net.Listen("tcp", r.params.Addr)
+	if err != nil {
+		return err
+	}
+	r.Serve(ln)
+	return nil
+}
+
+// Serve serves peers on ln in background.
+func (r *PeerRegistry) Serve(ln net.Listener) {
+	log.Println(r.TAG, "node:", r.params.Name, ", listen:", ln.Addr(), ", peers:", r.params.Peers)
+	r.server = &http.Server{Handler: r}
+	go r.server.Serve(ln)
+}
+
+func (r *PeerRegistry) Close() {
+	if r.server != nil {
+		r.server.Close()
+	}
+	r.queue.Close()
+}
+
+// post runs task in background, and drops it if too many pending.
+func (r *PeerRegistry) post(name string, task func()) bool {
+	if !r.queue.Post(task) {
+		log.Warnln(r.TAG, "queue full, drop", name)
+		return false
+	}
+	return true
+}
+
+func (r *PeerRegistry) Publish(key string, request *RegisterRequest) {
+	body, err := json.Marshal(&ClusterSession{r.params.Name, key, request, request.lifetime()})
+	if err != nil {
+		log.Warnln(r.TAG, "publish marshal err:", err)
+		return
+	}
+	for _, peer := range r.params.Peers {
+		peer := peer
+		r.post("publish "+key, func() {
+			if _, err := r.send(http.MethodPost, peer, "", body); err != nil {
+				log.Warnln(r.TAG, "publish to", peer, "err:", err)
+			}
+		})
+	}
+}
+
+func (r *PeerRegistry) Resolve(key string) {
+	if !isValidIceKey(key) {
+		log.Warnln(r.TAG, "resolve invalid key:", key)
+		return
+	}
+
+	now := util.NowMs64()
+	r.Lock()
+	if !r.checkResolve(key, now) {
+		r.Unlock()
+		return
+	}
+	r.resolving[key] = now
+	r.Unlock()
+
+	done := func(err error) {
+		r.Lock()
+		delete(r.resolving, key)
+		if err == errClusterNotFound {
+			r.addMissed(key, util.NowMs64())
+		}
+		r.Unlock()
+	}
+	ok := r.post("resolve "+key, func() {
+		err := r.resolve(key)
+		if err != nil {
+			log.Warnln(r.TAG, "resolve", key, "err:", err)
+		}
+		done(err)
+	})
+	if !ok {
+		done(nil)
+	}
+}
+
+// checkResolve checks the repeated, missed and rate limit of resolving key(locked).
+func (r *PeerRegistry) checkResolve(key string, now uint64) bool {
+	if start, ok := r.resolving[key]; ok && now < start+kClusterResolveGap {
+		return false
+	}
+	if expired, ok := r.missed[key]; ok {
+		if now < expired {
+			return false
+		}
+		delete(r.missed, key)
+	}
+	if now >= r.rateTime+1000 {
+		r.rateTime = now
+		r.rateCount = 0
+	}
+	if r.rateCount >= kClusterResolveRate {
+		log.Warnln(r.TAG, "resolve rate limited:", key)
+		return false
+	}
+	r.rateCount += 1
+	return true
+}
+
+// addMissed caches the key not found(locked).
+func (r *PeerRegistry) addMissed(key string, now uint64) {
+	if len(r.missed) >= kClusterMissMax {
+		for k, expired := range r.missed {
+			if now >= expired {
+				delete(r.missed, k)
+			}
+		}
+		if len(r.missed) >= kClusterMissMax {
+			r.missed = make(map[string]uint64)
+		}
+	}
+	r.missed[key] = now + kClusterMissTtl
+}
+
+// isValidIceKey checks "answer_ufrag:offer_ufrag", each is 4-256 ice-chars(RFC 8839).
+func isValidIceKey(key string) bool {
+	items := strings.Split(key, ":")
+	return len(items) == 2 && util.IsIceUfrag(items[0]) && util.IsIceUfrag(items[1])
+}
+
+// resolve queries peers one by one until found.
+func (r *PeerRegistry) resolve(key string) error {
+	for _, peer := range r.params.Peers {
+		body, err := r.send(http.MethodGet, peer, url.PathEscape(key), nil)
+		if err != nil || len(body) == 0 {
+			continue
+		}
+		var session ClusterSession
+		if err := json.Unmarshal(body, &session); err != nil {
+			continue
+		}
+		session.Key = key
+		if !session.addReplica(r.cache) {
+			continue
+		}
+		log.Println(r.TAG, "resolve", key, "from node:", session.Node)
+		return nil
+	}
+	return errClusterNotFound
+}
+
+func (r *PeerRegistry) Remove(key string) error {
+	path := url.PathEscape(key)
+	var wg sync.WaitGroup
+	var found int32
+	for _, peer := range r.params.Peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			body, err := r.send(http.MethodDelete, peer, path, nil)
+			if err != nil {
+				log.Warnln(r.TAG, "delete", path, "from", peer, "err:", err)
+			} else if body != nil {
+				atomic.StoreInt32(&found, 1)
+			}
+		}(peer)
+	}
+	wg.Wait()
+	if found == 0 {
+		return errClusterNotFound
+	}
+	return nil
+}
+
+func (r *PeerRegistry) Claim(key string) {
+	r.broadcastDelete(key, true)
+}
+
+func (r *PeerRegistry) broadcastDelete(key string, claim bool) {
+	path := url.PathEscape(key)
+	if claim {
+		path += "?claim=1"
+	}
+	for _, peer := range r.params.Peers {
+		peer := peer
+		r.post("claim "+path, func() {
+			if _, err := r.send(http.MethodDelete, peer, path, nil); err != nil {
+				log.Warnln(r.TAG, "delete", path, "from", peer, "err:", err)
+			}
+		})
+	}
+}
+
+// send requests one peer(path is escaped key with query), and returns body for 200 or nil for 404.
+func (r *PeerRegistry) send(method, peer, path string, body []byte) ([]byte, error) {
+	uri := peer
+	if !strings.Contains(uri, "://") {
+		uri = "http://" + uri
+	}
+	uri = strings.TrimRight(uri, "/") + kClusterSessionPath
+	if len(path) > 0 {
+		uri += "/" + path
+	}
+
+	req, err := http.NewRequest(method, uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(r.params.Secret) > 0 {
+		req.Header.Set(kClusterTokenHeader, r.params.Secret)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	rbody, err := util.ReadHttpBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if rbody == nil && err == nil {
+			rbody = []byte{} // not nil for 200
+		}
+		return rbody, err
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, errors.New("peer status: " + resp.Status)
+	}
+}
+
+// ServeHTTP serves the requests from peers.
+// All requests are forbidden without secret.
+func (r *PeerRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	token := req.Header.Get(kClusterTokenHeader)
+	if len(r.params.Secret) == 0 || !hmac.Equal([]byte(token), []byte(r.params.Secret)) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if !strings.HasPrefix(req.URL.Path, kClusterSessionPath) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := strings.Trim(strings.TrimPrefix(req.URL.Path, kClusterSessionPath), "/")
+
+	switch req.Method {
+	case http.MethodPost:
+		body, err := util.ReadHttpBody(req.Body, req.Header.Get("Content-Encoding"))
+		var session ClusterSession
+		if err == nil {
+			err = json.Unmarshal(body, &session)
+		}
+		if err != nil || !session.addReplica(r.cache) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		log.Println(r.TAG, "session", session.Key, "from node:", session.Node)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		item := r.cache.Get(key)
+		if item == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		request, ok := item.data.(*RegisterRequest)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		lifetime := request.lifetime()
+		if lifetime <= 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := json.Marshal(&ClusterSession{r.params.Name, key, request, lifetime})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	case http.MethodDelete:
+		if req.URL.Query().Get("claim") == "1" {
+			log.Println(r.TAG, "claimed session", key)
+			r.cache.Delete(key)
+		} else if r.onRemove != nil {
+			log.Println(r.TAG, "remove session", key)
+			if err := r.onRemove(key); err != nil {
+				log.Println(r.TAG, "remove session", key, "err:", err)
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		} else {
+			if r.cache.Get(key) == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			r.cache.Delete(key)
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
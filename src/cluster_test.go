@@ -0,0 +1,265 @@
+package webrtc
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testClusterNode struct {
+	cache    Cache
+	registry *PeerRegistry
+	removed  chan string
+}
+
+// newTestCluster starts nodes on localhost, each node has all others as peers.
+func newTestCluster(t *testing.T, count int) []*testClusterNode {
+	var lns []net.Listener
+	var addrs []string
+	for i := 0; i < count; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns = append(lns, ln)
+		addrs = append(addrs, ln.Addr().String())
+	}
+
+	var nodes []*testClusterNode
+	for i := 0; i < count; i++ {
+		params := &ClusterParams{Name: addrs[i], Addr: addrs[i], Secret: "secret"}
+		for j := 0; j < count; j++ {
+			if j != i {
+				params.Peers = append(params.Peers, addrs[j])
+			}
+		}
+		node := &testClusterNode{cache: NewMemCache(0), removed: make(chan string, 10)}
+		node.registry = NewPeerRegistry(params, node.cache, func(key string) error {
+			node.removed <- key
+			found := false
+			node.cache.Range(func(k string, item *CacheItem) bool {
+				request := item.data.(*RegisterRequest)
+				found = (k == key || request.SessionKey == key)
+				return !found
+			})
+			if !found {
+				return errors.New("no session for " + key)
+			}
+			return nil
+		})
+		node.registry.Serve(lns[i])
+		nodes = append(nodes, node)
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.registry.Close()
+			node.cache.Close()
+		}
+	})
+	return nodes
+}
+
+func waitCacheItem(cache Cache, key string) *RegisterRequest {
+	for i := 0; i < 100; i++ {
+		if item := cache.Get(key); item != nil {
+			return item.data.(*RegisterRequest)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestClusterPublishResolve(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	request := &RegisterRequest{
+		SessionKey: "s1",
+		OfferIce:   SdpIceInfo{Ufrag: "offer", Pwd: "offerpwd"},
+		AnswerIce:  SdpIceInfo{Ufrag: "answer", Pwd: "answerpwd"},
+		Candidates: []string{"a=candidate:1 1 udp 2013266431 127.0.0.1 5000 typ host"},
+	}
+
+	// published from node0 to others
+	request.setLifetime(20 * 1000)
+	nodes[0].cache.Set(request.iceKey(), NewCacheItem(request))
+	nodes[0].registry.Publish(request.iceKey(), request)
+	for i := 1; i < len(nodes); i++ {
+		got := waitCacheItem(nodes[i].cache, request.iceKey())
+		if got == nil || got.SessionKey != "s1" || got.AnswerIce.Pwd != "answerpwd" {
+			t.Errorf("node%d: wrong published session: %v", i, got)
+		}
+	}
+
+	// resolved from node1 only
+	request2 := &RegisterRequest{SessionKey: "s2", OfferIce: SdpIceInfo{Ufrag: "offer2"}, AnswerIce: SdpIceInfo{Ufrag: "answer2"}}
+	request2.setLifetime(10 * 1000)
+	nodes[1].cache.Set(request2.iceKey(), NewCacheItem(request2))
+	nodes[2].registry.Resolve(request2.iceKey())
+	if got := waitCacheItem(nodes[2].cache, request2.iceKey()); got == nil || got.SessionKey != "s2" {
+		t.Errorf("wrong resolved session: %v", got)
+	}
+
+	// the replicas keep the remaining lifetime, not restarted by each hop
+	for i, node := range nodes[1:] {
+		item := node.cache.Get(request.iceKey())
+		got := item.data.(*RegisterRequest)
+		if item.timeout > 20*1000 || got.lifetime() > 20*1000 || !got.replica {
+			t.Errorf("node%d: wrong replica lifetime: %d, %d", i+1, item.timeout, got.lifetime())
+		}
+	}
+	if item := nodes[2].cache.Get(request2.iceKey()); item.timeout > 10*1000 {
+		t.Errorf("wrong resolved lifetime: %d", item.timeout)
+	}
+
+	// the expired session is not served
+	expired := &RegisterRequest{SessionKey: "s3", OfferIce: SdpIceInfo{Ufrag: "offer3"}, AnswerIce: SdpIceInfo{Ufrag: "answer3"}}
+	expired.expires = 1
+	nodes[1].cache.Set(expired.iceKey(), NewCacheItem(expired))
+	if err := nodes[2].registry.resolve(expired.iceKey()); err != errClusterNotFound {
+		t.Errorf("expect expired session not found, got %v", err)
+	}
+
+	// removed in others
+	if err := nodes[0].registry.Remove("s1"); err != nil {
+		t.Errorf("remove err: %v", err)
+	}
+	for i := 1; i < len(nodes); i++ {
+		select {
+		case key := <-nodes[i].removed:
+			if key != "s1" {
+				t.Errorf("node%d: wrong removed key: %s", i, key)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("node%d: not removed", i)
+		}
+	}
+	if err := nodes[0].registry.Remove("none"); err != errClusterNotFound {
+		t.Errorf("expect not found in peers, got %v", err)
+	}
+}
+
+func TestClusterSecret(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+	nodes[1].registry.params.Secret = "wrong"
+	request := &RegisterRequest{SessionKey: "s1", OfferIce: SdpIceInfo{Ufrag: "o"}, AnswerIce: SdpIceInfo{Ufrag: "a"}}
+	if _, err := nodes[1].registry.send("POST", nodes[0].registry.params.Addr, "", []byte("{}")); err == nil {
+		t.Errorf("expect forbidden with wrong secret")
+	}
+	nodes[1].cache.Set(request.iceKey(), NewCacheItem(request))
+	if err := nodes[0].registry.resolve(request.iceKey()); err != errClusterNotFound {
+		t.Errorf("expect not found with wrong secret, got %v", err)
+	}
+}
+
+func TestClusterNoSecret(t *testing.T) {
+	params := &ClusterParams{Name: "n1", Addr: "127.0.0.1:0", Peers: []string{"127.0.0.1:1"}}
+	if reg := NewSessionRegistry(params, NewMemCache(0), nil); reg != nil {
+		reg.Close()
+		t.Fatal("cluster should be disabled without secret")
+	}
+
+	// all requests forbidden without secret
+	reg := NewPeerRegistry(params, NewMemCache(0), nil)
+	defer reg.Close()
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest(http.MethodGet, kClusterSessionPath+"/answer:offer", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("wrong status without secret: %d", w.Code)
+	}
+}
+
+func TestClusterResolveLimit(t *testing.T) {
+	var gets int32
+	hang := make(chan struct{})
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&gets, 1) > 1 {
+			<-hang
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer peer.Close()
+	defer close(hang)
+
+	cache := NewMemCache(0)
+	defer cache.Close()
+	params := &ClusterParams{Name: "n1", Peers: []string{peer.URL}, Secret: "secret", Timeout: time.Minute}
+	reg := NewPeerRegistry(params, cache, nil)
+	defer reg.Close()
+
+	// malformed keys are never resolved
+	for _, key := range []string{"", "abc", "answer", "ans:offer", "answer:offer:x", "answer:off\\er"} {
+		reg.Resolve(key)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&gets); n != 0 {
+		t.Fatalf("malformed keys resolved: %d", n)
+	}
+
+	// the missed key is cached
+	reg.Resolve("answer:offer")
+	for i := 0; i < 100 && atomic.LoadInt32(&gets) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		reg.Lock()
+		_, missed := reg.missed["answer:offer"]
+		reg.Unlock()
+		if missed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	reg.Resolve("answer:offer")
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&gets); n != 1 {
+		t.Errorf("missed key resolved again: %d", n)
+	}
+
+	// the peer hangs, but Resolve never blocks and is rate limited
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < kClusterWorkers+kClusterQueueSize+100; i++ {
+			reg.Resolve("answer" + strconv.Itoa(i) + ":offer")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("resolve blocked")
+	}
+	if n := atomic.LoadInt32(&gets); n > kClusterResolveRate+1 {
+		t.Errorf("resolve not rate limited: %d", n)
+	}
+}
+
+func TestClusterClaimNonBlocking(t *testing.T) {
+	hang := make(chan struct{})
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer peer.Close()
+	defer close(hang)
+
+	params := &ClusterParams{Name: "n1", Peers: []string{peer.URL}, Secret: "secret", Timeout: time.Minute}
+	reg := NewPeerRegistry(params, NewMemCache(0), nil)
+	defer reg.Close()
+
+	// called on hub loop for the first stun of each session
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < kClusterWorkers+kClusterQueueSize+100; i++ {
+			reg.Claim("answer" + strconv.Itoa(i) + ":offer")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("claim blocked")
+	}
+}
@@ -3,7 +3,9 @@ package webrtc
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/PeterXu/xrtc/util"
 	log "github.com/PeterXu/xrtc/util"
@@ -18,16 +20,29 @@ const (
 	kTlsCrtFile        = "/tmp/etc/cert.pem"
 	kTlsKeyFile        = "/tmp/etc/cert.key"
 	kGeoLite2File      = "/tmp/etc/GeoLite2-City.mmdb"
+	kGeoLite2AsnFile   = "/tmp/etc/GeoLite2-ASN.mmdb"
+	kCacheBoltFile     = "/tmp/etc/xrtc-cache.db"
 	kCandidateIpMark   = "candidate_host_ip"
+	kCandidateIpv6Mark = "candidate_host_ipv6"
+	kMinRegisterTtl    = 5 * time.Second
+	kMaxRegisterTtl    = 10 * time.Minute
 )
 
 // Config contains all services(udp/tcp/http)
 type Config struct {
-	Servers []*NetConfig
+	Servers  []*NetConfig
+	Webhook  WebhookParams
+	Backends BackendParams
+	Cluster  ClusterParams
+	Cache    CacheParams
+	Routing  RoutingParams
+	GeoIP    GeoIPParams
+	Turn     TurnParams
+	Proxies  []*ProxyNodeParams // peer nodes in other regions
 }
 
 func NewConfig() *Config {
-	return &Config{}
+	return &Config{Routing: kDefaultRoutingParams, GeoIP: kDefaultGeoIPParams, Turn: kDefaultTurnParams}
 }
 
 // Load loads all service from config file.
@@ -49,6 +64,56 @@ func (c *Config) Load(fname string) bool {
 			log.Error(uTAG, "check services, err=", err)
 			return false
 		}
+
+		// Check webhook (optional)
+		if webhook, err := yaml.ToMap(root.Key("webhook")); err == nil {
+			c.Webhook.Load(webhook)
+		}
+
+		// Check backends (optional)
+		if backends, err := yaml.ToMap(root.Key("backends")); err == nil {
+			c.Backends.Load(backends)
+		}
+
+		// Check routing (optional)
+		if routing, err := yaml.ToMap(root.Key("routing")); err == nil {
+			c.Routing.Load(routing)
+		}
+
+		// Check geoip (optional)
+		if geoip, err := yaml.ToMap(root.Key("geoip")); err == nil {
+			c.GeoIP.Load(geoip)
+		}
+
+		// Check cache (optional)
+		if cache, err := yaml.ToMap(root.Key("cache")); err == nil {
+			c.Cache.Load(cache)
+		}
+
+		// Check cluster (optional)
+		if cluster, err := yaml.ToMap(root.Key("cluster")); err == nil {
+			c.Cluster.Load(cluster)
+		}
+
+		// Check turn (optional)
+		if turn, err := yaml.ToMap(root.Key("turn")); err == nil {
+			c.Turn.Load(turn)
+		}
+
+		// Check proxies (optional)
+		if proxies, err := yaml.ToList(root.Key("proxies")); err == nil {
+			for idx, item := range proxies {
+				node, err := yaml.ToMap(item)
+				if err != nil {
+					log.Warnln(uTAG, "check proxy node, err=", err)
+					continue
+				}
+				var pp ProxyNodeParams
+				if pp.Load(node, idx) {
+					c.Proxies = append(c.Proxies, &pp)
+				}
+			}
+		}
 	}
 
 	// Check services
@@ -113,7 +178,14 @@ type NetParams struct {
 	TlsCrtFile string   // crt file
 	TlsKeyFile string   // key file
 	EnableIce  bool     // enable ice
+	EnableTurn bool     // enable turn on the ice port(check EnableIce)
 	Candidates []string // ice candidates(check EnableIce)
+	Regions    []string // region tag of each candidate, "" for default
+
+	// plain stun server for non-session clients(check EnableIce)
+	EnableStun       bool
+	StunOrigin       bool   // add RESPONSE-ORIGIN
+	StunOtherAddress string // add OTHER-ADDRESS if set, "ip:port"
 }
 
 // Load the "net:" parameters under one service.
@@ -123,6 +195,10 @@ func (n *NetParams) Load(node yaml.Map, proto string) {
 	n.TlsKeyFile = yaml.ToString(node.Key("tls_key_file"))
 
 	n.EnableIce = (yaml.ToString(node.Key("enable_ice")) == "true")
+	n.EnableTurn = n.EnableIce && (yaml.ToString(node.Key("enable_turn")) == "true")
+	n.EnableStun = n.EnableIce && (yaml.ToString(node.Key("enable_stun")) == "true")
+	n.StunOrigin = (yaml.ToString(node.Key("stun_response_origin")) == "true")
+	n.StunOtherAddress = yaml.ToString(node.Key("stun_other_address"))
 	for n.EnableIce {
 		var port string
 		var err error
@@ -135,35 +211,55 @@ func (n *NetParams) Load(node yaml.Map, proto string) {
 			break
 		}
 		for idx, ip := range ips {
+			// "ip" or {ip: "ip", region: "tag"}
 			szip0 := yaml.ToString(ip)
+			region := ""
+			if item, err := yaml.ToMap(ip); err == nil {
+				szip0 = yaml.ToString(item.Key("ip"))
+				region = yaml.ToString(item.Key("region"))
+			}
 			if len(szip0) == 0 {
 				continue
 			}
 			var szip string
-			if szip0 == kCandidateIpMark {
+			switch szip0 {
+			case kCandidateIpMark:
 				szip = util.LocalIPString()
-			} else {
+			case kCandidateIpv6Mark:
+				szip = util.LocalIPv6String()
+			default:
 				szip = util.LookupIP(szip0)
 			}
-			log.Println(uTAG, "net candidate_ip: ", szip0, szip)
-
-			var candidate string
-			if proto == "udp" {
-				candidate = fmt.Sprintf("a=candidate:%d 1 udp 2013266431 %s %s typ host",
-					(idx + 1), szip, port)
-			} else if proto == "tcp" {
-				candidate = fmt.Sprintf("a=candidate:%d 1 tcp 1010827775 %s %s typ host tcptype passive",
-					(idx + 1), szip, port)
-			} else {
+			log.Println(uTAG, "net candidate_ip: ", szip0, szip, region)
+			if len(szip) == 0 {
 				continue
 			}
-			n.Candidates = append(n.Candidates, candidate)
+
+			if candidate := makeHostCandidate(idx+1, proto, szip, port); len(candidate) > 0 {
+				n.Candidates = append(n.Candidates, candidate)
+				n.Regions = append(n.Regions, region)
+			}
 		}
 		break
 	}
 	log.Println(uTAG, "net params:", n)
 }
 
+// makeHostCandidate returns a host candidate of udp/tcp, or "" for other proto.
+func makeHostCandidate(foundation int, proto, ip, port string) string {
+	cand := util.NewCandidate(util.Itoa(foundation), 1, proto, 0, ip, util.Atoi(port), util.CandidateHost)
+	switch proto {
+	case "udp":
+		cand.Priority = 2013266431
+	case "tcp":
+		cand.Priority = 1010827775
+		cand.TcpType = "passive"
+	default:
+		return ""
+	}
+	return cand.SdpLine()
+}
+
 /// net config
 
 type NetConfig struct {
@@ -197,13 +293,31 @@ func NewHTTPConfig(name string, netp yaml.Map) *NetConfig {
 /// HttpParams
 
 type HttpParams struct {
-	Servername string // server name
-	Root       string // static root dir
-	RequestID  string
+	Servername   string // server name
+	Root         string // static root dir
+	RequestID    string
+	WhipUpstream string // upstream WHIP endpoint url
+	WhepUpstream string // upstream WHEP endpoint url
+
+	JanusUpstream   string // upstream janus rest api url
+	JanusWsUpstream string // upstream janus websocket url
+
+	ProxyMode string // auto/always/never
+	IceLite   bool   // ice-lite to client(RFC 8445), only respond to checks
+
+	SdpPolicy *SdpPolicyParams // munging of passing sdp, nil for none
+
+	RegisterTtl    time.Duration // default ttl of registration
+	MinRegisterTtl time.Duration // min ttl from request
+	MaxRegisterTtl time.Duration // max ttl from request
 }
 
 var kDefaultHttpParams = HttpParams{
-	RequestID: "X-Request-Id",
+	RequestID:      "X-Request-Id",
+	ProxyMode:      kProxyAuto,
+	RegisterTtl:    kDefaultCacheTimeout * time.Millisecond,
+	MinRegisterTtl: kMinRegisterTtl,
+	MaxRegisterTtl: kMaxRegisterTtl,
 }
 
 // Load loads the http parameters(routes/..) under a service.
@@ -218,5 +332,456 @@ func (h *HttpParams) Load(node yaml.Map) {
 		h.Root = kDefaultServerRoot
 	}
 
+	h.WhipUpstream = yaml.ToString(node.Key("whip_upstream"))
+	h.WhepUpstream = yaml.ToString(node.Key("whep_upstream"))
+	h.JanusUpstream = yaml.ToString(node.Key("janus_upstream"))
+	h.JanusWsUpstream = yaml.ToString(node.Key("janus_ws_upstream"))
+
+	switch mode := yaml.ToString(node.Key("proxy")); mode {
+	case kProxyAuto, kProxyAlways, kProxyNever:
+		h.ProxyMode = mode
+	case "":
+	default:
+		log.Warnln(uTAG, "invalid http proxy mode:", mode)
+	}
+
+	h.IceLite = (yaml.ToString(node.Key("ice_lite")) == "true")
+	if policy, err := yaml.ToMap(node.Key("sdp_policy")); err == nil {
+		h.SdpPolicy = &SdpPolicyParams{}
+		h.SdpPolicy.Load(policy)
+	}
+
+	h.RegisterTtl = yaml.ToDuration(node.Key("register_ttl"), h.RegisterTtl)
+	h.MinRegisterTtl = yaml.ToDuration(node.Key("min_register_ttl"), h.MinRegisterTtl)
+	h.MaxRegisterTtl = yaml.ToDuration(node.Key("max_register_ttl"), h.MaxRegisterTtl)
+	if h.MaxRegisterTtl < h.MinRegisterTtl {
+		h.MaxRegisterTtl = h.MinRegisterTtl
+	}
+
 	log.Println(uTAG, "http parameters:", h)
 }
+
+/// SdpPolicyParams
+
+type SdpPolicyParams struct {
+	AudioCodecs    []string // allowed audio codecs in order, empty for any
+	VideoCodecs    []string // allowed video codecs in order(rtx follows its codec)
+	StripBandwidth bool     // remove all b= lines
+	AudioBandwidth int      // b=AS(kbps) of audio, 0 for unchanged
+	VideoBandwidth int      // b=AS(kbps) of video, 0 for unchanged
+	Tias           bool     // also add b=TIAS(bps)
+	Extmaps        []string // allowed header extensions(uri), empty for any
+	StripExtmaps   []string // removed header extensions(uri)
+	RtcpMux        bool     // force a=rtcp-mux
+	Bundle         bool     // force a=group:BUNDLE of all mids
+}
+
+// Load loads the "sdp_policy:" parameters under http.
+func (s *SdpPolicyParams) Load(node yaml.Map) {
+	s.AudioCodecs = loadStrings(node.Key("audio_codecs"))
+	s.VideoCodecs = loadStrings(node.Key("video_codecs"))
+	s.StripBandwidth = (yaml.ToString(node.Key("strip_bandwidth")) == "true")
+	s.AudioBandwidth = yaml.ToInt(node.Key("audio_bandwidth"), 0)
+	s.VideoBandwidth = yaml.ToInt(node.Key("video_bandwidth"), 0)
+	s.Tias = (yaml.ToString(node.Key("tias")) == "true")
+	s.Extmaps = loadStrings(node.Key("extmaps"))
+	s.StripExtmaps = loadStrings(node.Key("strip_extmaps"))
+	s.RtcpMux = (yaml.ToString(node.Key("rtcp_mux")) == "true")
+	s.Bundle = (yaml.ToString(node.Key("bundle")) == "true")
+	log.Println(uTAG, "sdp policy parameters:", s)
+}
+
+// loadStrings returns the non-empty strings of one list.
+func loadStrings(node yaml.Node) []string {
+	var items []string
+	if list, err := yaml.ToList(node); err == nil {
+		for _, item := range list {
+			if szitem := yaml.ToString(item); len(szitem) > 0 {
+				items = append(items, szitem)
+			}
+		}
+	}
+	return items
+}
+
+/// WebhookParams
+
+type WebhookParams struct {
+	Urls    []string      // receivers of session events
+	Secret  string        // key of hmac-sha256 signature
+	Retries int           // retry times when failed
+	Timeout time.Duration // timeout of one http post
+}
+
+// Load loads the "webhook:" parameters under root.
+func (w *WebhookParams) Load(node yaml.Map) {
+	if urls, err := yaml.ToList(node.Key("urls")); err == nil {
+		for _, url := range urls {
+			if szurl := yaml.ToString(url); len(szurl) > 0 {
+				w.Urls = append(w.Urls, szurl)
+			}
+		}
+	}
+	w.Secret = yaml.ToString(node.Key("secret"))
+	w.Retries = yaml.ToInt(node.Key("retries"), kDefaultWebhookRetries)
+	w.Timeout = yaml.ToDuration(node.Key("timeout"), kDefaultWebhookTimeout)
+	log.Println(uTAG, "webhook parameters:", w.Urls, w.Retries, w.Timeout)
+}
+
+/// BackendParams
+
+type BackendParams struct {
+	Strategy string                 // round_robin/least_sessions/room_hash
+	Servers  []*BackendServerParams // webrtc servers
+	Health   HealthParams           // active health checking
+}
+
+// Load loads the "backends:" parameters under root.
+func (b *BackendParams) Load(node yaml.Map) {
+	b.Strategy = yaml.ToString(node.Key("strategy"))
+	if len(b.Strategy) == 0 {
+		b.Strategy = kBackendRoundRobin
+	}
+
+	b.Health = kDefaultHealthParams
+	if health, err := yaml.ToMap(node.Key("health")); err == nil {
+		b.Health.Load(health)
+	}
+
+	servers, err := yaml.ToList(node.Key("servers"))
+	if err != nil {
+		log.Warnln(uTAG, "check backend servers, err=", err)
+		return
+	}
+	for idx, item := range servers {
+		server, err := yaml.ToMap(item)
+		if err != nil {
+			log.Warnln(uTAG, "check backend server, err=", err)
+			continue
+		}
+		var sp BackendServerParams
+		if sp.Load(server, idx) {
+			b.Servers = append(b.Servers, &sp)
+		}
+	}
+	log.Println(uTAG, "backend parameters:", b.Strategy, len(b.Servers))
+}
+
+type BackendServerParams struct {
+	Name       string   // unique name
+	Weight     int      // default 1
+	Capacity   int      // max sessions, 0 for unlimited
+	Candidates []string // ice candidates of server
+	HealthUrl  string   // optional http health url(2xx for ok)
+}
+
+// Load loads one server of backends, from "addr"(with proto) or "candidates".
+func (s *BackendServerParams) Load(node yaml.Map, idx int) bool {
+	s.Name = yaml.ToString(node.Key("name"))
+	if len(s.Name) == 0 {
+		s.Name = fmt.Sprintf("backend%d", idx+1)
+	}
+	s.Weight = yaml.ToInt(node.Key("weight"), 1)
+	if s.Weight <= 0 {
+		s.Weight = 1
+	}
+	s.Capacity = yaml.ToInt(node.Key("capacity"), 0)
+	s.HealthUrl = yaml.ToString(node.Key("health_url"))
+
+	s.Candidates = loadCandidates(node)
+	if len(s.Candidates) == 0 {
+		log.Warnln(uTAG, "no candidates for backend:", s.Name)
+		return false
+	}
+	return true
+}
+
+// loadCandidates loads ice candidates from "addr"(with proto) or "candidates".
+func loadCandidates(node yaml.Map) []string {
+	var candidates []string
+	if addr := yaml.ToString(node.Key("addr")); len(addr) > 0 {
+		proto := strings.ToLower(yaml.ToString(node.Key("proto")))
+		if len(proto) == 0 {
+			proto = "udp"
+		}
+		if host, port, err := net.SplitHostPort(addr); err != nil {
+			log.Warnln(uTAG, "wrong candidate addr:", addr, err)
+		} else if candidate := makeHostCandidate(1, proto, util.LookupIP(host), port); len(candidate) > 0 {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if items, err := yaml.ToList(node.Key("candidates")); err == nil {
+		for _, item := range items {
+			if candidate := yaml.ToString(item); len(candidate) > 0 {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	return candidates
+}
+
+/// ProxyNodeParams
+
+// ProxyNodeParams is one peer xrtc node in other region.
+type ProxyNodeParams struct {
+	Name       string   // unique name
+	Region     string   // region tag
+	Candidates []string // ice candidates of peer node
+}
+
+// Load loads one node of "proxies:", from "addr"(with proto) or "candidates".
+func (p *ProxyNodeParams) Load(node yaml.Map, idx int) bool {
+	p.Name = yaml.ToString(node.Key("name"))
+	if len(p.Name) == 0 {
+		p.Name = fmt.Sprintf("proxy%d", idx+1)
+	}
+	p.Region = yaml.ToString(node.Key("region"))
+	p.Candidates = loadCandidates(node)
+	if len(p.Candidates) == 0 {
+		log.Warnln(uTAG, "no candidates for proxy:", p.Name)
+		return false
+	}
+	return true
+}
+
+/// HealthParams
+
+type HealthParams struct {
+	Enable   bool          // default true
+	Interval time.Duration // check interval
+	Timeout  time.Duration // timeout of one check
+	Fails    int           // consecutive failures to be unhealthy
+	Rises    int           // consecutive successes to be healthy
+	Stun     bool          // stun check for udp candidates, or skipped
+}
+
+var kDefaultHealthParams = HealthParams{
+	Enable:   true,
+	Interval: kDefaultHealthInterval,
+	Timeout:  kDefaultHealthTimeout,
+	Fails:    kDefaultHealthFails,
+	Rises:    kDefaultHealthRises,
+	Stun:     true,
+}
+
+// Load loads the "health:" parameters under backends.
+func (h *HealthParams) Load(node yaml.Map) {
+	h.Enable = (yaml.ToString(node.Key("enable")) != "false")
+	h.Interval = yaml.ToDuration(node.Key("interval"), kDefaultHealthInterval)
+	h.Timeout = yaml.ToDuration(node.Key("timeout"), kDefaultHealthTimeout)
+	h.Fails = yaml.ToInt(node.Key("fails"), kDefaultHealthFails)
+	h.Rises = yaml.ToInt(node.Key("rises"), kDefaultHealthRises)
+	h.Stun = (yaml.ToString(node.Key("stun")) != "false")
+	log.Println(uTAG, "health parameters:", h)
+}
+
+/// ClusterParams
+
+type ClusterParams struct {
+	Name    string        // node name
+	Addr    string        // listen addr for peers, "host:port"
+	Peers   []string      // addrs of other nodes
+	Secret  string        // shared token between nodes
+	Timeout time.Duration // timeout of one peer request
+}
+
+// Load loads the "cluster:" parameters under root.
+func (c *ClusterParams) Load(node yaml.Map) {
+	c.Name = yaml.ToString(node.Key("name"))
+	c.Addr = yaml.ToString(node.Key("addr"))
+	if len(c.Name) == 0 {
+		c.Name = c.Addr
+	}
+	if peers, err := yaml.ToList(node.Key("peers")); err == nil {
+		for _, peer := range peers {
+			if szpeer := yaml.ToString(peer); len(szpeer) > 0 {
+				c.Peers = append(c.Peers, szpeer)
+			}
+		}
+	}
+	c.Secret = yaml.ToString(node.Key("secret"))
+	c.Timeout = yaml.ToDuration(node.Key("timeout"), kDefaultClusterTimeout)
+	log.Println(uTAG, "cluster parameters:", c.Name, c.Addr, c.Peers, c.Timeout)
+}
+
+/// TurnParams
+
+type TurnParams struct {
+	Realm          string
+	Users          map[string]string // long-term credentials, name => password
+	Secret         string            // shared secret of REST API credentials
+	CredentialTtl  time.Duration     // ttl of REST API credentials handed out
+	Lifetime       time.Duration     // default lifetime of allocation
+	MaxLifetime    time.Duration     // max lifetime of allocation
+	RelayIP        string            // ip of relayed address, default local ip
+	MinPort        int               // port range of relayed address, 0 for any
+	MaxPort        int               //
+	MaxAllocations int               // max allocations of server
+	AllowedPeers   []*net.IPNet      // allowed peers besides local candidates
+}
+
+var kDefaultTurnParams = TurnParams{
+	Realm:          kTurnDefaultRealm,
+	CredentialTtl:  kTurnCredentialTtl,
+	Lifetime:       kTurnDefaultLifetime,
+	MaxLifetime:    kTurnMaxLifetime,
+	MaxAllocations: kTurnMaxAllocations,
+}
+
+// Load loads the "turn:" parameters under root.
+func (t *TurnParams) Load(node yaml.Map) {
+	if realm := yaml.ToString(node.Key("realm")); len(realm) > 0 {
+		t.Realm = realm
+	}
+	if users, err := yaml.ToList(node.Key("users")); err == nil {
+		t.Users = make(map[string]string)
+		for _, item := range users {
+			if user, err := yaml.ToMap(item); err == nil {
+				name := yaml.ToString(user.Key("name"))
+				if len(name) > 0 {
+					t.Users[name] = yaml.ToString(user.Key("password"))
+				}
+			}
+		}
+	}
+	t.Secret = yaml.ToString(node.Key("secret"))
+	t.CredentialTtl = yaml.ToDuration(node.Key("credential_ttl"), kTurnCredentialTtl)
+	t.Lifetime = yaml.ToDuration(node.Key("lifetime"), kTurnDefaultLifetime)
+	t.MaxLifetime = yaml.ToDuration(node.Key("max_lifetime"), kTurnMaxLifetime)
+	if t.Lifetime > t.MaxLifetime {
+		t.Lifetime = t.MaxLifetime
+	}
+	t.RelayIP = yaml.ToString(node.Key("relay_ip"))
+	t.MinPort = yaml.ToInt(node.Key("min_port"), 0)
+	t.MaxPort = yaml.ToInt(node.Key("max_port"), 0)
+	if t.MinPort <= 0 || t.MaxPort < t.MinPort {
+		t.MinPort, t.MaxPort = 0, 0
+	}
+	t.MaxAllocations = yaml.ToInt(node.Key("max_allocations"), kTurnMaxAllocations)
+	if peers, err := yaml.ToList(node.Key("allowed_peers")); err == nil {
+		for _, item := range peers {
+			if _, ipnet, err := net.ParseCIDR(yaml.ToString(item)); err == nil {
+				t.AllowedPeers = append(t.AllowedPeers, ipnet)
+			} else {
+				log.Warnln(uTAG, "invalid turn allowed peer:", yaml.ToString(item))
+			}
+		}
+	}
+	log.Println(uTAG, "turn parameters:", t.Realm, len(t.Users), len(t.Secret) > 0, t.Lifetime,
+		t.RelayIP, t.MinPort, t.MaxPort, t.AllowedPeers)
+}
+
+/// CacheParams
+
+type CacheParams struct {
+	Type  string        // memory/bolt
+	File  string        // db file for bolt
+	Sweep time.Duration // interval of clearing timeout items
+}
+
+// Load loads the "cache:" parameters under root.
+func (c *CacheParams) Load(node yaml.Map) {
+	c.Type = yaml.ToString(node.Key("type"))
+	if len(c.Type) == 0 {
+		c.Type = kCacheMemory
+	}
+	c.File = yaml.ToString(node.Key("file"))
+	if len(c.File) == 0 {
+		c.File = kCacheBoltFile
+	}
+	c.Sweep = yaml.ToDuration(node.Key("sweep"), kDefaultCacheSweep)
+	log.Println(uTAG, "cache parameters:", c.Type, c.File, c.Sweep)
+}
+
+/// GeoIPParams
+
+type GeoIPParams struct {
+	Type    string        // mmdb/csv
+	File    string        // city/country mmdb, or cidr csv
+	AsnFile string        // optional asn mmdb
+	Watch   time.Duration // interval of checking files, 0 to disable reload
+}
+
+var kDefaultGeoIPParams = GeoIPParams{
+	Type:    kGeoMmdb,
+	File:    kGeoLite2File,
+	AsnFile: kGeoLite2AsnFile,
+	Watch:   kDefaultGeoWatch,
+}
+
+// Load loads the "geoip:" parameters under root.
+func (g *GeoIPParams) Load(node yaml.Map) {
+	switch gtype := yaml.ToString(node.Key("type")); gtype {
+	case kGeoMmdb, "":
+		g.Type = kGeoMmdb
+		g.File = kGeoLite2File
+		g.AsnFile = kGeoLite2AsnFile
+	case kGeoCsv:
+		g.Type = kGeoCsv
+		g.File = ""
+		g.AsnFile = ""
+	default:
+		log.Warnln(uTAG, "invalid geoip type:", gtype)
+	}
+	if file := yaml.ToString(node.Key("file")); len(file) > 0 {
+		g.File = file
+	}
+	if file := yaml.ToString(node.Key("asn_file")); len(file) > 0 {
+		g.AsnFile = file
+	}
+	g.Watch = yaml.ToDuration(node.Key("watch"), kDefaultGeoWatch)
+	log.Println(uTAG, "geoip parameters:", g.Type, g.File, g.AsnFile, g.Watch)
+}
+
+/// RoutingParams
+
+type RoutingParams struct {
+	Compare       string  // country/continent/asn/distance
+	DistanceRatio float64 // for distance: proxy if d(client,proxy) < d(client,server)*ratio
+	Overrides     []RoutingOverride
+}
+
+var kDefaultRoutingParams = RoutingParams{
+	Compare:       kRoutingCountry,
+	DistanceRatio: kDefaultDistanceRatio,
+}
+
+// Load loads the "routing:" parameters under root.
+func (r *RoutingParams) Load(node yaml.Map) {
+	switch compare := yaml.ToString(node.Key("compare")); compare {
+	case kRoutingCountry, kRoutingContinent, kRoutingAsn, kRoutingDistance:
+		r.Compare = compare
+	case "":
+	default:
+		log.Warnln(uTAG, "invalid routing compare:", compare)
+	}
+	if ratio, err := strconv.ParseFloat(yaml.ToString(node.Key("distance_ratio")), 64); err == nil && ratio > 0 {
+		r.DistanceRatio = ratio
+	}
+
+	if overrides, err := yaml.ToList(node.Key("overrides")); err == nil {
+		for _, item := range overrides {
+			override, err := yaml.ToMap(item)
+			if err != nil {
+				continue
+			}
+			o := RoutingOverride{
+				Client: yaml.ToString(override.Key("client")),
+				Server: yaml.ToString(override.Key("server")),
+				Action: yaml.ToString(override.Key("action")),
+			}
+			if len(o.Client) == 0 {
+				o.Client = "*"
+			}
+			if len(o.Server) == 0 {
+				o.Server = "*"
+			}
+			if o.Action != kRoutingActionProxy && o.Action != kRoutingActionDirect {
+				log.Warnln(uTAG, "invalid routing override action:", o.Action)
+				continue
+			}
+			r.Overrides = append(r.Overrides, o)
+		}
+	}
+	log.Println(uTAG, "routing parameters:", r.Compare, r.DistanceRatio, r.Overrides)
+}
@@ -3,6 +3,7 @@ package webrtc
 import (
 	"bytes"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/PeterXu/xrtc/util"
@@ -17,24 +18,19 @@ type Connection struct {
 	chanSend chan interface{}
 	user     *User
 
-	ready                  bool
-	stunRequesting         uint64
-	hadStunChecking        bool
-	hadStunBindingResponse bool
-	leave                  bool
+	ready                  int32 // atomic, read by service
+	hadStunChecking        int32 // atomic, the checking goroutine
+	hadStunBindingResponse int32 // atomic
+	leave                  int32 // atomic
 	objtime                *ObjTime
 }
 
 func NewConnection(addr net.Addr, chanSend chan interface{}) *Connection {
 	return &Connection{
-		TAG:                    "[CONN]",
-		addr:                   addr,
-		chanSend:               chanSend,
-		ready:                  false,
-		hadStunChecking:        false,
-		hadStunBindingResponse: false,
-		leave:                  false,
-		objtime:                NewObjTime(),
+		TAG:      "[CONN]",
+		addr:     addr,
+		chanSend: chanSend,
+		objtime:  NewObjTime(),
 	}
 }
 
@@ -47,7 +43,7 @@ func (c *Connection) getAddr() net.Addr {
 }
 
 func (c *Connection) dispose() {
-	c.leave = true
+	atomic.StoreInt32(&c.leave, 1)
 	if c.user != nil {
 		c.user.delConnection(c)
 	}
@@ -63,25 +59,28 @@ func (c *Connection) onRecvData(data []byte) {
 	if !c.user.isIceDirect() && util.IsStunPacket(data) {
 		log.Println(c.TAG, "recv stun, len=", len(data))
 		var msg util.IceMessage
-		if !msg.Read(data) {
-			log.Warnln(c.TAG, "invalid stun message, dtype=", msg.Dtype)
+		if err := msg.Decode(data); err != nil {
+			log.Warnln(c.TAG, "invalid stun message, dtype=", msg.Dtype, err)
+			if err.Code != 0 && msg.Dtype == util.STUN_BINDING_REQUEST {
+				c.sendStunErrorResponse(&msg.StunMessage, err)
+			}
 			return
 		}
 
 		switch msg.Dtype {
 		case util.STUN_BINDING_REQUEST:
-			c.onRecvStunBindingRequest(msg.TransId)
+			c.onRecvStunBindingRequest(&msg.StunMessage)
 		case util.STUN_BINDING_RESPONSE:
-			if c.hadStunBindingResponse {
+			if atomic.LoadInt32(&c.hadStunBindingResponse) == 1 {
 				log.Warnln(c.TAG, "had stun binding response")
 				return
 			}
 			log.Println(c.TAG, "recv stun binding response")
 			// init and enable srtp
-			c.hadStunBindingResponse = true
-			c.ready = true
+			atomic.StoreInt32(&c.hadStunBindingResponse, 1)
+			atomic.StoreInt32(&c.ready, 1)
 		case util.STUN_BINDING_ERROR_RESPONSE:
-			log.Warnln(c.TAG, "error stun message")
+			c.onRecvStunBindingErrorResponse(&msg.StunMessage)
 		default:
 			log.Warnln(c.TAG, "unknown stun message=", msg.Dtype)
 		}
@@ -89,7 +88,7 @@ func (c *Connection) onRecvData(data []byte) {
 		// dtls handshake
 		// rtp/rtcp data to inner
 		//log.Println(c.TAG, "recv dtls/rtp/rtcp, len=", len(data))
-		c.ready = true
+		atomic.StoreInt32(&c.ready, 1)
 		c.user.sendToInner(c, data)
 	}
 }
@@ -100,31 +99,71 @@ func (c *Connection) sendData(data []byte) bool {
 }
 
 func (c *Connection) isReady() bool {
-	return c.ready
+	return atomic.LoadInt32(&c.ready) == 1
 }
 
-func (c *Connection) onRecvStunBindingRequest(transId string) {
-	if c.leave {
+func (c *Connection) onRecvStunBindingRequest(req *util.StunMessage) {
+	if atomic.LoadInt32(&c.leave) == 1 {
 		log.Warnln(c.TAG, "had left!")
 		return
 	}
 
+	if role := c.user.getIceRole(); role.checkRequest(req) {
+		log.Warnln(c.TAG, "ice role conflict, keep", role)
+		c.sendStunErrorResponse(req, &util.StunError{Code: kStunErrorRoleConflict, Reason: "Role Conflict"})
+		return
+	}
+
 	log.Println(c.TAG, "recv request and send stun binding response")
 	sendIce := c.user.getSendIce()
 
 	var buf bytes.Buffer
-	if !util.GenStunMessageResponse(&buf, sendIce.Pwd, transId, c.addr) {
+	if !util.GenStunMessageResponse(&buf, sendIce.Pwd, req.TransId, c.addr) {
 		log.Warnln(c.TAG, "fail to gen stun response")
 		return
 	}
 
 	log.Println(c.TAG, "stun response len=", len(buf.Bytes()))
 	c.sendData(buf.Bytes())
+
+	if c.user.getIceRole().isLite() {
+		// ice-lite: no checks, accept nomination from the controlling peer
+		if req.GetAttribute(util.STUN_ATTR_USE_CANDIDATE) != nil {
+			atomic.StoreInt32(&c.ready, 1)
+			c.user.nominate(c)
+		}
+		return
+	}
 	c.checkStunBindingRequest()
 }
 
+// onRecvStunBindingErrorResponse switches role and retries on 487(RFC 8445 7.2.5.1).
+func (c *Connection) onRecvStunBindingErrorResponse(resp *util.StunMessage) {
+	attr, ok := resp.GetAttribute(util.STUN_ATTR_ERROR_CODE).(*util.StunErrorCodeAttribute)
+	if !ok || attr.Code() != kStunErrorRoleConflict {
+		log.Warnln(c.TAG, "error stun message")
+		return
+	}
+	role := c.user.getIceRole()
+	role.switchRole()
+	log.Println(c.TAG, "ice role conflict, switch to", role)
+	c.sendStunBindingRequest()
+}
+
+// sendStunErrorResponse replies 400/420/487 for the failed request.
+func (c *Connection) sendStunErrorResponse(req *util.StunMessage, stunErr *util.StunError) {
+	sendIce := c.user.getSendIce()
+
+	var buf bytes.Buffer
+	if !util.GenStunErrorResponse(&buf, sendIce.Pwd, req, stunErr) {
+		log.Warnln(c.TAG, "fail to gen stun error response")
+		return
+	}
+	c.sendData(buf.Bytes())
+}
+
 func (c *Connection) sendStunBindingRequest() bool {
-	if c.hadStunBindingResponse {
+	if atomic.LoadInt32(&c.hadStunBindingResponse) == 1 || atomic.LoadInt32(&c.leave) == 1 {
 		return false
 	}
 
@@ -133,7 +172,8 @@ func (c *Connection) sendStunBindingRequest() bool {
 	recvIce := c.user.getRecvIce()
 
 	var buf bytes.Buffer
-	if util.GenStunMessageRequest(&buf, sendIce.Ufrag, recvIce.Ufrag, recvIce.Pwd) {
+	attrs := c.user.getIceRole().attributes()
+	if util.GenStunMessageRequest(&buf, sendIce.Ufrag, recvIce.Ufrag, recvIce.Pwd, attrs...) {
 		log.Println(c.TAG, "send stun binding request, len=", buf.Len())
 		c.sendData(buf.Bytes())
 	} else {
@@ -147,31 +187,29 @@ func (c *Connection) checkStunBindingRequest() {
 		return
 	}
 
-	if c.hadStunChecking {
+	if !atomic.CompareAndSwapInt32(&c.hadStunChecking, 0, 1) {
 		return
 	}
 
-	c.hadStunChecking = true
-
 	go func() {
-		c.stunRequesting = 500
+		var stunRequesting uint64 = 500
 		for {
 			select {
-			case <-time.After(time.Millisecond * time.Duration(c.stunRequesting)):
+			case <-time.After(time.Millisecond * time.Duration(stunRequesting)):
 				if !c.sendStunBindingRequest() {
 					log.Println(c.TAG, "quit stun request interval")
-					c.hadStunChecking = false
+					atomic.StoreInt32(&c.hadStunChecking, 0)
 					return
 				}
 
-				if delta := util.NowMs64() - c.objtime.utime; delta >= (15 * 1000) {
+				if delta := util.NowMs64() - c.objtime.updateTime(); delta >= (15 * 1000) {
 					log.Warnln(c.TAG, "(timeout) no response from client and quit")
 					return
 				} else if delta > (5 * 1000) {
 					log.Println(c.TAG, "adjust stun request interval")
-					c.stunRequesting = delta / 2
+					stunRequesting = delta / 2
 				} else if delta < 500 {
-					c.stunRequesting = 500
+					stunRequesting = 500
 				}
 			}
 		}
@@ -0,0 +1,215 @@
+package webrtc
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/PeterXu/xrtc/util"
+)
+
+// fuzzConn is a net.Conn reading from data in chunks, then fails with a non-net error.
+type fuzzConn struct {
+	net.Conn
+	data  []byte
+	chunk int
+}
+
+var errFuzzClosed = errors.New("fuzz conn closed")
+
+func (c *fuzzConn) Read(b []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, errFuzzClosed
+	}
+	n := c.chunk
+	if n <= 0 || n > len(b) {
+		n = len(b)
+	}
+	if n > len(c.data) {
+		n = len(c.data)
+	}
+	copy(b, c.data[0:n])
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func (c *fuzzConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func addStunSeeds(f *testing.F) {
+	for _, text := range []string{kStunSampleRequest, kStunSampleIPv4Response,
+		kStunSampleIPv6Response, kStunSampleLongTermRequest} {
+		f.Add(stunHex(f, text))
+	}
+	var buf bytes.Buffer
+	util.GenStunMessageRequest(&buf, "offer", "answer", "pwd")
+	f.Add(buf.Bytes())
+	f.Add(util.MakeChannelData(0x4001, []byte("data"), true))
+	f.Add([]byte{0x80, 0x60, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1})                // rtp
+	f.Add([]byte{0x81, 0xc8, 0x00, 0x06})                                        // rtcp
+	f.Add([]byte{0x16, 0xfe, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x10, 0x01, 0x02}) // dtls
+}
+
+func FuzzPacketClassifiers(f *testing.F) {
+	addStunSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		util.IsStunPacket(data)
+		util.IsDtlsPacket(data)
+		util.IsRtcpPacket(data)
+		util.IsRtpPacket(data)
+		util.IsRtpRtcpPacket(data)
+		util.IsTurnPacket(data)
+		util.IsTurnTcpStream(data)
+		if channel, payload, ok := util.ParseChannelData(data); ok {
+			if channel < 0x4000 || len(payload) > len(data)-4 {
+				t.Fatalf("invalid channel data: %x %d", channel, len(payload))
+			}
+		}
+	})
+}
+
+func FuzzStunMessage(f *testing.F) {
+	addStunSeeds(f)
+	responder := NewStunResponder(&NetParams{EnableStun: true, StunOrigin: true,
+		Candidates: []string{makeHostCandidate(1, "udp", "1.2.3.4", "6000")}})
+	from := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg util.StunMessage
+		if err := msg.Decode(data); err == nil {
+			// the decoded message can be written again
+			var buf bytes.Buffer
+			msg.Write(&buf)
+		} else if err.Code == 420 && len(err.Unknown) == 0 {
+			t.Fatalf("420 without unknown attributes")
+		}
+		var ice util.IceMessage
+		ice.Read(data)
+		util.ValidateStunMessageIntegrity(data, "pwd")
+
+		if resp := responder.Response(data, from); resp != nil {
+			var out util.StunMessage
+			if err := out.Decode(resp); err != nil {
+				t.Fatalf("invalid stun response: %v", err)
+			}
+		}
+	})
+}
+
+func FuzzReadIceTcpPacket(f *testing.F) {
+	f.Add([]byte{0x00, 0x04, 1, 2, 3, 4}, 1)
+	f.Add([]byte{0x00, 0x10, 1, 2}, 0)
+	f.Add([]byte{0x00}, 0)
+	f.Add([]byte("\x000\xff\xff"), 152) // stun length 0xffff over turn-tcp
+	body := make([]byte, 64*1024)
+	f.Fuzz(func(t *testing.T, data []byte, chunk int) {
+		conn := &fuzzConn{data: data, chunk: chunk % 8}
+		for i := 0; i < 4; i++ {
+			n, err := util.ReadIceTcpPacket(conn, body)
+			if n < 0 || n > len(body) {
+				t.Fatalf("invalid size %d", n)
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		conn = &fuzzConn{data: data, chunk: chunk % 8}
+		tbuf := make([]byte, 128*1024)
+		for i := 0; i < 4; i++ {
+			if _, err := util.ReadTurnTcpPacket(conn, tbuf); err != nil {
+				break
+			}
+		}
+	})
+}
+
+func FuzzParseSdp(f *testing.F) {
+	f.Add([]byte(kFuzzSdp))
+	f.Add([]byte(kChromeOfferSdp))
+	f.Add([]byte(kFirefoxOfferSdp))
+	f.Add([]byte("v=0\nm=audio\na=rtpmap:\na=fmtp:111\na=ssrc:1 msid:\na=sctpmap:\n"))
+	f.Add([]byte("a=group:BUNDLE \nm=application")) // no ptype and sctp
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var desc util.MediaDesc
+		if desc.Parse(data) {
+			desc.GetMediaType()
+			desc.GetUfrag()
+			desc.GetPasswd()
+			desc.GetCandidates()
+			desc.GetAudioCodec()
+			desc.GetVideoCodec()
+			desc.GetTransceivers()
+			if desc.CreateAnswer() {
+				desc.AnswerSdp()
+			}
+		}
+	})
+}
+
+func FuzzSessionDescription(f *testing.F) {
+	f.Add([]byte(kFuzzSdp))
+	f.Add([]byte(kPolicySdp))
+	f.Add([]byte("v=0\nm=audio 9 RTP/AVP 0\r\ngarbage\n\nb="))
+	f.Add([]byte("\x8d=\n\x00="))
+	policy := NewSdpPolicy(&SdpPolicyParams{AudioCodecs: []string{"opus"}, VideoCodecs: []string{"vp8"},
+		StripBandwidth: true, VideoBandwidth: 1000, Tias: true, RtcpMux: true, Bundle: true})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		desc := util.ParseSessionDescription(data)
+		if out := desc.Marshal(); !bytes.Equal(out, data) {
+			t.Fatalf("round trip failed: %q => %q", data, out)
+		}
+		policy.Apply(string(data))
+		util.SetSdpIceLite(data)
+	})
+}
+
+func FuzzParseCandidate(f *testing.F) {
+	f.Add("a=candidate:1 1 udp 2013266431 192.168.1.10 8000 typ host")
+	f.Add("candidate:2 1 tcp 1010827775 2001:db8::1 9 typ host tcptype passive")
+	f.Add("a=candidate:3 1 udp 1 1.2.3.4 5 typ srflx raddr 0.0.0.0 rport 0")
+	f.Add("candidate:1 1 UDP 1677729535 1.2.3.4 5 typ srflx raddr 0.0.0.0 rport 0 generation 0 ufrag a network-id 1 network-cost 10")
+	f.Add("a=candidate:4 1 udp 2122262783 abcd.local 54321 typ host x-ext 1")
+	f.Fuzz(func(t *testing.T, line string) {
+		if cand := util.ParseCandidate(line); cand != nil {
+			// marshal and parse again
+			again, err := util.UnmarshalCandidate(cand.Marshal())
+			if err != nil || !reflect.DeepEqual(cand, again) {
+				t.Fatalf("round trip failed: %q => %q, %v", line, cand.Marshal(), err)
+			}
+		}
+		util.ParseCandidateHost(line)
+		util.ParseCandidateIp(line)
+	})
+}
+
+const kFuzzSdp = "v=0\r\n" +
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=group:BUNDLE 0 1\r\n" +
+	"a=msid-semantic: WMS stream\r\n" +
+	"a=ice-ufrag:abcd\r\n" +
+	"a=ice-pwd:0123456789abcdefghijkl\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:0\r\n" +
+	"a=sendrecv\r\n" +
+	"a=rtcp-mux\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=fmtp:111 minptime=10;useinbandfec=1\r\n" +
+	"a=ssrc:1001 cname:user\r\n" +
+	"a=candidate:1 1 udp 2013266431 192.168.1.10 8000 typ host\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n" +
+	"a=mid:1\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtpmap:97 rtx/90000\r\n" +
+	"a=fmtp:97 apt=96\r\n" +
+	"a=rtcp-fb:96 nack pli\r\n" +
+	"a=extmap:1/sendonly urn:ietf:params:rtp-hdrext:toffset\r\n" +
+	"a=ssrc-group:FID 2001 2002\r\n" +
+	"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+	"a=sctp-port:5000\r\n"
@@ -1,63 +1,339 @@
 package webrtc
 
 import (
+	"encoding/csv"
+	"errors"
+	"io"
 	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/PeterXu/xrtc/util"
 	"github.com/oschwald/geoip2-golang"
 )
 
-var gGeoDB *geoip2.Reader
+// geoip provider types
+const (
+	kGeoMmdb = "mmdb" // maxmind city/country db, with optional asn db
+	kGeoCsv  = "csv"  // static cidr file
+)
+
+// default interval of checking geoip files
+const kDefaultGeoWatch = 60 * time.Second
+
+var errGeoNoFile = errors.New("no geoip file")
+
+// GeoInfo is the location of one ip.
+type GeoInfo struct {
+	IP        string  `json:"ip"`
+	Country   string  `json:"country,omitempty"`   // iso code
+	Continent string  `json:"continent,omitempty"` // continent code
+	ASN       uint    `json:"asn,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+// hasLocation returns false for unknown(e.g. local) ip.
+func (g *GeoInfo) hasLocation() bool {
+	return g.Latitude != 0 || g.Longitude != 0
+}
+
+// GeoProvider looks up the geo info of one ip, nil if not found.
+type GeoProvider interface {
+	Lookup(ip net.IP) *GeoInfo
+}
+
+// the global geoip, set by Inst
+var gGeoIP struct {
+	sync.Mutex
+	geo *GeoIP
+}
+
+// setGeoIP replaces the global geoip and closes the old one.
+func setGeoIP(geo *GeoIP) {
+	gGeoIP.Lock()
+	old := gGeoIP.geo
+	gGeoIP.geo = geo
+	gGeoIP.Unlock()
+	old.Close()
+}
+
+// lookupGeo returns nil if no geo db or invalid ip.
+func lookupGeo(ip string) *GeoInfo {
+	gGeoIP.Lock()
+	geo := gGeoIP.geo
+	gGeoIP.Unlock()
+	return geo.Lookup(ip)
+}
+
+// geoHolder wraps provider for atomic.Value(nil if not loaded).
+type geoHolder struct {
+	provider GeoProvider
+}
 
-func init() {
-	db, err := geoip2.Open(kGeoLite2File)
+// GeoIP is the geoip provider loaded from config,
+// which checks the files periodically and reloads them when changed.
+type GeoIP struct {
+	TAG      string
+	params   GeoIPParams
+	holder   atomic.Value
+	stats    map[string]string // file => size/mtime
+	exitTick chan bool
+	exitOnce sync.Once
+}
+
+func NewGeoIP(params *GeoIPParams) *GeoIP {
+	g := &GeoIP{
+		TAG:      "[GEOIP]",
+		params:   *params,
+		exitTick: make(chan bool),
+	}
+	g.holder.Store(geoHolder{})
+	g.load()
+	if g.params.Watch > 0 {
+		go g.Run()
+	}
+	return g
+}
+
+// load opens the provider, and keeps the old one if failed.
+func (g *GeoIP) load() {
+	g.stats = g.statFiles()
+	provider, err := openGeoProvider(&g.params)
 	if err != nil {
-		log.Warnln(err)
-	} else {
-		log.Println("[geoip]", "load geo db success")
-		gGeoDB = db
+		if g.holder.Load().(geoHolder).provider == nil {
+			log.Warnln(g.TAG, "geo routing is disabled, load failed:", err)
+		} else {
+			log.Warnln(g.TAG, "reload failed, keep the old:", err)
+		}
+		return
 	}
+	log.Println(g.TAG, "load success:", g.params.Type, g.params.File)
+	g.holder.Store(geoHolder{provider})
 }
 
-// return false, default (srcIP->dstIP)
-// return true, use (srcIP->midIP->dstIP) when midIP is more optimal than dstIP
-func checkGeoOptimal(srcIP, midIP, dstIP string) bool {
-	db := gGeoDB
-	if db == nil {
-		return false
+// statFiles returns the size/mtime of files, "" for missing.
+func (g *GeoIP) statFiles() map[string]string {
+	stats := make(map[string]string)
+	for _, fname := range []string{g.params.File, g.params.AsnFile} {
+		if len(fname) == 0 {
+			continue
+		}
+		if fi, err := os.Stat(fname); err == nil {
+			stats[fname] = strconv.FormatInt(fi.Size(), 10) + "/" + fi.ModTime().String()
+		} else {
+			stats[fname] = ""
+		}
 	}
-	srcRd, err := db.City(net.ParseIP(srcIP))
-	if err != nil {
-		return false
+	return stats
+}
+
+func (g *GeoIP) changed() bool {
+	stats := g.statFiles()
+	for fname, stat := range stats {
+		if g.stats[fname] != stat {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *GeoIP) Lookup(ip string) *GeoInfo {
+	if g == nil {
+		return nil
+	}
+	provider := g.holder.Load().(geoHolder).provider
+	addr := net.ParseIP(ip)
+	if provider == nil || addr == nil {
+		return nil
+	}
+	info := provider.Lookup(addr)
+	if info != nil {
+		info.IP = ip
 	}
-	srcCN := srcRd.Country.Names["en"]
+	return info
+}
 
-	midRd, err := db.City(net.ParseIP(midIP))
+// Close stops watching, and it is safe to call more than once.
+func (g *GeoIP) Close() {
+	if g != nil {
+		g.exitOnce.Do(func() { close(g.exitTick) })
+	}
+}
+
+func (g *GeoIP) Run() {
+	ticker := time.NewTicker(g.params.Watch)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.exitTick:
+			log.Println(g.TAG, "Run exit...")
+			return
+		case <-ticker.C:
+			if g.changed() {
+				log.Println(g.TAG, "files changed, reload")
+				g.load()
+			}
+		}
+	}
+}
+
+// openGeoProvider opens the provider by params.
+func openGeoProvider(params *GeoIPParams) (GeoProvider, error) {
+	if len(params.File) == 0 {
+		return nil, errGeoNoFile
+	}
+	switch params.Type {
+	case kGeoCsv:
+		return openCsvGeo(params.File)
+	default:
+		return openMmdbGeo(params.File, params.AsnFile)
+	}
+}
+
+// mmdbGeo is the provider of maxmind city/country db.
+// The dbs are read into memory(not mmap), so the old one is safe after reloading.
+type mmdbGeo struct {
+	db   *geoip2.Reader
+	asn  *geoip2.Reader // optional
+	city bool           // city db, or country db without location
+}
+
+func openMmdbGeo(fname, asnFile string) (*mmdbGeo, error) {
+	db, err := openMmdb(fname)
 	if err != nil {
-		return false
+		return nil, err
 	}
-	midCN := midRd.Country.Names["en"]
+	dbType := db.Metadata().DatabaseType
+	g := &mmdbGeo{
+		db:   db,
+		city: strings.Contains(dbType, "City") || strings.Contains(dbType, "Enterprise"),
+	}
+	if len(asnFile) > 0 {
+		if g.asn, err = openMmdb(asnFile); err != nil {
+			log.Warnln("[GEOIP]", "skip asn db:", err)
+		}
+	}
+	return g, nil
+}
 
-	dstRd, err := db.City(net.ParseIP(dstIP))
+func openMmdb(fname string) (*geoip2.Reader, error) {
+	data, err := os.ReadFile(fname)
 	if err != nil {
-		return false
+		return nil, err
 	}
-	dstCN := dstRd.Country.Names["en"]
+	return geoip2.FromBytes(data)
+}
 
-	log.Println("[geoip]", srcCN, midCN, dstCN)
+func (g *mmdbGeo) Lookup(ip net.IP) *GeoInfo {
+	info := &GeoInfo{}
+	if g.city {
+		rd, err := g.db.City(ip)
+		if err != nil {
+			return nil
+		}
+		info.Country = rd.Country.IsoCode
+		info.Continent = rd.Continent.Code
+		info.Latitude = rd.Location.Latitude
+		info.Longitude = rd.Location.Longitude
+	} else {
+		rd, err := g.db.Country(ip)
+		if err != nil {
+			return nil
+		}
+		info.Country = rd.Country.IsoCode
+		info.Continent = rd.Continent.Code
+	}
+	if g.asn != nil {
+		if asn, err := g.asn.ASN(ip); err == nil {
+			info.ASN = asn.AutonomousSystemNumber
+		}
+	}
+	return info
+}
+
+// csvGeo is the provider of static cidr file, each line:
+//
+//	cidr,country[,continent[,asn[,latitude,longitude]]]
+//
+// The longest prefix is matched, and "#" is for comment.
+type csvGeo struct {
+	entries []csvGeoEntry
+}
+
+type csvGeoEntry struct {
+	ipnet *net.IPNet
+	ones  int
+	info  GeoInfo
+}
 
-	if len(srcCN) == 0 || len(dstCN) == 0 {
-		// maybe src or dst is local ip.
-		// That means: src euqal-to mid, or mid euqal-to dst.
-		// Donot need to change.
-		return false
+func openCsvGeo(fname string) (*csvGeo, error) {
+	fp, err := os.Open(fname)
+	if err != nil {
+		return nil, err
 	}
+	defer fp.Close()
+	return parseCsvGeo(fp)
+}
 
-	if srcCN == midCN && srcCN != dstCN {
-		// cross country:
-		// change from (srcCN -> dstCN) to (srcCN -> midCN -> dstCN).
-		return true
+func parseCsvGeo(rd io.Reader) (*csvGeo, error) {
+	reader := csv.NewReader(rd)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	g := &csvGeo{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			return nil, errors.New("wrong geoip csv line: " + strings.Join(record, ","))
+		}
+		_, ipnet, err := net.ParseCIDR(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, err
+		}
+		entry := csvGeoEntry{ipnet: ipnet, info: GeoInfo{Country: strings.TrimSpace(record[1])}}
+		entry.ones, _ = ipnet.Mask.Size()
+		if len(record) > 2 {
+			entry.info.Continent = strings.TrimSpace(record[2])
+		}
+		if len(record) > 3 {
+			asn, _ := strconv.ParseUint(strings.TrimSpace(record[3]), 10, 32)
+			entry.info.ASN = uint(asn)
+		}
+		if len(record) > 5 {
+			entry.info.Latitude, _ = strconv.ParseFloat(strings.TrimSpace(record[4]), 64)
+			entry.info.Longitude, _ = strconv.ParseFloat(strings.TrimSpace(record[5]), 64)
+		}
+		g.entries = append(g.entries, entry)
+	}
+	if len(g.entries) == 0 {
+		return nil, errors.New("empty geoip csv")
 	}
 
-	return false
+	// longest prefix first
+	sort.SliceStable(g.entries, func(i, j int) bool {
+		return g.entries[i].ones > g.entries[j].ones
+	})
+	return g, nil
+}
+
+func (g *csvGeo) Lookup(ip net.IP) *GeoInfo {
+	for i := range g.entries {
+		if g.entries[i].ipnet.Contains(ip) {
+			info := g.entries[i].info
+			return &info
+		}
+	}
+	return nil
 }
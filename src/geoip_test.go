@@ -0,0 +1,83 @@
+package webrtc
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCsvGeoLookup(t *testing.T) {
+	g, err := parseCsvGeo(strings.NewReader(`
+# cidr,country,continent,asn,latitude,longitude
+10.0.0.0/8, CN, AS
+10.1.0.0/16, JP, AS, 2516, 35.7, 139.7
+2001:db8::/32, US, NA
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip      string
+		country string
+	}{
+		{"10.2.3.4", "CN"},
+		{"10.1.3.4", "JP"},
+		{"2001:db8::1", "US"},
+		{"192.168.1.1", ""},
+	}
+	for _, tt := range tests {
+		info := g.Lookup(net.ParseIP(tt.ip))
+		if (info == nil && len(tt.country) > 0) || (info != nil && info.Country != tt.country) {
+			t.Errorf("%s: want %q, got %v", tt.ip, tt.country, info)
+		}
+	}
+	if info := g.Lookup(net.ParseIP("10.1.0.1")); info.ASN != 2516 || !info.hasLocation() {
+		t.Errorf("want asn and location, got %v", info)
+	}
+	if _, err := parseCsvGeo(strings.NewReader("bad-cidr,CN\n")); err == nil {
+		t.Errorf("want error for bad cidr")
+	}
+}
+
+func TestGeoIPReload(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "geo.csv")
+	geo := NewGeoIP(&GeoIPParams{Type: kGeoCsv, File: fname, Watch: 20 * time.Millisecond})
+	defer geo.Close()
+
+	// missing file: disabled until created
+	if info := geo.Lookup("10.0.0.1"); info != nil {
+		t.Fatalf("want nil without file, got %v", info)
+	}
+
+	waitCountry := func(country string) {
+		for i := 0; i < 100; i++ {
+			if info := geo.Lookup("10.0.0.1"); info != nil && info.Country == country {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("not reloaded to %s", country)
+	}
+
+	if err := os.WriteFile(fname, []byte("10.0.0.0/8,CN\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitCountry("CN")
+
+	// broken file keeps the old
+	os.WriteFile(fname, []byte("broken\n"), 0644)
+	time.Sleep(100 * time.Millisecond)
+	if info := geo.Lookup("10.0.0.1"); info == nil || info.Country != "CN" {
+		t.Fatalf("want old CN, got %v", info)
+	}
+
+	os.WriteFile(fname, []byte("10.0.0.0/8,US\n"), 0644)
+	waitCountry("US")
+	// closed again(e.g. replaced after hub closed) without panic
+	geo.Close()
+	setGeoIP(geo)
+	setGeoIP(nil)
+}
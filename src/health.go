@@ -0,0 +1,157 @@
+package webrtc
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/PeterXu/xrtc/util"
+	util "github.com/PeterXu/xrtc/util"
+)
+
+// default health checking parameters
+const (
+	kDefaultHealthInterval = 5 * time.Second
+	kDefaultHealthTimeout  = 2 * time.Second
+	kDefaultHealthFails    = 2
+	kDefaultHealthRises    = 1
+)
+
+// HealthChecker checks all backends periodically, by stun(udp), tcp connect and http url.
+type HealthChecker struct {
+	TAG      string
+	pool     *BackendPool
+	params   HealthParams
+	client   *http.Client
+	exitTick chan bool
+}
+
+func NewHealthChecker(pool *BackendPool, params *HealthParams) *HealthChecker {
+	return &HealthChecker{
+		TAG:      "[HEALTH]",
+		pool:     pool,
+		params:   *params,
+		client:   &http.Client{Timeout: params.Timeout},
+		exitTick: make(chan bool, 1),
+	}
+}
+
+func (c *HealthChecker) Close() {
+	select {
+	case c.exitTick <- true:
+	default:
+	}
+}
+
+func (c *HealthChecker) Run() {
+	log.Println(c.TAG, "Run begin, interval:", c.params.Interval)
+	c.checkAll()
+
+	tickChan := time.NewTicker(c.params.Interval).C
+	for {
+		select {
+		case <-c.exitTick:
+			log.Println(c.TAG, "Run exit...")
+			return
+		case <-tickChan:
+			c.checkAll()
+		}
+	}
+}
+
+// checkAll checks all backends concurrently and waits for the results.
+func (c *HealthChecker) checkAll() {
+	var wg sync.WaitGroup
+	for _, b := range c.pool.backends {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			c.pool.onHealthResult(b, c.check(b), c.params.Fails, c.params.Rises)
+		}(b)
+	}
+	wg.Wait()
+}
+
+// check returns nil if any candidate is reachable and health url(if set) is ok.
+func (c *HealthChecker) check(b *Backend) error {
+	var err error
+	reachable := false
+	for _, addr := range b.addrs {
+		switch addr.proto {
+		case "udp":
+			if !c.params.Stun {
+				reachable = true
+				continue
+			}
+			err = c.checkStun(addr.addr)
+		case "tcp":
+			err = c.checkTcp(addr.addr)
+		default:
+			continue
+		}
+		if err == nil {
+			reachable = true
+			break
+		}
+	}
+	if !reachable && err != nil {
+		return err
+	}
+
+	if len(b.HealthUrl) > 0 {
+		return c.checkHttp(b.HealthUrl)
+	}
+	return nil
+}
+
+// checkStun sends a binding request with random ice, any stun response(even error) is ok.
+func (c *HealthChecker) checkStun(addr string) error {
+	conn, err := net.DialTimeout("udp", addr, c.params.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	if !util.GenStunMessageRequest(&buf, util.RandomString(8), util.RandomString(8), util.RandomString(24)) {
+		return errors.New("gen stun request failed")
+	}
+	conn.SetDeadline(time.Now().Add(c.params.Timeout))
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	data := make([]byte, 1500)
+	n, err := conn.Read(data)
+	if err != nil {
+		return err
+	}
+	if !util.IsStunPacket(data[:n]) {
+		return errors.New("invalid stun response from " + addr)
+	}
+	return nil
+}
+
+func (c *HealthChecker) checkTcp(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, c.params.Timeout)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func (c *HealthChecker) checkHttp(url string) error {
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("health url status: " + resp.Status)
+	}
+	return nil
+}
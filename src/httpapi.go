@@ -0,0 +1,80 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// The version of http api response envelope.
+const kApiResponseVersion = "1.0"
+
+// ApiCode is a machine-readable result code in api response.
+type ApiCode string
+
+// These are all result codes of http api.
+const (
+	kApiCodeOK                 ApiCode = "ok"
+	kApiCodeBadBody            ApiCode = "bad_body"
+	kApiCodeNoServerCandidates ApiCode = "no_server_candidates"
+	kApiCodeNoProxyCandidates  ApiCode = "no_proxy_candidates"
+	kApiCodeNoBackend          ApiCode = "no_backend"
+	kApiCodeGeoNotOptimal      ApiCode = "geo_not_optimal" // not error, direct candidates returned
+	kApiCodeForbidden          ApiCode = "forbidden"
+	kApiCodeMethodNotAllowed   ApiCode = "method_not_allowed"
+	kApiCodeNotFound           ApiCode = "not_found"
+	kApiCodeUpstream           ApiCode = "upstream_error"
+	kApiCodeInternal           ApiCode = "internal_error"
+)
+
+// ApiResponse is the envelope of all http api responses.
+type ApiResponse struct {
+	Version string      `json:"version"`
+	Code    ApiCode     `json:"code"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// ApiError is an api failure with its http status.
+type ApiError struct {
+	Status  int
+	Code    ApiCode
+	Message string
+}
+
+func NewApiError(status int, code ApiCode, message string) *ApiError {
+	return &ApiError{status, code, message}
+}
+
+func (e *ApiError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// writeApiResponse writes one api envelope with http status.
+func writeApiResponse(w http.ResponseWriter, status int, code ApiCode, message string, data interface{}) {
+	resp := ApiResponse{
+		Version: kApiResponseVersion,
+		Code:    code,
+		Message: message,
+		Data:    data,
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(ApiResponse{
+			Version: kApiResponseVersion,
+			Code:    kApiCodeInternal,
+			Message: err.Error(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeApiData(w http.ResponseWriter, code ApiCode, data interface{}) {
+	writeApiResponse(w, http.StatusOK, code, "", data)
+}
+
+func writeApiError(w http.ResponseWriter, err *ApiError) {
+	writeApiResponse(w, err.Status, err.Code, err.Message, nil)
+}
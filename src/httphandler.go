@@ -3,9 +3,11 @@ package webrtc
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
+	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	log "github.com/PeterXu/xrtc/util"
 	util "github.com/PeterXu/xrtc/util"
@@ -16,6 +18,7 @@ const (
 	kVersion    = "xrtc-agent"
 	kApiVersion = "/webrtc/version"
 	kApiRequest = "/webrtc/request"
+	kApiStats   = "/webrtc/stats"
 )
 
 type SdpIceInfo struct {
@@ -28,12 +31,107 @@ type RegisterRequest struct {
 	SessionKey string     `json:"session_key,omitempty"`
 	OfferIce   SdpIceInfo `json:"offer_ice"`
 	AnswerIce  SdpIceInfo `json:"answer_ice"`
-	Candidates []string   `json:"candidates"` // dest candidates to server
+	Candidates []string   `json:"candidates"`         // dest candidates to server
+	Room       string     `json:"room,omitempty"`     // select server from backends if no candidates
+	Ttl        int        `json:"ttl,omitempty"`      // seconds to wait for client stun, bounded by config
+	IceRole    string     `json:"ice_role,omitempty"` // xrtc role to client: controlled(default)/controlling
+	IceLite    bool       `json:"ice_lite,omitempty"` // xrtc is ice-lite to client(always controlled)
+
+	// sdp mode: ice info and candidates are parsed from offer/answer sdp
+	OfferSdp  string `json:"offer_sdp,omitempty"`
+	AnswerSdp string `json:"answer_sdp,omitempty"`
+
+	stunned int32  // atomic, 1 when any client stun arrived
+	replica bool   // from other node in cluster
+	expires uint64 // ms, the registration expired time(0 if unknown)
+}
+
+// setLifetime sets the registration expired after ttl(ms).
+func (r *RegisterRequest) setLifetime(ttl int) {
+	r.expires = util.NowMs64() + uint64(ttl)
+}
+
+// lifetime returns the remaining ttl(ms) of registration, 0 if expired.
+// It is the full ttl if unknown(e.g. restored from file).
+func (r *RegisterRequest) lifetime() int {
+	if r.expires == 0 {
+		return r.Ttl * 1000
+	}
+	if now := util.NowMs64(); now < r.expires {
+		return int(r.expires - now)
+	}
+	return 0
+}
+
+// setStunned marks the first client stun, and returns false if already marked.
+func (r *RegisterRequest) setStunned() bool {
+	return atomic.CompareAndSwapInt32(&r.stunned, 0, 1)
+}
+
+func (r *RegisterRequest) isStunned() bool {
+	return atomic.LoadInt32(&r.stunned) == 1
+}
+
+// iceKey returns the key of cache/user: "answer_ufrag:offer_ufrag".
+func (r *RegisterRequest) iceKey() string {
+	return r.AnswerIce.Ufrag + ":" + r.OfferIce.Ufrag
+}
+
+// terminatesIce returns true if xrtc is the ice agent to client(with ice_role or ice_lite),
+// or else the client stun is forwarded to server directly.
+func (r *RegisterRequest) terminatesIce() bool {
+	return len(r.IceRole) > 0 || r.IceLite
+}
+
+// isSdpMode returns true if raw offer/answer sdp posted.
+func (r *RegisterRequest) isSdpMode() bool {
+	return len(r.OfferSdp) > 0 || len(r.AnswerSdp) > 0
+}
+
+// loadSdp fills ice info(offer/answer) and server candidates(answer) from sdp.
+func (r *RegisterRequest) loadSdp() error {
+	if len(r.OfferSdp) == 0 || len(r.AnswerSdp) == 0 {
+		return errors.New("both offer_sdp and answer_sdp required")
+	}
+
+	var offer, answer util.MediaDesc
+	offer.Parse([]byte(r.OfferSdp))
+	answer.Parse([]byte(r.AnswerSdp))
+
+	r.OfferIce = SdpIceInfo{offer.GetUfrag(), offer.GetPasswd(), offer.GetIceOptions()}
+	r.AnswerIce = SdpIceInfo{answer.GetUfrag(), answer.GetPasswd(), answer.GetIceOptions()}
+	if len(r.OfferIce.Ufrag) == 0 || len(r.AnswerIce.Ufrag) == 0 {
+		return errors.New("no ice-ufrag in offer/answer sdp")
+	}
+
+	r.Candidates = util.GetSdpCandidates([]byte(r.AnswerSdp))
+	return nil
 }
 
 type RegisterResponse struct {
-	SessionKey string   `json:"session_key,omitempty"`
-	Candidates []string `json:"candidates"` // proxy candidates for client
+	SessionKey string           `json:"session_key,omitempty"`
+	Candidates []string         `json:"candidates"`            // proxy candidates for client
+	Backend    string           `json:"backend,omitempty"`     // selected server for room
+	Ttl        int              `json:"ttl"`                   // seconds to wait for client stun
+	Routing    *RoutingDecision `json:"routing,omitempty"`     // why proxied or not
+	IceServers []*IceServer     `json:"ice_servers,omitempty"` // turn servers for strict firewall
+
+	// sdp mode: answer sdp with the candidates for client
+	AnswerSdp string `json:"answer_sdp,omitempty"`
+}
+
+type SessionResponse struct {
+	SessionKey string `json:"session_key"`
+}
+
+type StatsResponse struct {
+	Sessions Metrics       `json:"sessions"`
+	Backends []BackendInfo `json:"backends"`
+}
+
+type VersionResponse struct {
+	Name       string `json:"name"`
+	ApiVersion string `json:"api_version"`
 }
 
 type HttpServerHandler struct {
@@ -43,6 +141,9 @@ type HttpServerHandler struct {
 
 	Config HttpParams
 
+	// policy munges the passing sdp, nil for none
+	policy *SdpPolicy
+
 	// UUID returns a unique id in uuid format.
 	// If UUID is nil, uuid.NewUUID() is used.
 	UUID func() string
@@ -53,24 +154,22 @@ func NewHttpServeHandler(name string, cfg *HttpParams) http.Handler {
 		TAG:    "[HTTP]",
 		Name:   name,
 		Config: *cfg,
+		policy: NewSdpPolicy(cfg.SdpPolicy),
 		UUID:   uuid.NewUUID,
 	}
 }
 
-func createJsonString(key, value string) string {
-	if len(key) > 0 && len(value) > 0 {
-		return fmt.Sprintf("{%s: %s}", key, value)
-	} else {
-		return "{}"
+// checkServername returns false if the request host is not matched with servername.
+func (p *HttpServerHandler) checkServername(r *http.Request) bool {
+	name := p.Config.Servername
+	if len(name) == 0 || name == kDefaultServerName {
+		return true
 	}
-}
-
-func createJsonBytes(key, value string) []byte {
-	return []byte(createJsonString(key, value))
-}
-
-func createJsonStatus(value string) []byte {
-	return createJsonBytes("status", value)
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	return strings.EqualFold(host, name)
 }
 
 func (p *HttpServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -81,11 +180,19 @@ func (p *HttpServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Add("Access-Control-Allow-Origin", "*")
 	w.Header().Add("Access-Control-Allow-Headers",
-		"Content-Type, Content-Range, Content-Disposition, Content-Description")
+		"Content-Type, Content-Range, Content-Disposition, Content-Description, Authorization, If-Match")
+	w.Header().Add("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+	w.Header().Add("Access-Control-Expose-Headers", "Location, ETag, Link, Accept-Patch")
 
 	if r.Method == http.MethodOptions {
 		log.Warnln(p.TAG, "http options")
-		w.Write(createJsonStatus("OK"))
+		writeApiData(w, kApiCodeOK, nil)
+		return
+	}
+
+	if !p.checkServername(r) {
+		log.Warnln(p.TAG, "http forbidden host=", r.Host)
+		writeApiError(w, NewApiError(http.StatusForbidden, kApiCodeForbidden, "servername not matched"))
 		return
 	}
 
@@ -93,19 +200,31 @@ func (p *HttpServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Println(p.TAG, "http path=", path, r.RemoteAddr)
 	switch {
 	case strings.HasPrefix(path, kApiVersion):
-		w.Write(createJsonBytes("version", kVersion))
+		writeApiData(w, kApiCodeOK, &VersionResponse{kVersion, kApiResponseVersion})
+	case strings.HasPrefix(path, kApiStats):
+		writeApiData(w, kApiCodeOK, &StatsResponse{Inst().Metrics().Snapshot(), Inst().Backends().Infos()})
 	case strings.HasPrefix(path, kApiRequest):
+		if r.Method == http.MethodDelete {
+			key := strings.Trim(strings.TrimPrefix(path, kApiRequest), "/")
+			if err := p.handleDelete(w, key); err != nil {
+				log.Warnln(p.TAG, "handle delete error:", err)
+				writeApiError(w, err)
+			}
+			break
+		}
 		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write(createJsonStatus("Only Post Allowed"))
+			writeApiError(w, NewApiError(http.StatusMethodNotAllowed, kApiCodeMethodNotAllowed, "only POST/DELETE allowed"))
 			break
 		}
 		encoding := r.Header.Get("Content-Encoding")
 		body, err := util.ReadHttpBody(r.Body, encoding)
 		if body == nil || err != nil {
 			log.Warnln(p.TAG, "http invalid reqeust body, err=", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(createJsonStatus(err.Error()))
+			msg := "empty body"
+			if err != nil {
+				msg = err.Error()
+			}
+			writeApiError(w, NewApiError(http.StatusBadRequest, kApiCodeBadBody, msg))
 			break
 		}
 
@@ -114,64 +233,173 @@ func (p *HttpServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		if err := p.handleRequest(w, raddr, body); err != nil {
 			log.Warnln(p.TAG, "handle request error:", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(createJsonStatus(err.Error()))
+			writeApiError(w, err)
 			break
 		}
+	case strings.HasPrefix(path, kApiJanus):
+		p.handleJanus(w, r)
+	case strings.HasPrefix(path, kApiWhip):
+		p.handleWhip(w, r, kApiWhip, p.Config.WhipUpstream)
+	case strings.HasPrefix(path, kApiWhep):
+		p.handleWhip(w, r, kApiWhep, p.Config.WhepUpstream)
 	default:
-		w.WriteHeader(http.StatusNotFound)
+		writeApiError(w, NewApiError(http.StatusNotFound, kApiCodeNotFound, "no such api: "+path))
 	}
 }
 
-func (p *HttpServerHandler) handleRequest(w http.ResponseWriter, raddr string, body []byte) error {
+func (p *HttpServerHandler) handleRequest(w http.ResponseWriter, raddr string, body []byte) *ApiError {
 	var jreq RegisterRequest
 	if err := json.Unmarshal(body, &jreq); err != nil {
-		return err
+		return NewApiError(http.StatusBadRequest, kApiCodeBadBody, err.Error())
 	}
 
-	log.Println(p.TAG, "http req=", raddr, jreq)
+	if jreq.isSdpMode() {
+		if err := jreq.loadSdp(); err != nil {
+			return NewApiError(http.StatusBadRequest, kApiCodeBadBody, err.Error())
+		}
+	}
+	resp, code, err := p.registerRequest(raddr, &jreq)
+	if err != nil {
+		return err
+	}
+	resp.AnswerSdp = p.policy.Apply(resp.AnswerSdp)
+	writeApiData(w, code, resp)
+	return nil
+}
 
-	// default use orignal server-candidates
-	serverCandidates := jreq.Candidates
+// selectCandidates checks routing policy and returns the candidates(proxy/server) for client.
+func (p *HttpServerHandler) selectCandidates(raddr string, serverCandidates []string) ([]string, *RoutingDecision, *ApiError) {
 	if len(serverCandidates) == 0 {
-		return errors.New("no server candidates")
+		return nil, nil, NewApiError(http.StatusBadRequest, kApiCodeNoServerCandidates, "no server candidates")
 	}
-
-	proxyCandidates := Inst().Candidates()
-	if len(proxyCandidates) == 0 {
-		return errors.New("no proxy candidates")
+	if err := Inst().Backends().CheckCandidates(serverCandidates); err != nil {
+		return nil, nil, NewApiError(http.StatusServiceUnavailable, kApiCodeNoBackend, err.Error())
 	}
 
 	clientIp := util.ParseHostIp(raddr)
+
+	// the proxy node closest to client
+	group := selectProxyGroup(lookupGeo(clientIp), Inst().ProxyGroups(), lookupGeo)
+	if group == nil || len(group.Candidates) == 0 {
+		return nil, nil, NewApiError(http.StatusServiceUnavailable, kApiCodeNoProxyCandidates, "no proxy candidates")
+	}
+
 	serverIp := util.ParseCandidateIp(serverCandidates[0])
-	proxyIp := util.ParseCandidateIp(proxyCandidates[0])
+	proxyIp := group.ip()
 
-	log.Println(p.TAG, "ips:", serverIp, proxyIp, clientIp)
+	log.Println(p.TAG, "ips:", serverIp, proxyIp, clientIp, ", proxy:", group.Node, group.Region)
 
-	candidates := serverCandidates
-	isOptimal := checkGeoOptimal(clientIp, proxyIp, serverIp)
-	if isOptimal {
+	decision := Inst().Routing().Check(p.Config.ProxyMode, clientIp, proxyIp, serverIp)
+	decision.Node = group.Node
+	decision.Region = group.Region
+	log.Println(p.TAG, "routing:", decision.UseProxy, decision.Policy, decision.Reason)
+	if decision.UseProxy {
 		// client -> proxy -> server
 		log.Println(p.TAG, "use proxy between client and server")
+		return group.Candidates, decision, nil
+	}
+	return serverCandidates, decision, nil
+}
+
+// registerRequest caches ice info when using proxy.
+// It returns the candidates(proxy/server) for client.
+func (p *HttpServerHandler) registerRequest(raddr string, jreq *RegisterRequest) (*RegisterResponse, ApiCode, *ApiError) {
+	log.Println(p.TAG, "http req=", raddr, jreq.SessionKey, jreq.OfferIce, jreq.AnswerIce, jreq.Candidates)
 
+	jreq.IceLite = jreq.IceLite || p.Config.IceLite
+	if err := checkIceRole(jreq.IceRole, jreq.IceLite); err != nil {
+		return nil, "", NewApiError(http.StatusBadRequest, kApiCodeBadBody, err.Error())
+	}
+
+	// select server by room when no candidates
+	var backend *Backend
+	if len(jreq.Candidates) == 0 && len(jreq.Room) > 0 {
+		var err error
+		if backend, err = Inst().Backends().Select(jreq.Room); err != nil {
+			return nil, "", NewApiError(http.StatusServiceUnavailable, kApiCodeNoBackend, err.Error())
+		}
+		log.Println(p.TAG, "select backend:", backend.Name, "for room:", jreq.Room)
+		jreq.Candidates = backend.Candidates
+	}
+
+	// default use orignal server-candidates
+	candidates, decision, err := p.selectCandidates(raddr, jreq.Candidates)
+	if err != nil {
+		return nil, "", err
+	}
+	isOptimal := decision.UseProxy
+
+	code := kApiCodeGeoNotOptimal
+	if isOptimal {
 		// use proxy ip-candidates to client
-		candidates = proxyCandidates
+		code = kApiCodeOK
 
-		// add to cache for processing
-		item := NewCacheItem(&jreq)
-		key := jreq.AnswerIce.Ufrag + ":" + jreq.OfferIce.Ufrag
+		// add to cache for processing(ttl bounded)
+		ttl := p.registerTtl(jreq.Ttl) // ms
+		jreq.Ttl = ttl / 1000
+		jreq.setLifetime(ttl)
+		item := NewCacheItemEx(jreq, ttl)
+		key := jreq.iceKey()
 		Inst().Cache().Set(key, item)
+		Inst().Metrics().incRegistered()
+		Inst().Backends().Acquire(key, backend, ttl)
+		if registry := Inst().Registry(); registry != nil {
+			registry.Publish(key, jreq)
+		}
+		Inst().Webhook().Post(NewWebhookEvent(kEventSessionRegistered, jreq.SessionKey, key))
 	}
 
-	resp := RegisterResponse{
+	resp := &RegisterResponse{
 		SessionKey: jreq.SessionKey,
 		Candidates: candidates,
+		Routing:    decision,
 	}
-	data, err := json.Marshal(resp)
-	if err != nil {
-		return err
-	} else {
-		w.Write(data)
-		return nil
+	if backend != nil {
+		resp.Backend = backend.Name
+	}
+	if isOptimal {
+		resp.Ttl = jreq.Ttl
+		resp.IceServers = Inst().IceServers(jreq.SessionKey)
+	}
+	if jreq.isSdpMode() {
+		if isOptimal {
+			answer := util.UpdateSdpCandidates([]byte(jreq.AnswerSdp), candidates)
+			if jreq.IceLite {
+				answer = util.SetSdpIceLite(answer)
+			}
+			resp.AnswerSdp = string(answer)
+		} else {
+			resp.AnswerSdp = jreq.AnswerSdp
+		}
 	}
+	return resp, code, nil
+}
+
+// registerTtl returns ttl(ms) of registration, the request ttl(s) is bounded by config.
+func (p *HttpServerHandler) registerTtl(ttl int) int {
+	if ttl <= 0 {
+		return int(p.Config.RegisterTtl / time.Millisecond)
+	}
+	value := time.Duration(ttl) * time.Second
+	if value < p.Config.MinRegisterTtl {
+		value = p.Config.MinRegisterTtl
+	} else if value > p.Config.MaxRegisterTtl {
+		value = p.Config.MaxRegisterTtl
+	}
+	return int(value / time.Millisecond)
+}
+
+// handleDelete disposes the session with key(session_key or ice key) at once.
+func (p *HttpServerHandler) handleDelete(w http.ResponseWriter, key string) *ApiError {
+	if len(key) == 0 {
+		return NewApiError(http.StatusBadRequest, kApiCodeBadBody, "no session key")
+	}
+
+	log.Println(p.TAG, "http delete session=", key)
+	if err := Inst().DeleteSession(key); err != nil {
+		return NewApiError(http.StatusNotFound, kApiCodeNotFound, err.Error())
+	}
+
+	writeApiData(w, kApiCodeOK, &SessionResponse{key})
+	return nil
 }
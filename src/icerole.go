@@ -0,0 +1,145 @@
+package webrtc
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+
+	"github.com/PeterXu/xrtc/util"
+)
+
+// ice roles of xrtc on the client leg(RFC 8445)
+const (
+	kIceRoleControlled  = "controlled"
+	kIceRoleControlling = "controlling"
+)
+
+// the priority of peer-reflexive candidate in binding requests(RFC 8445 7.1.1),
+// type preference 110, local preference 65535, component 1.
+const kIcePrflxPriority = (110 << 24) | (65535 << 8) | (256 - 1)
+
+// stun error code of role conflict(RFC 8445 7.3.1.1)
+const kStunErrorRoleConflict = 487
+
+var errIceRole = errors.New("ice_role should be controlled or controlling")
+var errIceLiteRole = errors.New("ice-lite should be controlled")
+
+// checkIceRole returns error for unknown role, "" is controlled.
+func checkIceRole(role string, lite bool) error {
+	switch role {
+	case "", kIceRoleControlled:
+		return nil
+	case kIceRoleControlling:
+		if lite {
+			return errIceLiteRole
+		}
+		return nil
+	}
+	return errIceRole
+}
+
+// IceRole is the ice role of one session on the client leg,
+// which is switched on role conflict by tie-breaker(RFC 8445 7.3.1.1).
+// The ice-lite is always controlled and never sends checks.
+type IceRole struct {
+	controlling int32 // atomic, 1 for controlling
+	tieBreaker  uint64
+	lite        bool
+}
+
+func NewIceRole(role string, lite bool) *IceRole {
+	r := &IceRole{tieBreaker: newTieBreaker(), lite: lite}
+	if role == kIceRoleControlling && !lite {
+		r.controlling = 1
+	}
+	return r
+}
+
+// newTieBreaker returns one random uint64.
+func newTieBreaker() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+func (r *IceRole) isControlling() bool {
+	return atomic.LoadInt32(&r.controlling) == 1
+}
+
+func (r *IceRole) setControlling(controlling bool) {
+	var value int32
+	if controlling {
+		value = 1
+	}
+	atomic.StoreInt32(&r.controlling, value)
+}
+
+func (r *IceRole) isLite() bool {
+	return r.lite
+}
+
+func (r *IceRole) String() string {
+	if r.lite {
+		return "lite"
+	}
+	if r.isControlling() {
+		return kIceRoleControlling
+	}
+	return kIceRoleControlled
+}
+
+// switchRole changes role, e.g. on 487 response to our request(RFC 8445 7.2.5.1).
+func (r *IceRole) switchRole() {
+	if r.lite {
+		return
+	}
+	r.setControlling(!r.isControlling())
+}
+
+// attributes returns PRIORITY, ICE-CONTROLLING/ICE-CONTROLLED and USE-CANDIDATE(controlling)
+// for our binding requests.
+func (r *IceRole) attributes() []util.StunAttribute {
+	attrs := []util.StunAttribute{util.NewStunUInt32Attribute(util.STUN_ATTR_PRIORITY, kIcePrflxPriority)}
+	if r.isControlling() {
+		attrs = append(attrs, util.NewStunUInt64Attribute(util.STUN_ATTR_ICE_CONTROLLING, r.tieBreaker))
+		// aggressive nomination, the only pair to client
+		attrs = append(attrs, util.NewStunByteStringAttribute(util.STUN_ATTR_USE_CANDIDATE, nil))
+	} else {
+		attrs = append(attrs, util.NewStunUInt64Attribute(util.STUN_ATTR_ICE_CONTROLLED, r.tieBreaker))
+	}
+	return attrs
+}
+
+// checkRequest checks role conflict of one binding request(RFC 8445 7.3.1.1).
+// It returns true if 487(Role Conflict) should be responded,
+// or else switches role if required and returns false.
+func (r *IceRole) checkRequest(req *util.StunMessage) bool {
+	controlling := r.isControlling()
+	var atype util.StunAttributeType = util.STUN_ATTR_ICE_CONTROLLED
+	if controlling {
+		atype = util.STUN_ATTR_ICE_CONTROLLING
+	}
+	attr, ok := req.GetAttribute(atype).(*util.StunUInt64Attribute)
+	if !ok {
+		// no conflict
+		return false
+	}
+	if r.lite {
+		// the full peer should be controlling(RFC 8445 6.1.1)
+		return true
+	}
+
+	if r.tieBreaker >= attr.Value() {
+		if controlling {
+			return true
+		}
+		r.setControlling(true)
+	} else {
+		if !controlling {
+			return true
+		}
+		r.setControlling(false)
+	}
+	return false
+}
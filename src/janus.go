@@ -0,0 +1,330 @@
+package webrtc
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/PeterXu/xrtc/util"
+	util "github.com/PeterXu/xrtc/util"
+)
+
+// Janus api-aware reverse proxy (rest/websocket).
+const (
+	kApiJanus             = "/janus"
+	kJanusHandleTimeout   = 24 * 3600 * 1000 // ms
+	kJanusUpstreamTimeout = 60 * time.Second // more than long-poll(30s)
+	kJanusDialTimeout     = 5 * time.Second
+)
+
+var gJanusClient = &http.Client{Timeout: kJanusUpstreamTimeout}
+
+// JanusHandle is the negotiation state of one janus plugin handle.
+// The sdp of client is registered as "offer" and the sdp of janus as "answer",
+// no matter which side offers.
+type JanusHandle struct {
+	sync.Mutex
+	clientSdp string
+	serverSdp string
+	proxied   bool
+}
+
+func janusCacheKey(session, handle string) string {
+	return "janus:" + session + ":" + handle
+}
+
+// janusString returns the string/number field of janus message.
+func janusString(msg map[string]interface{}, key string) string {
+	switch v := msg[key].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return ""
+	}
+}
+
+// decodeJanus keeps the 64-bit ids of janus by json.Number.
+func decodeJanus(data []byte) (interface{}, error) {
+	var msg interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(&msg)
+	return msg, err
+}
+
+// handleJanus proxies janus rest(/janus/{session}/{handle}) or websocket api.
+func (p *HttpServerHandler) handleJanus(w http.ResponseWriter, r *http.Request) {
+	if util.IsWsUpgrade(r) {
+		p.handleJanusWs(w, r)
+		return
+	}
+
+	if len(p.Config.JanusUpstream) == 0 {
+		writeApiError(w, NewApiError(http.StatusNotFound, kApiCodeNotFound, "no janus upstream"))
+		return
+	}
+
+	var session, handle string
+	rest := strings.TrimPrefix(r.URL.Path, kApiJanus)
+	if parts := strings.Split(strings.Trim(rest, "/"), "/"); len(parts) >= 2 {
+		session, handle = parts[0], parts[1]
+	}
+
+	body, err := util.ReadHttpBody(r.Body, r.Header.Get("Content-Encoding"))
+	if err != nil {
+		writeApiError(w, NewApiError(http.StatusBadRequest, kApiCodeBadBody, err.Error()))
+		return
+	}
+	if len(body) > 0 {
+		body = p.onJanusRequest(r.RemoteAddr, session, handle, body)
+	}
+
+	upstream := strings.TrimRight(p.Config.JanusUpstream, "/") + rest
+	if len(r.URL.RawQuery) > 0 {
+		upstream += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequest(r.Method, upstream, bytes.NewReader(body))
+	if err != nil {
+		writeApiError(w, NewApiError(http.StatusBadGateway, kApiCodeUpstream, err.Error()))
+		return
+	}
+	if ctype := r.Header.Get("Content-Type"); len(ctype) > 0 {
+		req.Header.Set("Content-Type", ctype)
+	}
+
+	resp, err := gJanusClient.Do(req)
+	if err != nil {
+		log.Warnln(p.TAG, "janus upstream error:", err)
+		writeApiError(w, NewApiError(http.StatusBadGateway, kApiCodeUpstream, err.Error()))
+		return
+	}
+	rbody, err := util.ReadHttpBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		writeApiError(w, NewApiError(http.StatusBadGateway, kApiCodeUpstream, err.Error()))
+		return
+	}
+
+	if data := p.onJanusEvent(r.RemoteAddr, rbody); data != nil {
+		rbody = data
+	} else {
+		// the dropped event(e.g. trickle) in long-poll
+		rbody = []byte(`{"janus": "keepalive"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(rbody)
+}
+
+// handleJanusWs proxies janus websocket messages in two directions.
+func (p *HttpServerHandler) handleJanusWs(w http.ResponseWriter, r *http.Request) {
+	if len(p.Config.JanusWsUpstream) == 0 {
+		writeApiError(w, NewApiError(http.StatusNotFound, kApiCodeNotFound, "no janus websocket upstream"))
+		return
+	}
+
+	server, protocol, err := util.WsDial(p.Config.JanusWsUpstream,
+		r.Header.Get("Sec-WebSocket-Protocol"), kJanusDialTimeout)
+	if err != nil {
+		log.Warnln(p.TAG, "janus websocket upstream error:", err)
+		writeApiError(w, NewApiError(http.StatusBadGateway, kApiCodeUpstream, err.Error()))
+		return
+	}
+
+	client, err := util.WsUpgrade(w, r, protocol)
+	if err != nil {
+		log.Warnln(p.TAG, "janus websocket upgrade error:", err)
+		server.Close()
+		return
+	}
+
+	raddr := r.RemoteAddr
+	log.Println(p.TAG, "janus websocket begin", raddr)
+
+	done := make(chan bool, 2)
+	pump := func(from, to *util.WsConn, filter func(data []byte) []byte) {
+		defer func() { done <- true }()
+		for {
+			opcode, data, err := from.ReadMessage()
+			if err != nil {
+				return
+			}
+			if opcode == util.WsTextMessage {
+				if data = filter(data); data == nil {
+					continue
+				}
+			}
+			if err := to.WriteMessage(opcode, data); err != nil || opcode == util.WsCloseMessage {
+				return
+			}
+		}
+	}
+
+	go pump(client, server, func(data []byte) []byte {
+		return p.onJanusRequest(raddr, "", "", data)
+	})
+	go pump(server, client, func(data []byte) []byte {
+		return p.onJanusEvent(raddr, data)
+	})
+
+	<-done
+	client.Close()
+	server.Close()
+	log.Println(p.TAG, "janus websocket end", raddr)
+}
+
+// onJanusRequest checks the jsep(sdp) of client, and returns the message to janus.
+// The session/handle are from rest path, or in message for websocket.
+func (p *HttpServerHandler) onJanusRequest(raddr, session, handle string, data []byte) []byte {
+	v, err := decodeJanus(data)
+	if err != nil {
+		return data
+	}
+	msg, ok := v.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	if len(session) == 0 {
+		session = janusString(msg, "session_id")
+		handle = janusString(msg, "handle_id")
+	}
+
+	switch janusString(msg, "janus") {
+	case "message":
+		if jsep, ok := msg["jsep"].(map[string]interface{}); ok {
+			if sdp := janusString(jsep, "sdp"); len(sdp) > 0 {
+				// janus gets the same sdp(munged by policy) as registered
+				jsep["sdp"] = p.onJanusSdp(raddr, session, handle, janusString(jsep, "type"), sdp, true)
+				if out, err := json.Marshal(msg); err == nil {
+					return out
+				}
+			}
+		}
+	case "detach":
+		Inst().Cache().Delete(janusCacheKey(session, handle))
+	}
+	return data
+}
+
+// onJanusEvent rewrites the jsep(sdp) of janus, and returns nil if dropped.
+// The long-poll of rest api may return an array of events.
+func (p *HttpServerHandler) onJanusEvent(raddr string, data []byte) []byte {
+	v, err := decodeJanus(data)
+	if err != nil {
+		return data
+	}
+
+	switch msg := v.(type) {
+	case map[string]interface{}:
+		if !p.checkJanusEvent(raddr, msg) {
+			return nil
+		}
+	case []interface{}:
+		var events []interface{}
+		for _, item := range msg {
+			if ev, ok := item.(map[string]interface{}); ok && !p.checkJanusEvent(raddr, ev) {
+				continue
+			}
+			events = append(events, item)
+		}
+		v = events
+	default:
+		return data
+	}
+
+	if out, err := json.Marshal(v); err == nil {
+		return out
+	}
+	return data
+}
+
+// checkJanusEvent returns false if the event should be dropped.
+func (p *HttpServerHandler) checkJanusEvent(raddr string, msg map[string]interface{}) bool {
+	session := janusString(msg, "session_id")
+	handle := janusString(msg, "sender")
+
+	switch janusString(msg, "janus") {
+	case "trickle":
+		// the candidates of janus are replaced by proxy
+		if item := Inst().Cache().Get(janusCacheKey(session, handle)); item != nil {
+			state := item.data.(*JanusHandle)
+			state.Lock()
+			proxied := state.proxied
+			state.Unlock()
+			if proxied {
+				return false
+			}
+		}
+	case "detached":
+		Inst().Cache().Delete(janusCacheKey(session, handle))
+	}
+
+	if jsep, ok := msg["jsep"].(map[string]interface{}); ok {
+		if sdp := janusString(jsep, "sdp"); len(sdp) > 0 {
+			jsep["sdp"] = p.onJanusSdp(raddr, session, handle, janusString(jsep, "type"), sdp, false)
+		}
+	}
+	return true
+}
+
+// onJanusSdp saves sdp of one handle, and registers both when the answer arrives.
+// It returns the sdp(with proxy candidates for janus) to the other side.
+func (p *HttpServerHandler) onJanusSdp(raddr, session, handle, stype, sdp string, fromClient bool) string {
+	if len(session) == 0 || len(handle) == 0 {
+		return sdp
+	}
+
+	key := janusCacheKey(session, handle)
+	var state *JanusHandle
+	if item := Inst().Cache().Get(key); item != nil {
+		state = item.data.(*JanusHandle)
+	} else {
+		state = &JanusHandle{}
+		Inst().Cache().Set(key, NewCacheItemEx(state, kJanusHandleTimeout))
+	}
+	state.Lock()
+	defer state.Unlock()
+
+	sdp = p.policy.Apply(sdp)
+	out := sdp
+	if fromClient {
+		state.clientSdp = sdp
+	} else {
+		state.serverSdp = sdp
+		candidates, decision, err := p.selectCandidates(raddr, util.GetSdpCandidates([]byte(sdp)))
+		if err != nil {
+			log.Warnln(p.TAG, "janus select candidates err:", err)
+		}
+		state.proxied = (decision != nil && decision.UseProxy)
+		if state.proxied {
+			out = string(util.UpdateSdpCandidates([]byte(sdp), candidates))
+		}
+	}
+
+	if stype != "answer" || !state.proxied || len(state.clientSdp) == 0 || len(state.serverSdp) == 0 {
+		return out
+	}
+
+	jreq := &RegisterRequest{
+		SessionKey: session + ":" + handle,
+		OfferSdp:   state.clientSdp,
+		AnswerSdp:  state.serverSdp,
+	}
+	if err := jreq.loadSdp(); err != nil {
+		log.Warnln(p.TAG, "janus load sdp err:", err)
+		return out
+	}
+	if _, _, err := p.registerRequest(raddr, jreq); err != nil {
+		log.Warnln(p.TAG, "janus register err:", err)
+		return out
+	}
+	if jreq.IceLite {
+		// only when this session is registered and answered by xrtc
+		out = string(util.SetSdpIceLite([]byte(out)))
+	}
+	return out
+}
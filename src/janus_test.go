@@ -0,0 +1,309 @@
+package webrtc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PeterXu/xrtc/util"
+)
+
+const kTestProxyCandidate = "a=candidate:1 1 udp 2013266431 1.2.3.4 6000 typ host"
+
+const kJanusClientSdp = "v=0\r\n" +
+	"o=- 1 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=ice-ufrag:cliu\r\n" +
+	"a=ice-pwd:clientpasswordclientpass\r\n" +
+	"a=mid:0\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n"
+
+const kJanusServerSdp = "v=0\r\n" +
+	"o=- 3 4 IN IP4 10.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"c=IN IP4 10.0.0.1\r\n" +
+	"a=ice-ufrag:janu\r\n" +
+	"a=ice-pwd:januspasswordjanuspasswo\r\n" +
+	"a=mid:0\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=candidate:1 1 udp 2013266431 10.0.0.1 8000 typ host\r\n"
+
+// testServer is one ice listener with candidates only.
+type testServer struct {
+	params NetParams
+}
+
+func (s *testServer) Run()               {}
+func (s *testServer) Close()             {}
+func (s *testServer) Params() *NetParams { return &s.params }
+
+// newTestHub installs a global hub with one proxy candidate.
+func newTestHub(t *testing.T) *MaxHub {
+	hub := NewMaxHub(NewMemCache(0))
+	hub.AddServer(&testServer{NetParams{EnableIce: true, Candidates: []string{kTestProxyCandidate}}})
+	gMutex.Lock()
+	gMaxHub = hub
+	gMutex.Unlock()
+	t.Cleanup(func() {
+		gMutex.Lock()
+		gMaxHub = nil
+		gMutex.Unlock()
+		hub.Close()
+	})
+	return hub
+}
+
+// wsFrame returns one unmasked frame(payload < 126).
+func wsFrame(fin bool, opcode int, payload string) []byte {
+	head := byte(opcode)
+	if fin {
+		head |= 0x80
+	}
+	return append([]byte{head, byte(len(payload))}, payload...)
+}
+
+func TestWsConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	server := util.NewWsConn(a, nil, false)
+	client := util.NewWsConn(b, nil, true)
+
+	// the client frames are masked, and lengths of 7/16/64 bits
+	for _, size := range []int{5, 200, 70000} {
+		data := bytes.Repeat([]byte("x"), size)
+		go client.WriteMessage(util.WsBinaryMessage, data)
+		var head [2]byte
+		if _, err := io.ReadFull(a, head[:]); err != nil {
+			t.Fatal(err)
+		}
+		if head[0] != 0x80|util.WsBinaryMessage || head[1]&0x80 == 0 {
+			t.Fatalf("wrong frame head: %x", head)
+		}
+		length, ext := int(head[1]&0x7F), 0
+		if length == 126 {
+			ext = 2
+		} else if length == 127 {
+			ext = 8
+		}
+		frame := make([]byte, ext+4+size)
+		if _, err := io.ReadFull(a, frame); err != nil {
+			t.Fatal(err)
+		}
+		payload := frame[ext+4:]
+		if bytes.Equal(payload, data) && frame[ext] != 0 {
+			t.Errorf("size %d: payload not masked", size)
+		}
+		for i := range payload {
+			payload[i] ^= frame[ext+i%4]
+		}
+		if !bytes.Equal(payload, data) {
+			t.Errorf("size %d: wrong unmasked payload", size)
+		}
+	}
+
+	// round trip
+	go client.WriteMessage(util.WsTextMessage, []byte("hello"))
+	if op, data, err := server.ReadMessage(); err != nil || op != util.WsTextMessage || string(data) != "hello" {
+		t.Errorf("wrong message: %d, %q, %v", op, data, err)
+	}
+
+	// fragments with a ping between them
+	go func() {
+		a.Write(wsFrame(false, util.WsTextMessage, "hel"))
+		a.Write(wsFrame(false, util.WsContinuation, "lo "))
+		a.Write(wsFrame(true, util.WsPingMessage, "p"))
+		a.Write(wsFrame(true, util.WsContinuation, "janus"))
+		a.Write(wsFrame(true, util.WsTextMessage, "next"))
+	}()
+	for _, want := range []struct {
+		op   int
+		data string
+	}{
+		{util.WsPingMessage, "p"},
+		{util.WsTextMessage, "hello janus"},
+		{util.WsTextMessage, "next"},
+	} {
+		op, data, err := client.ReadMessage()
+		if err != nil || op != want.op || string(data) != want.data {
+			t.Errorf("wrong message: %d, %q, %v", op, data, err)
+		}
+	}
+
+	// continuation without the first fragment
+	go a.Write(wsFrame(true, util.WsContinuation, "x"))
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Errorf("expect error for continuation only")
+	}
+}
+
+func checkJanusAnswer(t *testing.T, sdp string) {
+	if !strings.Contains(sdp, kTestProxyCandidate) || strings.Contains(sdp, "10.0.0.1 8000") {
+		t.Errorf("janus candidates not replaced:\n%s", sdp)
+	}
+	if item := Inst().Cache().Get("janu:cliu"); item == nil {
+		t.Errorf("janus session not registered")
+	}
+}
+
+func TestJanusRest(t *testing.T) {
+	newTestHub(t)
+
+	offers := make(chan string, 1)
+	janus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			var msg struct {
+				Jsep struct {
+					Sdp string `json:"sdp"`
+				} `json:"jsep"`
+			}
+			json.NewDecoder(r.Body).Decode(&msg)
+			offers <- msg.Jsep.Sdp
+		}
+		if r.Method == http.MethodGet {
+			// long-poll: answer then trickle
+			json.NewEncoder(w).Encode([]interface{}{
+				map[string]interface{}{"janus": "event", "session_id": 11, "sender": 22,
+					"jsep": map[string]string{"type": "answer", "sdp": kJanusServerSdp}},
+				map[string]interface{}{"janus": "trickle", "session_id": 11, "sender": 22,
+					"candidate": map[string]string{"candidate": "candidate:2 1 udp 1 10.0.0.1 8001 typ host"}},
+			})
+			return
+		}
+		w.Write([]byte(`{"janus": "ack", "session_id": 11}`))
+	}))
+	defer janus.Close()
+
+	handler := NewHttpServeHandler("test", &HttpParams{ProxyMode: kProxyAlways, JanusUpstream: janus.URL + "/janus",
+		IceLite: true, SdpPolicy: &SdpPolicyParams{RtcpMux: true}})
+	svr := httptest.NewServer(handler)
+	defer svr.Close()
+
+	body := `{"janus": "message", "body": {}, "jsep": {"type": "offer", "sdp": ` + jsonString(kJanusClientSdp) + `}}`
+	resp, err := http.Post(svr.URL+"/janus/11/22", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// janus gets the offer munged by policy, the same as registered
+	if offer := <-offers; !strings.Contains(offer, "a=rtcp-mux") || strings.Contains(kJanusClientSdp, "a=rtcp-mux") {
+		t.Errorf("offer to janus not munged:\n%s", offer)
+	}
+
+	resp, err = http.Get(svr.URL + "/janus/11?maxev=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var events []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0]["janus"] != "event" {
+		t.Fatalf("trickle of janus not dropped: %v", events)
+	}
+	jsep, _ := events[0]["jsep"].(map[string]interface{})
+	sdp, _ := jsep["sdp"].(string)
+	checkJanusAnswer(t, sdp)
+	if !strings.Contains(sdp, "a=ice-lite") {
+		t.Errorf("no ice-lite in answer of registered session")
+	}
+	if item := Inst().Cache().Get("janu:cliu"); item == nil || !item.data.(*RegisterRequest).terminatesIce() {
+		t.Errorf("ice-lite session is not answered by xrtc")
+	} else if offer := item.data.(*RegisterRequest).OfferSdp; !strings.Contains(offer, "a=rtcp-mux") {
+		t.Errorf("registered offer not munged:\n%s", offer)
+	}
+}
+
+func TestJanusWebsocket(t *testing.T) {
+	newTestHub(t)
+
+	janus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := util.WsUpgrade(w, r, r.Header.Get("Sec-WebSocket-Protocol"))
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if op != util.WsTextMessage || !strings.Contains(string(data), `"jsep"`) {
+				continue
+			}
+			trickle, _ := json.Marshal(map[string]interface{}{"janus": "trickle", "session_id": 11, "sender": 22,
+				"candidate": map[string]string{"candidate": "candidate:2 1 udp 1 10.0.0.1 8001 typ host"}})
+			event, _ := json.Marshal(map[string]interface{}{"janus": "event", "session_id": 11, "sender": 22,
+				"jsep": map[string]string{"type": "answer", "sdp": kJanusServerSdp}})
+			conn.WriteMessage(util.WsPingMessage, []byte("ping"))
+			conn.WriteMessage(util.WsTextMessage, event)
+			conn.WriteMessage(util.WsTextMessage, trickle)
+			conn.WriteMessage(util.WsTextMessage, []byte(`{"janus": "keepalive"}`))
+		}
+	}))
+	defer janus.Close()
+
+	handler := NewHttpServeHandler("test", &HttpParams{ProxyMode: kProxyAlways,
+		JanusWsUpstream: "ws" + strings.TrimPrefix(janus.URL, "http")})
+	svr := httptest.NewServer(handler)
+	defer svr.Close()
+
+	client, protocol, err := util.WsDial("ws"+strings.TrimPrefix(svr.URL, "http")+"/janus", "janus-protocol", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if protocol != "janus-protocol" {
+		t.Errorf("wrong protocol: %s", protocol)
+	}
+
+	msg := `{"janus": "message", "session_id": 11, "handle_id": 22, "body": {}, "jsep": {"type": "offer", "sdp": ` +
+		jsonString(kJanusClientSdp) + `}}`
+	if err := client.WriteMessage(util.WsTextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	for len(events) < 2 {
+		op, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op == util.WsPingMessage {
+			continue
+		}
+		events = append(events, string(data))
+	}
+	// the trickle is dropped between event and keepalive
+	var event struct {
+		Janus string `json:"janus"`
+		Jsep  struct {
+			Sdp string `json:"sdp"`
+		} `json:"jsep"`
+	}
+	if err := json.Unmarshal([]byte(events[0]), &event); err != nil || event.Janus != "event" {
+		t.Fatalf("wrong event: %s", events[0])
+	}
+	if !strings.Contains(events[1], "keepalive") {
+		t.Errorf("trickle of janus not dropped: %s", events[1])
+	}
+	checkJanusAnswer(t, event.Jsep.Sdp)
+}
+
+func jsonString(value string) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
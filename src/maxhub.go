@@ -1,6 +1,8 @@
 package webrtc
 
 import (
+	"bytes"
+	"errors"
 	"net"
 	"strings"
 	"time"
@@ -26,6 +28,21 @@ func NewHubMessage(data []byte, from net.Addr, to net.Addr, misc interface{}) *H
 	return &HubMessage{data, from, to, misc}
 }
 
+// admin commands over chanAdmin
+const (
+	kAdminDeleteSession = "delete_session" // args: session_key or ice key
+)
+
+type AdminCommand struct {
+	name   string
+	args   []string
+	result chan error
+}
+
+func NewAdminCommand(name string, args ...string) *AdminCommand {
+	return &AdminCommand{name, args, make(chan error, 1)}
+}
+
 type MaxHub struct {
 	TAG string
 
@@ -34,7 +51,28 @@ type MaxHub struct {
 	servers     []OneServer
 
 	// cache control
-	cache *Cache
+	cache Cache
+
+	// session events
+	webhook *Webhook
+
+	// webrtc servers for room
+	backends *BackendPool
+
+	// shared sessions between nodes
+	registry SessionRegistry
+
+	// session counters
+	metrics *Metrics
+
+	// geo routing
+	routing *RoutingPolicy
+
+	// peer proxy nodes in other regions
+	proxies []*ProxyGroup
+
+	// turn server on ice ports
+	turn *TurnServer
 
 	// data from outer client(over udpsvr/tcpsvr)
 	chanRecvFromOuter chan interface{}
@@ -46,21 +84,123 @@ type MaxHub struct {
 	exitTick chan bool
 }
 
-func NewMaxHub() *MaxHub {
+func NewMaxHub(cache Cache) *MaxHub {
 	hub := &MaxHub{
 		TAG:               "[MAXHUB]",
 		connections:       make(map[string]*Connection),
 		clients:           make(map[string]*User),
-		cache:             NewCache(),
+		cache:             cache,
+		metrics:           NewMetrics(),
+		routing:           NewRoutingPolicy(&kDefaultRoutingParams),
 		chanRecvFromOuter: make(chan interface{}, 1000), // unblocking mode, data from udpsvr
 		chanAdmin:         make(chan interface{}, 10),   // data from admin/control
 		exitTick:          make(chan bool),
 	}
+	cache.OnExpired(hub.onCacheExpired)
 	go hub.Run()
 	return hub
 }
 
 func (h *MaxHub) OnAdminData(msg *HubMessage) {
+	cmd, ok := msg.misc.(*AdminCommand)
+	if !ok {
+		log.Warnln(h.TAG, "invalid admin data")
+		return
+	}
+
+	var err error
+	switch cmd.name {
+	case kAdminDeleteSession:
+		if len(cmd.args) != 1 {
+			err = errors.New("invalid args for " + cmd.name)
+		} else {
+			err = h.deleteSession(cmd.args[0])
+		}
+	default:
+		err = errors.New("unknown admin command: " + cmd.name)
+	}
+	cmd.result <- err
+}
+
+// sendAdminCommand posts one command to hub and waits for its result.
+func (h *MaxHub) sendAdminCommand(cmd *AdminCommand) error {
+	h.chanAdmin <- NewHubMessage(nil, nil, nil, cmd)
+	return <-cmd.result
+}
+
+// DeleteSession disposes a session(user/connections/service) immediately.
+// The key is the session_key of register request or ice key("answer_ufrag:offer_ufrag").
+// In cluster, it is also removed from other nodes, and fails only if no node has it.
+func (h *MaxHub) DeleteSession(key string) error {
+	err := h.deleteLocalSession(key)
+	if h.registry != nil && h.registry.Remove(key) == nil {
+		return nil
+	}
+	return err
+}
+
+// deleteLocalSession disposes a session only in this node.
+func (h *MaxHub) deleteLocalSession(key string) error {
+	return h.sendAdminCommand(NewAdminCommand(kAdminDeleteSession, key))
+}
+
+// findSessionIceKey returns ice key of a session in clients or cache.
+func (h *MaxHub) findSessionIceKey(key string) string {
+	if _, ok := h.clients[key]; ok {
+		return key
+	}
+	if h.cache.Get(key) != nil {
+		return key
+	}
+
+	for k, v := range h.clients {
+		if v.getSessionKey() == key {
+			return k
+		}
+	}
+
+	// the session maybe not connected (only in cache)
+	iceKey := ""
+	h.cache.Range(func(k string, item *CacheItem) bool {
+		if request, ok := item.data.(*RegisterRequest); ok && request.SessionKey == key {
+			iceKey = k
+			return false
+		}
+		return true
+	})
+	return iceKey
+}
+
+func (h *MaxHub) deleteSession(key string) error {
+	if len(key) == 0 {
+		return errors.New("empty session key")
+	}
+
+	iceKey := h.findSessionIceKey(key)
+	if len(iceKey) == 0 {
+		return errors.New("no session for " + key)
+	}
+
+	log.Println(h.TAG, "delete session:", key, iceKey)
+	if user, ok := h.clients[iceKey]; ok {
+		h.removeUser(iceKey, user)
+	} else {
+		// only in cache, not stunned yet
+		h.backends.Release(iceKey)
+	}
+	h.cache.Delete(iceKey)
+	return nil
+}
+
+// removeUser disposes user and all its connections.
+func (h *MaxHub) removeUser(key string, user *User) {
+	for addr, conn := range user.connections {
+		conn.dispose()
+		delete(h.connections, addr)
+	}
+	user.dispose(kCloseReasonDeleted)
+	delete(h.clients, key)
+	h.backends.Release(key)
 }
 
 func (h *MaxHub) findConnection(addr net.Addr) *Connection {
@@ -73,8 +213,11 @@ func (h *MaxHub) findConnection(addr net.Addr) *Connection {
 
 func (h *MaxHub) handleStunBindingRequest(data []byte, addr net.Addr, misc interface{}) {
 	var msg util.IceMessage
-	if !msg.Read(data) {
-		log.Warnln(h.TAG, "invalid stun message")
+	if err := msg.Decode(data); err != nil {
+		log.Warnln(h.TAG, "invalid stun message, dtype=", msg.Dtype, err)
+		if err.Code != 0 && msg.Dtype == util.STUN_BINDING_REQUEST {
+			h.sendStunErrorResponse(&msg.StunMessage, err, addr, misc)
+		}
 		return
 	}
 
@@ -106,17 +249,33 @@ func (h *MaxHub) handleStunBindingRequest(data []byte, addr net.Addr, misc inter
 				}
 			}
 			if request == nil {
+				if h.registry != nil {
+					// maybe registered at other node, resolved for next stun
+					h.registry.Resolve(stunName)
+				}
 				log.Warnln(h.TAG, "invalid ice for user")
 				return
 			}
 			iceTcp := false
-			iceDirect := true
+			iceDirect := !request.terminatesIce()
+			if request.setStunned() {
+				h.metrics.incStunned()
+				if h.registry != nil {
+					// the copies in other nodes are not reported as unreached
+					h.registry.Claim(stunName)
+				}
+			}
 			user = NewUser(iceTcp, iceDirect)
+			user.setSessionKey(request.SessionKey)
+			user.setWebhook(h.webhook)
+			user.setIceRole(request.IceRole, request.IceLite)
 			if !user.setIceInfo(&request.OfferIce, &request.AnswerIce, request.Candidates) {
 				log.Warnln(h.TAG, "invalid ice for user")
 				return
 			}
 			h.clients[stunName] = user
+			h.backends.Bind(stunName)
+			user.notify(kEventSessionStun)
 		} else {
 			log.Warnln(h.TAG, "another connection for user-stun=", stunName)
 		}
@@ -137,6 +296,41 @@ func (h *MaxHub) handleStunBindingRequest(data []byte, addr net.Addr, misc inter
 	}
 }
 
+// sendStunErrorResponse replies error(e.g. 420) to the request of known USERNAME,
+// which is signed by the pwd of answer. The others are dropped.
+func (h *MaxHub) sendStunErrorResponse(req *util.StunMessage, stunErr *util.StunError, addr net.Addr, misc interface{}) {
+	attr, ok := req.GetAttribute(util.STUN_ATTR_USERNAME).(*util.StunByteStringAttribute)
+	if !ok {
+		return
+	}
+	stunName := string(attr.Data)
+
+	var pwd string
+	if user, ok := h.clients[stunName]; ok {
+		pwd = user.getSendIce().Pwd
+	} else if item := h.cache.Get(stunName); item != nil {
+		if request, ok := item.data.(*RegisterRequest); ok {
+			pwd = request.AnswerIce.Pwd
+		}
+	}
+	if len(pwd) == 0 {
+		log.Warnln(h.TAG, "no stun error response for unknown user:", stunName)
+		return
+	}
+
+	chanSend, ok := misc.(chan interface{})
+	if !ok {
+		log.Warnln(h.TAG, "no chanSend for this connection")
+		return
+	}
+	var buf bytes.Buffer
+	if !util.GenStunErrorResponse(&buf, pwd, req, stunErr) {
+		log.Warnln(h.TAG, "fail to gen stun error response")
+		return
+	}
+	chanSend <- NewHubMessage(buf.Bytes(), nil, addr, nil)
+}
+
 func (h *MaxHub) clearConnections() {
 	var connKeys []string
 	for k, v := range h.connections {
@@ -158,8 +352,9 @@ func (h *MaxHub) clearUsers() {
 	var userKeys []string
 	for k, v := range h.clients {
 		if v.isTimeout() {
-			v.dispose()
+			v.dispose(kCloseReasonTimeout)
 			userKeys = append(userKeys, k)
+			h.backends.Release(k)
 		}
 	}
 
@@ -198,10 +393,59 @@ func (h *MaxHub) AddServer(server OneServer) {
 	}
 }
 
-func (h *MaxHub) Cache() *Cache {
+func (h *MaxHub) Cache() Cache {
 	return h.cache
 }
 
+func (h *MaxHub) SetWebhook(webhook *Webhook) {
+	h.webhook = webhook
+}
+
+func (h *MaxHub) Webhook() *Webhook {
+	return h.webhook
+}
+
+func (h *MaxHub) SetBackends(backends *BackendPool) {
+	h.backends = backends
+}
+
+func (h *MaxHub) Backends() *BackendPool {
+	return h.backends
+}
+
+func (h *MaxHub) SetRegistry(registry SessionRegistry) {
+	h.registry = registry
+}
+
+func (h *MaxHub) Registry() SessionRegistry {
+	return h.registry
+}
+
+func (h *MaxHub) SetRouting(routing *RoutingPolicy) {
+	h.routing = routing
+}
+
+func (h *MaxHub) Routing() *RoutingPolicy {
+	return h.routing
+}
+
+func (h *MaxHub) Metrics() *Metrics {
+	return h.metrics
+}
+
+// onCacheExpired reports the registration expired without any client stun,
+// which means the client can't reach this proxy.
+// It is called in cache goroutine.
+func (h *MaxHub) onCacheExpired(key string, item *CacheItem) {
+	request, ok := item.data.(*RegisterRequest)
+	if !ok || request.isStunned() || request.replica {
+		return
+	}
+	log.Warnln(h.TAG, "session unreached, no stun for:", key, request.SessionKey)
+	h.metrics.incUnreached()
+	h.webhook.Post(NewWebhookEvent(kEventSessionUnreached, request.SessionKey, key))
+}
+
 func (h *MaxHub) Candidates() []string {
 	var candidates []string
 	for _, svr := range h.servers {
@@ -210,12 +454,104 @@ func (h *MaxHub) Candidates() []string {
 	return candidates
 }
 
+func (h *MaxHub) SetTurn(turn *TurnServer) {
+	h.turn = turn
+}
+
+func (h *MaxHub) Turn() *TurnServer {
+	return h.turn
+}
+
+// IceServers returns the stun servers and turn servers(with REST API credentials) of listeners for user,
+// or nil if both are disabled.
+func (h *MaxHub) IceServers(user string) []*IceServer {
+	var servers []*IceServer
+	stun := &IceServer{Urls: h.iceUrls("stun:", func(params *NetParams) bool {
+		return params.EnableStun
+	})}
+	if len(stun.Urls) > 0 {
+		servers = append(servers, stun)
+	}
+	username, credential := h.turn.Credentials(user)
+	if len(username) > 0 {
+		turn := &IceServer{Username: username, Credential: credential}
+		turn.Urls = h.iceUrls("turn:", func(params *NetParams) bool {
+			return params.EnableTurn
+		})
+		if len(turn.Urls) > 0 {
+			servers = append(servers, turn)
+		}
+	}
+	return servers
+}
+
+// iceUrls returns the urls of candidates in the listeners matched.
+// The stun urls are udp only(without transport).
+func (h *MaxHub) iceUrls(scheme string, match func(params *NetParams) bool) []string {
+	var urls []string
+	for _, svr := range h.servers {
+		params := svr.Params()
+		if !match(params) {
+			continue
+		}
+		for _, candidate := range params.Candidates {
+			cand := util.ParseCandidate(candidate)
+			if cand == nil {
+				continue
+			}
+			url := scheme + cand.HostPort()
+			if scheme == "stun:" {
+				if cand.Transport != "udp" {
+					continue
+				}
+			} else {
+				url += "?transport=" + cand.Transport
+			}
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// SetProxies sets the peer proxy nodes in other regions.
+func (h *MaxHub) SetProxies(nodes []*ProxyNodeParams) {
+	h.proxies = nil
+	for _, node := range nodes {
+		h.proxies = append(h.proxies, &ProxyGroup{
+			Node:       node.Name,
+			Region:     node.Region,
+			Candidates: node.Candidates,
+		})
+	}
+}
+
+// ProxyGroups returns local candidates grouped by region, then peer proxy nodes.
+// The peer nodes are only used in cluster mode, which share the registrations.
+func (h *MaxHub) ProxyGroups() []*ProxyGroup {
+	var groups []*ProxyGroup
+	for _, svr := range h.servers {
+		params := svr.Params()
+		groups = groupCandidates(groups, params.Candidates, params.Regions)
+	}
+	if h.registry != nil {
+		groups = append(groups, h.proxies...)
+	}
+	return groups
+}
+
 func (h *MaxHub) Close() {
 	for _, svr := range h.servers {
 		svr.Close()
 	}
 	h.exitTick <- true
 	h.cache.Close()
+	h.backends.Close()
+	if h.registry != nil {
+		h.registry.Close()
+	}
+	h.turn.Close()
+	h.webhook.Close()
+	setGeoIP(nil)
 }
 
 func (h *MaxHub) Run() {
@@ -228,10 +564,6 @@ func (h *MaxHub) Run() {
 	quit := false
 	for !quit {
 		select {
-		case msg, ok := <-h.chanAdmin:
-			if ok {
-				h.OnAdminData(msg.(*HubMessage))
-			}
 		case <-h.exitTick:
 			quit = true
 			errCh <- nil
@@ -251,6 +583,11 @@ func (h *MaxHub) loopForOuter(errCh chan error) {
 			if ok {
 				h.OnRecvFromOuter(msg.(*HubMessage))
 			}
+		case msg, ok := <-h.chanAdmin:
+			// the same goroutine with outer, for connections/clients
+			if ok {
+				h.OnAdminData(msg.(*HubMessage))
+			}
 		case <-tickChan:
 			h.clearConnections()
 			h.clearUsers()
@@ -0,0 +1,37 @@
+package webrtc
+
+import (
+	"sync/atomic"
+)
+
+// Metrics are the counters of sessions.
+type Metrics struct {
+	Registered int64 `json:"registered"` // registered for proxy
+	Stunned    int64 `json:"stunned"`    // first client stun arrived
+	Unreached  int64 `json:"unreached"`  // expired without any client stun
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+func (m *Metrics) incRegistered() {
+	atomic.AddInt64(&m.Registered, 1)
+}
+
+func (m *Metrics) incStunned() {
+	atomic.AddInt64(&m.Stunned, 1)
+}
+
+func (m *Metrics) incUnreached() {
+	atomic.AddInt64(&m.Unreached, 1)
+}
+
+// Snapshot returns a copy of current counters.
+func (m *Metrics) Snapshot() Metrics {
+	return Metrics{
+		Registered: atomic.LoadInt64(&m.Registered),
+		Stunned:    atomic.LoadInt64(&m.Stunned),
+		Unreached:  atomic.LoadInt64(&m.Unreached),
+	}
+}
@@ -0,0 +1,73 @@
+package webrtc
+
+import (
+	"math"
+
+	"github.com/PeterXu/xrtc/util"
+)
+
+// the node name of local proxy groups
+const kProxyNodeLocal = "local"
+
+// ProxyGroup is the candidates of one proxy node(local or peer) in one region.
+type ProxyGroup struct {
+	Node       string
+	Region     string
+	Candidates []string
+}
+
+// ip returns the ip of first candidate.
+func (g *ProxyGroup) ip() string {
+	if len(g.Candidates) == 0 {
+		return ""
+	}
+	return util.ParseCandidateIp(g.Candidates[0])
+}
+
+// groupCandidates groups local candidates by region tag, in order of first appearance.
+func groupCandidates(groups []*ProxyGroup, candidates, regions []string) []*ProxyGroup {
+	for idx, candidate := range candidates {
+		region := ""
+		if idx < len(regions) {
+			region = regions[idx]
+		}
+		var group *ProxyGroup
+		for _, g := range groups {
+			if g.Node == kProxyNodeLocal && g.Region == region {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			group = &ProxyGroup{Node: kProxyNodeLocal, Region: region}
+			groups = append(groups, group)
+		}
+		group.Candidates = append(group.Candidates, candidate)
+	}
+	return groups
+}
+
+// selectProxyGroup returns the group closest to client by geo distance.
+// The first group(local) is default if no geo info.
+func selectProxyGroup(client *GeoInfo, groups []*ProxyGroup, lookup func(ip string) *GeoInfo) *ProxyGroup {
+	if len(groups) == 0 {
+		return nil
+	}
+	best := groups[0]
+	if len(groups) == 1 || client == nil || !client.hasLocation() {
+		return best
+	}
+
+	bestDistance := math.MaxFloat64
+	for _, g := range groups {
+		geo := lookup(g.ip())
+		if geo == nil || !geo.hasLocation() {
+			continue
+		}
+		if distance := geoDistance(client, geo); distance < bestDistance {
+			best = g
+			bestDistance = distance
+		}
+	}
+	return best
+}
@@ -0,0 +1,137 @@
+package webrtc
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// routing compare methods
+const (
+	kRoutingCountry   = "country"   // same country with proxy, but not server
+	kRoutingContinent = "continent" // same continent with proxy, but not server
+	kRoutingAsn       = "asn"       // same asn with proxy, but not server
+	kRoutingDistance  = "distance"  // proxy is much closer than server
+)
+
+// proxy modes of one listener
+const (
+	kProxyAuto   = "auto"
+	kProxyAlways = "always"
+	kProxyNever  = "never"
+)
+
+// actions of override
+const (
+	kRoutingActionProxy  = "proxy"
+	kRoutingActionDirect = "direct"
+)
+
+const kDefaultDistanceRatio = 0.5
+
+const kEarthRadiusKm = 6371.0
+
+// RoutingDecision is the result of routing, returned in api for debugging.
+type RoutingDecision struct {
+	UseProxy bool     `json:"use_proxy"`
+	Policy   string   `json:"policy"` // mode/override/compare method
+	Reason   string   `json:"reason"`
+	Client   *GeoInfo `json:"client,omitempty"`
+	Proxy    *GeoInfo `json:"proxy,omitempty"`
+	Server   *GeoInfo `json:"server,omitempty"`
+	Node     string   `json:"node,omitempty"`   // the selected proxy node
+	Region   string   `json:"region,omitempty"` // region of the selected proxy
+}
+
+// RoutingPolicy decides whether client(src) uses proxy(mid) to server(dst),
+// (src->mid->dst) or (src->dst).
+type RoutingPolicy struct {
+	params RoutingParams
+}
+
+func NewRoutingPolicy(params *RoutingParams) *RoutingPolicy {
+	return &RoutingPolicy{params: *params}
+}
+
+// Check decides by listener mode first, then by geo of all ips.
+func (p *RoutingPolicy) Check(mode, srcIP, midIP, dstIP string) *RoutingDecision {
+	switch mode {
+	case kProxyAlways:
+		return &RoutingDecision{UseProxy: true, Policy: mode, Reason: "listener always proxy"}
+	case kProxyNever:
+		return &RoutingDecision{UseProxy: false, Policy: mode, Reason: "listener never proxy"}
+	}
+	return p.decide(lookupGeo(srcIP), lookupGeo(midIP), lookupGeo(dstIP))
+}
+
+func (p *RoutingPolicy) decide(src, mid, dst *GeoInfo) *RoutingDecision {
+	d := &RoutingDecision{Policy: p.params.Compare, Client: src, Proxy: mid, Server: dst}
+	if src == nil || mid == nil || dst == nil {
+		d.Reason = "no geo info"
+		return d
+	}
+	if len(src.Country) == 0 || len(dst.Country) == 0 {
+		// maybe src or dst is local ip.
+		// That means: src euqal-to mid, or mid euqal-to dst.
+		// Donot need to change.
+		d.Reason = "unknown country of client or server"
+		return d
+	}
+
+	for _, o := range p.params.Overrides {
+		if o.match(src.Country, dst.Country) {
+			d.Policy = "override"
+			d.UseProxy = (o.Action == kRoutingActionProxy)
+			d.Reason = fmt.Sprintf("override %s->%s: %s", o.Client, o.Server, o.Action)
+			return d
+		}
+	}
+
+	switch p.params.Compare {
+	case kRoutingContinent:
+		d.UseProxy = (src.Continent == mid.Continent && src.Continent != dst.Continent)
+		d.Reason = fmt.Sprintf("continent %s/%s/%s", src.Continent, mid.Continent, dst.Continent)
+	case kRoutingAsn:
+		d.UseProxy = (src.ASN != 0 && src.ASN == mid.ASN && src.ASN != dst.ASN)
+		d.Reason = fmt.Sprintf("asn %d/%d/%d", src.ASN, mid.ASN, dst.ASN)
+	case kRoutingDistance:
+		if !src.hasLocation() || !mid.hasLocation() || !dst.hasLocation() {
+			d.Reason = "unknown location"
+			return d
+		}
+		toMid := geoDistance(src, mid)
+		toDst := geoDistance(src, dst)
+		d.UseProxy = (toMid < toDst*p.params.DistanceRatio)
+		d.Reason = fmt.Sprintf("distance %.0fkm(proxy) vs %.0fkm(server), ratio %.2f",
+			toMid, toDst, p.params.DistanceRatio)
+	default:
+		// cross country:
+		// change from (srcCN -> dstCN) to (srcCN -> midCN -> dstCN).
+		d.UseProxy = (src.Country == mid.Country && src.Country != dst.Country)
+		d.Reason = fmt.Sprintf("country %s/%s/%s", src.Country, mid.Country, dst.Country)
+	}
+	return d
+}
+
+// geoDistance returns great-circle distance(km) by haversine.
+func geoDistance(a, b *GeoInfo) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dlat := lat2 - lat1
+	dlon := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * kEarthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// RoutingOverride forces the action for client/server countries("*" for any).
+type RoutingOverride struct {
+	Client string
+	Server string
+	Action string // proxy/direct
+}
+
+func (o *RoutingOverride) match(client, server string) bool {
+	return (o.Client == "*" || strings.EqualFold(o.Client, client)) &&
+		(o.Server == "*" || strings.EqualFold(o.Server, server))
+}
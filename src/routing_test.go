@@ -0,0 +1,105 @@
+package webrtc
+
+import (
+	"testing"
+)
+
+var (
+	geoShanghai = &GeoInfo{IP: "1.1.1.1", Country: "CN", Continent: "AS", ASN: 4134, Latitude: 31.2, Longitude: 121.5}
+	geoBeijing  = &GeoInfo{IP: "2.2.2.2", Country: "CN", Continent: "AS", ASN: 4134, Latitude: 39.9, Longitude: 116.4}
+	geoTokyo    = &GeoInfo{IP: "3.3.3.3", Country: "JP", Continent: "AS", ASN: 2516, Latitude: 35.7, Longitude: 139.7}
+	geoNewYork  = &GeoInfo{IP: "4.4.4.4", Country: "US", Continent: "NA", ASN: 7018, Latitude: 40.7, Longitude: -74.0}
+)
+
+func TestRoutingCompare(t *testing.T) {
+	tests := []struct {
+		compare       string
+		src, mid, dst *GeoInfo
+		proxy         bool
+	}{
+		{kRoutingCountry, geoShanghai, geoBeijing, geoNewYork, true},
+		{kRoutingCountry, geoShanghai, geoBeijing, geoBeijing, false},
+		{kRoutingCountry, geoShanghai, geoTokyo, geoNewYork, false},
+		{kRoutingContinent, geoShanghai, geoTokyo, geoNewYork, true},
+		{kRoutingContinent, geoShanghai, geoTokyo, geoBeijing, false},
+		{kRoutingAsn, geoShanghai, geoBeijing, geoTokyo, true},
+		{kRoutingAsn, geoShanghai, geoTokyo, geoNewYork, false},
+		{kRoutingDistance, geoShanghai, geoTokyo, geoNewYork, true},
+		{kRoutingDistance, geoShanghai, geoNewYork, geoTokyo, false},
+	}
+	for i, tt := range tests {
+		params := kDefaultRoutingParams
+		params.Compare = tt.compare
+		d := NewRoutingPolicy(&params).decide(tt.src, tt.mid, tt.dst)
+		if d.UseProxy != tt.proxy {
+			t.Errorf("case %d(%s): want %v, got %v(%s)", i, tt.compare, tt.proxy, d.UseProxy, d.Reason)
+		}
+	}
+}
+
+func TestRoutingOverrideAndMode(t *testing.T) {
+	params := kDefaultRoutingParams
+	params.Overrides = []RoutingOverride{
+		{Client: "CN", Server: "JP", Action: kRoutingActionDirect},
+		{Client: "*", Server: "US", Action: kRoutingActionProxy},
+	}
+	policy := NewRoutingPolicy(&params)
+
+	if d := policy.decide(geoShanghai, geoBeijing, geoTokyo); d.UseProxy || d.Policy != "override" {
+		t.Errorf("want direct override, got %v(%s)", d.UseProxy, d.Reason)
+	}
+	if d := policy.decide(geoTokyo, geoShanghai, geoNewYork); !d.UseProxy || d.Policy != "override" {
+		t.Errorf("want proxy override, got %v(%s)", d.UseProxy, d.Reason)
+	}
+	if d := policy.decide(nil, geoBeijing, geoTokyo); d.UseProxy {
+		t.Errorf("want direct without geo, got %v", d.UseProxy)
+	}
+
+	if d := policy.Check(kProxyAlways, "", "", ""); !d.UseProxy || d.Policy != kProxyAlways {
+		t.Errorf("want always proxy, got %v", d)
+	}
+	if d := policy.Check(kProxyNever, "", "", ""); d.UseProxy || d.Policy != kProxyNever {
+		t.Errorf("want never proxy, got %v", d)
+	}
+}
+
+func TestSelectProxyGroup(t *testing.T) {
+	geos := map[string]*GeoInfo{}
+	for _, geo := range []*GeoInfo{geoBeijing, geoTokyo, geoNewYork} {
+		geos[geo.IP] = geo
+	}
+	lookup := func(ip string) *GeoInfo { return geos[ip] }
+
+	groups := groupCandidates(nil, []string{
+		makeHostCandidate(1, "udp", "2.2.2.2", "6000"),
+		makeHostCandidate(2, "udp", "3.3.3.3", "6000"),
+		makeHostCandidate(3, "tcp", "2.2.2.2", "6000"),
+	}, []string{"cn", "jp", "cn"})
+	groups = append(groups, &ProxyGroup{
+		Node:       "xrtc-us",
+		Region:     "us",
+		Candidates: []string{makeHostCandidate(1, "udp", "4.4.4.4", "6000")},
+	})
+	if len(groups) != 3 || len(groups[0].Candidates) != 2 {
+		t.Fatalf("wrong groups: %v", groups)
+	}
+
+	tests := []struct {
+		client *GeoInfo
+		region string
+	}{
+		{geoShanghai, "cn"},
+		{geoTokyo, "jp"},
+		{geoNewYork, "us"},
+		{nil, "cn"},
+		{&GeoInfo{IP: "10.0.0.1"}, "cn"},
+	}
+	for i, tt := range tests {
+		if g := selectProxyGroup(tt.client, groups, lookup); g.Region != tt.region {
+			t.Errorf("case %d: want %s, got %s", i, tt.region, g.Region)
+		}
+	}
+	if g := selectProxyGroup(geoTokyo, nil, lookup); g != nil {
+		t.Errorf("want nil group, got %v", g)
+	}
+}
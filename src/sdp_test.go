@@ -0,0 +1,229 @@
+package webrtc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PeterXu/xrtc/util"
+)
+
+// unified plan offer of chrome with simulcast(rid) and legacy simulcast(SIM)
+const kChromeOfferSdp = "v=0\r\n" +
+	"o=- 7614219274584779017 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=group:BUNDLE 0 1 2\r\n" +
+	"a=extmap-allow-mixed\r\n" +
+	"a=msid-semantic: WMS stream\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111 0\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=ice-ufrag:chro\r\n" +
+	"a=ice-pwd:chromepasswordchromepass\r\n" +
+	"a=mid:0\r\n" +
+	"a=extmap:1 urn:ietf:params:rtp-hdrext:ssrc-audio-level\r\n" +
+	"a=sendrecv\r\n" +
+	"a=msid:stream audiotrack\r\n" +
+	"a=rtcp-mux\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=ssrc:1001 cname:chrome\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:1\r\n" +
+	"a=extmap:4/sendonly urn:ietf:params:rtp-hdrext:sdes:rtp-stream-id\r\n" +
+	"a=sendonly\r\n" +
+	"a=msid:stream videotrack\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtpmap:97 rtx/90000\r\n" +
+	"a=fmtp:97 apt=96\r\n" +
+	"a=rid:h send\r\n" +
+	"a=rid:m send max-width=640;max-height=360\r\n" +
+	"a=rid:l send\r\n" +
+	"a=simulcast:send h;~m;l\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97 98\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:2\r\n" +
+	"a=msid:- screentrack\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtpmap:97 rtx/90000\r\n" +
+	"a=fmtp:97 apt=96\r\n" +
+	"a=rtpmap:98 ulpfec/90000\r\n" +
+	"a=ssrc-group:SIM 2001 2003 2005\r\n" +
+	"a=ssrc-group:FID 2001 2002\r\n" +
+	"a=ssrc-group:FID 2003 2004\r\n" +
+	"a=ssrc-group:FID 2005 2006\r\n" +
+	"a=ssrc-group:FEC-FR 2001 2007\r\n" +
+	"a=ssrc:2001 cname:chrome\r\n" +
+	"a=ssrc:2001 msid:- screentrack\r\n" +
+	"a=ssrc:2002 cname:chrome\r\n" +
+	"a=ssrc:2003 cname:chrome\r\n" +
+	"a=ssrc:2004 cname:chrome\r\n" +
+	"a=ssrc:2005 cname:chrome\r\n" +
+	"a=ssrc:2006 cname:chrome\r\n" +
+	"a=ssrc:2007 cname:chrome\r\n" +
+	"m=application 0 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+	"a=mid:3\r\n" +
+	"a=sctp-port:5000\r\n"
+
+// unified plan offer of firefox, video first and the old simulcast syntax
+const kFirefoxOfferSdp = "v=0\r\n" +
+	"o=mozilla...THIS_IS_SDPARTA-99.0 1 0 IN IP4 0.0.0.0\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=ice-options:trickle\r\n" +
+	"a=group:BUNDLE 0 1\r\n" +
+	"a=msid-semantic:WMS *\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 120 124\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=sendrecv\r\n" +
+	"a=ice-pwd:firefoxpasswordfirefoxpa\r\n" +
+	"a=ice-ufrag:fire\r\n" +
+	"a=mid:0\r\n" +
+	"a=msid:{stream} {videotrack}\r\n" +
+	"a=rid:a send\r\n" +
+	"a=rid:b send\r\n" +
+	"a=simulcast: send rid=a;b\r\n" +
+	"a=rtpmap:120 VP8/90000\r\n" +
+	"a=rtpmap:124 rtx/90000\r\n" +
+	"a=fmtp:124 apt=120\r\n" +
+	"a=ssrc:3001 cname:{firefox}\r\n" +
+	"a=ssrc:3002 cname:{firefox}\r\n" +
+	"a=ssrc-group:FID 3001 3002\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 109\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=recvonly\r\n" +
+	"a=ice-pwd:firefoxpasswordfirefoxpa\r\n" +
+	"a=ice-ufrag:fire\r\n" +
+	"a=mid:1\r\n" +
+	"a=rtpmap:109 opus/48000/2\r\n"
+
+func TestUnifiedPlanSdp(t *testing.T) {
+	var desc util.MediaDesc
+	if !desc.Parse([]byte(kChromeOfferSdp)) {
+		t.Fatal("parse failed")
+	}
+	trans := desc.GetTransceivers()
+	if len(trans) != 4 {
+		t.Fatalf("wrong transceivers: %d", len(trans))
+	}
+	if desc.GetUfrag() != "chro" {
+		t.Errorf("wrong ufrag: %s", desc.GetUfrag())
+	}
+
+	audio := trans[0]
+	if audio.Mid != "0" || audio.Kind != "audio" || audio.Direction != "sendrecv" ||
+		audio.StreamId != "stream" || audio.TrackId != "audiotrack" || !audio.ExtmapAllowMixed || audio.IsSimulcast() {
+		t.Errorf("wrong audio: %+v", audio)
+	}
+
+	video := desc.GetTransceiver("1")
+	if video == nil || video.Direction != "sendonly" || !video.IsSimulcast() || len(video.Rids) != 3 {
+		t.Fatalf("wrong video: %+v", video)
+	}
+	if video.Rids[1].Id != "m" || video.Rids[1].Direction != "send" || video.Rids[1].Params != "max-width=640;max-height=360" {
+		t.Errorf("wrong rid: %+v", video.Rids[1])
+	}
+	if want := [][]string{{"h"}, {"~m"}, {"l"}}; !reflect.DeepEqual(video.Simulcast.Send, want) {
+		t.Errorf("wrong simulcast: %v", video.Simulcast.Send)
+	}
+
+	screen := trans[2]
+	if screen.StreamId != "-" || screen.TrackId != "screentrack" || !screen.IsSimulcast() {
+		t.Errorf("wrong screen: %+v", screen)
+	}
+	if len(screen.SsrcGroups) != 5 || screen.SsrcGroups[4].Semantics != "FEC-FR" ||
+		!reflect.DeepEqual(screen.SsrcGroups[0].Ssrcs, []uint32{2001, 2003, 2005}) {
+		t.Errorf("wrong ssrc groups: %v", screen.SsrcGroups)
+	}
+	if !reflect.DeepEqual(screen.Ssrcs, []uint32{2001, 2002, 2003, 2004, 2005, 2006, 2007}) {
+		t.Errorf("wrong ssrcs: %v", screen.Ssrcs)
+	}
+
+	if app := trans[3]; app.Kind != "application" || !app.Rejected || app.Mid != "3" {
+		t.Errorf("wrong application: %+v", app)
+	}
+
+	var ff util.MediaDesc
+	ff.Parse([]byte(kFirefoxOfferSdp))
+	trans = ff.GetTransceivers()
+	if len(trans) != 2 || trans[0].Kind != "video" || trans[1].Direction != "recvonly" {
+		t.Fatalf("wrong firefox transceivers: %d", len(trans))
+	}
+	if ff.GetUfrag() != "fire" || ff.GetPasswd() != "firefoxpasswordfirefoxpa" {
+		t.Errorf("wrong firefox ice: %s", ff.GetUfrag())
+	}
+	if trans[0].StreamId != "{stream}" || trans[0].TrackId != "{videotrack}" {
+		t.Errorf("wrong firefox msid: %+v", trans[0])
+	}
+	if want := [][]string{{"a"}, {"b"}}; trans[0].Simulcast == nil || !reflect.DeepEqual(trans[0].Simulcast.Send, want) {
+		t.Errorf("wrong firefox simulcast: %+v", trans[0].Simulcast)
+	}
+	if trans[0].ExtmapAllowMixed || trans[1].Mid != "1" {
+		t.Errorf("wrong firefox media: %+v", trans[1])
+	}
+}
+
+func TestCandidate(t *testing.T) {
+	tests := []struct {
+		line string
+		want *util.Candidate
+		out  string // marshal, "" for the same
+	}{
+		{"a=candidate:1 1 udp 2013266431 192.168.1.10 8000 typ host", &util.Candidate{
+			Foundation: "1", ComponentId: 1, Transport: "udp", Priority: 2013266431, Address: "192.168.1.10", Port: 8000,
+			Type: "host", RelPort: -1, Generation: -1, NetworkId: -1, NetworkCost: -1}, ""},
+		{"candidate:842163049 1 UDP 1677729535 1.2.3.4 60769 typ srflx raddr 0.0.0.0 rport 0 generation 0 ufrag WbBI network-id 1 network-cost 10",
+			&util.Candidate{Foundation: "842163049", ComponentId: 1, Transport: "udp", Priority: 1677729535, Address: "1.2.3.4",
+				Port: 60769, Type: "srflx", RelAddr: "0.0.0.0", RelPort: 0, Generation: 0, Ufrag: "WbBI", NetworkId: 1, NetworkCost: 10},
+			"candidate:842163049 1 udp 1677729535 1.2.3.4 60769 typ srflx raddr 0.0.0.0 rport 0 generation 0 ufrag WbBI network-id 1 network-cost 10"},
+		{"a=candidate:2 1 tcp 1010827775 2001:db8::1 9 typ host tcptype passive x-ext 1", &util.Candidate{
+			Foundation: "2", ComponentId: 1, Transport: "tcp", Priority: 1010827775, Address: "2001:db8::1", Port: 9,
+			Type: "host", RelPort: -1, TcpType: "passive", Generation: -1, NetworkId: -1, NetworkCost: -1,
+			Extensions: []util.CandidateExtension{{Name: "x-ext", Value: "1"}}}, ""},
+		{"a=candidate:3 1 udp 2122262783 0b6c2a1e-9a2f-4c1b-8d5e-3f7a9c1d2e4b.local 54321 typ host generation 0", &util.Candidate{
+			Foundation: "3", ComponentId: 1, Transport: "udp", Priority: 2122262783, Address: "0b6c2a1e-9a2f-4c1b-8d5e-3f7a9c1d2e4b.local",
+			Port: 54321, Type: "host", RelPort: -1, Generation: 0, NetworkId: -1, NetworkCost: -1}, ""},
+		// invalid
+		{"a=candidate:1 1 udp 2013266431 1.2.3.4 8000", nil, ""},
+		{"a=candidate:1 1 udp 2013266431 1.2.3.4 8000 type host", nil, ""},
+		{"a=candidate:1 0 udp 2013266431 1.2.3.4 8000 typ host", nil, ""},
+		{"a=candidate:1 1 udp 0 1.2.3.4 8000 typ host", nil, ""},
+		{"a=candidate:1 1 udp 4294967296 1.2.3.4 8000 typ host", nil, ""},
+		{"a=candidate:1 1 udp 1 1.2.3.4 65536 typ host", nil, ""},
+		{"a=candidate:1 1 udp 1 1.2.3.4 8000 typ host generation", nil, ""},
+		{"a=candidate:1 1 udp 1 1.2.3.4 8000 typ relay rport x", nil, ""},
+		{"a=candidate:f@o 1 udp 1 1.2.3.4 8000 typ host", nil, ""},
+		{"a=ice-ufrag:1 1 udp 1 1.2.3.4 8000 typ host", nil, ""},
+	}
+	for i, tt := range tests {
+		cand, err := util.UnmarshalCandidate(tt.line)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("case %d: no error for %q", i, tt.line)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(cand, tt.want) {
+			t.Errorf("case %d: %v, %+v", i, err, cand)
+			continue
+		}
+		out := tt.out
+		if len(out) == 0 {
+			out = strings.TrimPrefix(tt.line, "a=")
+		}
+		if cand.Marshal() != out {
+			t.Errorf("case %d: marshal %q", i, cand.Marshal())
+		}
+	}
+
+	mdns := util.ParseCandidate(tests[3].line)
+	if !mdns.IsMdns() || mdns.IP() != nil || util.ParseCandidateIp(tests[3].line) != "" {
+		t.Errorf("wrong mDNS candidate")
+	}
+	if host := util.ParseCandidate(tests[2].line).HostPort(); host != "[2001:db8::1]:9" {
+		t.Errorf("wrong host port: %s", host)
+	}
+	if line := makeHostCandidate(2, "tcp", "1.2.3.4", "6000"); line != "a=candidate:2 1 tcp 1010827775 1.2.3.4 6000 typ host tcptype passive" {
+		t.Errorf("wrong host candidate: %s", line)
+	}
+}
@@ -0,0 +1,232 @@
+package webrtc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/PeterXu/xrtc/util"
+	log "github.com/PeterXu/xrtc/util"
+)
+
+// the static payload types without a=rtpmap(RFC 3551)
+var kStaticPayloadCodecs = map[string]string{
+	"0": "pcmu",
+	"8": "pcma",
+	"9": "g722",
+}
+
+// SdpPolicy munges the sdp passing through the api(register/whip/janus),
+// e.g. codecs filtering and bandwidth caps.
+type SdpPolicy struct {
+	TAG    string
+	params SdpPolicyParams
+}
+
+// NewSdpPolicy returns nil if no params.
+func NewSdpPolicy(params *SdpPolicyParams) *SdpPolicy {
+	if params == nil {
+		return nil
+	}
+	return &SdpPolicy{TAG: "[SDP]", params: *params}
+}
+
+// Apply returns the munged sdp, or the original for nil policy.
+// The lines not touched by policy are kept as is.
+func (p *SdpPolicy) Apply(sdp string) string {
+	if p == nil || len(sdp) == 0 {
+		return sdp
+	}
+
+	desc := util.ParseSessionDescription([]byte(sdp))
+	p.filterLines(&desc.SdpSection)
+	for _, m := range desc.Media {
+		p.filterLines(&m.SdpSection)
+		switch m.Kind() {
+		case "audio":
+			p.filterCodecs(m, p.params.AudioCodecs)
+			p.setBandwidth(m, p.params.AudioBandwidth)
+		case "video":
+			p.filterCodecs(m, p.params.VideoCodecs)
+			p.setBandwidth(m, p.params.VideoBandwidth)
+		default:
+			continue
+		}
+		if p.params.RtcpMux && !m.HasAttribute("rtcp-mux") {
+			m.AddAttribute("rtcp-mux", "")
+		}
+	}
+	if p.params.Bundle {
+		p.setBundle(desc)
+	}
+	return desc.String()
+}
+
+// filterLines removes b= lines and the header extensions not allowed.
+func (p *SdpPolicy) filterLines(s *util.SdpSection) {
+	s.RemoveLines(func(line *util.SdpLine) bool {
+		if p.params.StripBandwidth && line.Type == 'b' {
+			return true
+		}
+		name, value, _ := line.Attribute()
+		return name == "extmap" && !p.allowExtmap(value)
+	})
+}
+
+// allowExtmap checks the uri of "a=extmap:<id>[/<direction>] <uri> [<attributes>]".
+func (p *SdpPolicy) allowExtmap(value string) bool {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return true
+	}
+	uri := fields[1]
+	for _, item := range p.params.StripExtmaps {
+		if item == uri {
+			return false
+		}
+	}
+	if len(p.params.Extmaps) == 0 {
+		return true
+	}
+	for _, item := range p.params.Extmaps {
+		if item == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// filterCodecs keeps the allowed codecs(with rtx) of one media in order,
+// and keeps all if none allowed.
+func (p *SdpPolicy) filterCodecs(m *util.SdpMedia, allowed []string) {
+	formats := m.Formats()
+	if len(allowed) == 0 || len(formats) == 0 {
+		return
+	}
+
+	// payload type => codec(lowercase), rtx => apt
+	codecs := make(map[string]string)
+	apts := make(map[string]string)
+	for _, value := range m.Attributes("rtpmap") {
+		ptype, param := splitSdpFormat(value)
+		codecs[ptype] = strings.ToLower(strings.Split(param, "/")[0])
+	}
+	for _, value := range m.Attributes("fmtp") {
+		ptype, param := splitSdpFormat(value)
+		for _, item := range strings.Split(param, ";") {
+			if item = strings.TrimSpace(item); strings.HasPrefix(item, "apt=") {
+				apts[ptype] = strings.TrimPrefix(item, "apt=")
+			}
+		}
+	}
+
+	rank := func(ptype string) int {
+		codec, ok := codecs[ptype]
+		if !ok {
+			codec = kStaticPayloadCodecs[ptype]
+		}
+		for idx, item := range allowed {
+			if strings.EqualFold(item, codec) {
+				return idx
+			}
+		}
+		return -1
+	}
+
+	ranks := make(map[string]int)
+	var ptypes []string
+	for _, ptype := range formats {
+		if r := rank(ptype); r >= 0 {
+			ranks[ptype] = r
+			ptypes = append(ptypes, ptype)
+		}
+	}
+	if len(ptypes) == 0 {
+		log.Warnln(p.TAG, "no allowed codecs in m="+m.Kind()+", keep all")
+		return
+	}
+	// rtx follows its codec
+	for _, ptype := range formats {
+		if apt, ok := apts[ptype]; ok && codecs[ptype] == "rtx" {
+			if r, ok := ranks[apt]; ok {
+				if _, had := ranks[ptype]; !had {
+					ranks[ptype] = r
+					ptypes = append(ptypes, ptype)
+				}
+			}
+		}
+	}
+	order := make(map[string]int)
+	for idx, ptype := range formats {
+		order[ptype] = idx
+	}
+	sort.SliceStable(ptypes, func(i, j int) bool {
+		if ranks[ptypes[i]] != ranks[ptypes[j]] {
+			return ranks[ptypes[i]] < ranks[ptypes[j]]
+		}
+		return order[ptypes[i]] < order[ptypes[j]]
+	})
+
+	kept := make(map[string]bool)
+	for _, ptype := range ptypes {
+		kept[ptype] = true
+	}
+	m.SetFormats(ptypes)
+	m.RemoveLines(func(line *util.SdpLine) bool {
+		name, value, _ := line.Attribute()
+		switch name {
+		case "rtpmap", "fmtp", "rtcp-fb":
+			ptype, _ := splitSdpFormat(value)
+			return ptype != "*" && !kept[ptype]
+		}
+		return false
+	})
+}
+
+// setBandwidth replaces b=AS/TIAS of one media by kbps,
+// which follows the m/i/c lines.
+func (p *SdpPolicy) setBandwidth(m *util.SdpMedia, kbps int) {
+	if kbps <= 0 {
+		return
+	}
+	m.RemoveLines(func(line *util.SdpLine) bool {
+		return line.Type == 'b' && (strings.HasPrefix(line.Value, "AS:") || strings.HasPrefix(line.Value, "TIAS:"))
+	})
+	pos := 1
+	for idx, line := range m.Lines {
+		if line.Type == 'i' || line.Type == 'c' {
+			pos = idx + 1
+		}
+	}
+	if p.params.Tias {
+		m.InsertLine(pos, util.NewSdpLine('b', "TIAS:"+util.Itoa(kbps*1000)))
+	}
+	m.InsertLine(pos, util.NewSdpLine('b', "AS:"+util.Itoa(kbps)))
+}
+
+// setBundle replaces the session a=group:BUNDLE with the mids of all accepted media.
+func (p *SdpPolicy) setBundle(desc *util.SessionDescription) {
+	var mids []string
+	for _, m := range desc.Media {
+		if mid := m.Mid(); len(mid) > 0 && m.Port() != "0" {
+			mids = append(mids, mid)
+		}
+	}
+	if len(mids) == 0 {
+		return
+	}
+
+	desc.RemoveLines(func(line *util.SdpLine) bool {
+		name, value, _ := line.Attribute()
+		return name == "group" && strings.HasPrefix(value, "BUNDLE")
+	})
+	desc.AddAttribute("group", "BUNDLE "+strings.Join(mids, " "))
+}
+
+// splitSdpFormat returns the payload type and parameters of "<ptype> <params>".
+func splitSdpFormat(value string) (string, string) {
+	parts := strings.SplitN(value, " ", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,170 @@
+package webrtc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PeterXu/xrtc/util"
+)
+
+const kPolicySdp = "v=0\r\n" +
+	"o=- 1 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"b=AS:5000\r\n" +
+	"a=group:BUNDLE 0\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111 0 8\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:0\r\n" +
+	"a=extmap:1 urn:ietf:params:rtp-hdrext:ssrc-audio-level\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=fmtp:111 minptime=10\r\n" +
+	"a=rtcp-fb:111 transport-cc\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97 98 99\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"b=AS:2000\r\n" +
+	"a=mid:1\r\n" +
+	"a=extmap:2/sendonly urn:ietf:params:rtp-hdrext:toffset\r\n" +
+	"a=extmap:3 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time\r\n" +
+	"a=rtcp-mux\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtcp-fb:96 nack\r\n" +
+	"a=rtpmap:97 rtx/90000\r\n" +
+	"a=fmtp:97 apt=96\r\n" +
+	"a=rtpmap:98 H264/90000\r\n" +
+	"a=fmtp:98 packetization-mode=1\r\n" +
+	"a=rtpmap:99 rtx/90000\r\n" +
+	"a=fmtp:99 apt=98;rtx-time=3000\r\n" +
+	"a=rtcp-fb:* ccm fir\r\n" +
+	"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+	"a=mid:2\r\n"
+
+func TestSdpPolicy(t *testing.T) {
+	var policy *SdpPolicy
+	if out := policy.Apply(kPolicySdp); out != kPolicySdp {
+		t.Errorf("nil policy changed sdp")
+	}
+
+	policy = NewSdpPolicy(&SdpPolicyParams{
+		AudioCodecs:    []string{"PCMA", "opus"},
+		VideoCodecs:    []string{"h264", "vp8"},
+		StripBandwidth: true,
+		VideoBandwidth: 1500,
+		Tias:           true,
+		StripExtmaps:   []string{"urn:ietf:params:rtp-hdrext:toffset"},
+		RtcpMux:        true,
+		Bundle:         true,
+	})
+	out := policy.Apply(kPolicySdp)
+	lines := strings.Split(out, "\r\n")
+	index := func(line string) int {
+		for idx, item := range lines {
+			if item == line {
+				return idx
+			}
+		}
+		return -1
+	}
+
+	for _, line := range []string{
+		"m=audio 9 UDP/TLS/RTP/SAVPF 8 111",
+		"m=video 9 UDP/TLS/RTP/SAVPF 98 99 96 97",
+		"a=group:BUNDLE 0 1 2",
+		"a=fmtp:99 apt=98;rtx-time=3000",
+		"a=rtcp-fb:* ccm fir",
+		"a=extmap:3 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time",
+	} {
+		if index(line) < 0 {
+			t.Errorf("no line %q in:\n%s", line, out)
+		}
+	}
+	for _, line := range []string{
+		"b=AS:5000", "b=AS:2000", "a=group:BUNDLE 0",
+		"a=extmap:2/sendonly urn:ietf:params:rtp-hdrext:toffset",
+	} {
+		if index(line) >= 0 {
+			t.Errorf("line %q not removed", line)
+		}
+	}
+
+	// b= follows c= of video
+	if c := index("b=AS:1500"); c < 0 || lines[c-1] != "c=IN IP4 0.0.0.0" || lines[c+1] != "b=TIAS:1500000" {
+		t.Errorf("wrong bandwidth of video:\n%s", out)
+	}
+	// a=rtcp-mux added for audio only
+	if strings.Count(out, "a=rtcp-mux") != 2 {
+		t.Errorf("wrong rtcp-mux:\n%s", out)
+	}
+
+	// keep all if no allowed
+	policy = NewSdpPolicy(&SdpPolicyParams{VideoCodecs: []string{"AV1"}})
+	if out := policy.Apply(kPolicySdp); !strings.Contains(out, "m=video 9 UDP/TLS/RTP/SAVPF 96 97 98 99\r\n") {
+		t.Errorf("video codecs changed:\n%s", out)
+	}
+
+	// remove the codecs not allowed(with rtx)
+	policy = NewSdpPolicy(&SdpPolicyParams{VideoCodecs: []string{"VP8"}, Extmaps: []string{"urn:ietf:params:rtp-hdrext:ssrc-audio-level"}})
+	out = policy.Apply(kPolicySdp)
+	for _, line := range []string{"a=rtpmap:98 H264/90000", "a=fmtp:99 apt=98;rtx-time=3000", "a=extmap:3"} {
+		if strings.Contains(out, line) {
+			t.Errorf("line %q not removed", line)
+		}
+	}
+	if !strings.Contains(out, "m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n") || !strings.Contains(out, "a=extmap:1 ") {
+		t.Errorf("wrong video codecs:\n%s", out)
+	}
+}
+
+func TestSessionDescription(t *testing.T) {
+	// round trip byte for byte
+	for _, sdp := range []string{
+		kPolicySdp,
+		kFuzzSdp,
+		strings.ReplaceAll(kPolicySdp, "\r\n", "\n"),
+		strings.TrimSuffix(kPolicySdp, "\r\n"),
+		"v=0\nm=audio 9 RTP/AVP 0\r\na=x-unknown:  spaces \r\ngarbage\n\nb=\r\n",
+		"", "\n", "m=", "a=rtpmap",
+	} {
+		if out := util.ParseSessionDescription([]byte(sdp)).String(); out != sdp {
+			t.Errorf("round trip failed:\n%q\n%q", sdp, out)
+		}
+	}
+
+	desc := util.ParseSessionDescription([]byte(strings.TrimSuffix(kPolicySdp, "\r\n")))
+	if len(desc.Media) != 3 || desc.Media[1].Kind() != "video" || desc.Media[1].Mid() != "1" {
+		t.Fatalf("wrong media: %d", len(desc.Media))
+	}
+	video := desc.Media[1]
+	if formats := video.Formats(); strings.Join(formats, " ") != "96 97 98 99" {
+		t.Errorf("wrong formats: %v", formats)
+	}
+	if fmtps := video.Attributes("fmtp"); len(fmtps) != 3 || fmtps[2] != "99 apt=98;rtx-time=3000" {
+		t.Errorf("wrong fmtp: %v", fmtps)
+	}
+	if value, ok := video.Attribute("rtcp-mux"); !ok || value != "" {
+		t.Errorf("no rtcp-mux")
+	}
+	if group, _ := desc.Attribute("group"); group != "BUNDLE 0" {
+		t.Errorf("wrong group: %s", group)
+	}
+
+	// edit, and the untouched lines are kept
+	desc.SetAttribute("group", "BUNDLE 0 1 2")
+	video.SetFormats([]string{"96"})
+	video.RemoveAttributes("extmap")
+	desc.Media[2].AddAttribute("sctp-port", "5000")
+	out := desc.String()
+	for _, line := range []string{
+		"a=group:BUNDLE 0 1 2\r\n",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n",
+		"a=mid:2\r\na=sctp-port:5000\r\n",
+		"a=rtcp-fb:* ccm fir\r\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("no %q in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "a=extmap:2") || !strings.Contains(out, "a=extmap:1 ") {
+		t.Errorf("wrong extmap:\n%s", out)
+	}
+}
@@ -2,9 +2,9 @@ package webrtc
 
 import (
 	"errors"
-	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PeterXu/xrtc/nice"
@@ -23,7 +23,13 @@ type Service struct {
 	iceCands   []util.Candidate
 	remoteAddr net.Addr
 
+	// iceConn is set by iceLoop and closed by dispose
+	iceMtx      sync.Mutex
+	iceConn     net.Conn
+	iceDisposed bool
+
 	ready    bool
+	statMtx  sync.Mutex // stat is read by hub
 	stat     *NetStat
 	chanRecv chan interface{}
 	exitTick chan bool
@@ -37,7 +43,7 @@ func NewService(user *User, chanRecv chan interface{}) *Service {
 		user:     user,
 		stat:     NewNetStat(0, 0),
 		chanRecv: chanRecv,
-		exitTick: make(chan bool),
+		exitTick: make(chan bool, 1),
 		objtime:  NewObjTime(),
 	}
 }
@@ -86,8 +92,17 @@ func (s *Service) Init(ufrag, pwd, remote string) bool {
 	return true
 }
 
+// statInfo returns a copy of stat, safe for other goroutines.
+func (s *Service) statInfo() *NetStatInfo {
+	s.statMtx.Lock()
+	defer s.statMtx.Unlock()
+	return s.stat.Info()
+}
+
 func (s *Service) onRecvData(data []byte) {
+	s.statMtx.Lock()
 	s.stat.updateRecv(len(data))
+	s.statMtx.Unlock()
 	s.user.sendToOuter(data)
 }
 
@@ -98,7 +113,9 @@ func (s *Service) sendData(data []byte) {
 		return
 	}
 
+	s.statMtx.Lock()
 	s.stat.updateSend(len(data))
+	s.statMtx.Unlock()
 	if s.agent != nil {
 		s.agent.Send(data)
 	} else {
@@ -160,10 +177,26 @@ func (s *Service) dispose() {
 		s.agent.Destroy()
 		s.agent = nil
 	}
-	s.exitTick <- true
+	s.iceMtx.Lock()
+	conn := s.iceConn
+	s.iceConn = nil
+	s.iceDisposed = true
+	s.iceMtx.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+	s.quit()
 	log.Println(s.TAG, "dispose end")
 }
 
+// quit notifies Run to exit, and never blocks when Run has gone.
+func (s *Service) quit() {
+	select {
+	case s.exitTick <- true:
+	default:
+	}
+}
+
 func (s *Service) ChanRecv() chan interface{} {
 	if s.ready {
 		return s.chanRecv
@@ -176,11 +209,11 @@ func (s *Service) iceLoop(retCh chan error) {
 	var tcpCands []util.Candidate
 	var udpCands []util.Candidate
 	for _, cand := range s.iceCands {
-		if cand.CandType != "typ host" {
+		if cand.Type != util.CandidateHost || cand.IsMdns() {
 			continue
 		}
 		if cand.Transport == "tcp" {
-			if cand.NetType == "tcptype passive" {
+			if cand.TcpType == "passive" {
 				tcpCands = append(tcpCands, cand)
 			}
 		} else {
@@ -203,7 +236,7 @@ func (s *Service) iceLoop(retCh chan error) {
 		isTcp = (cand.Transport == "tcp")
 
 		var err error
-		addr := fmt.Sprintf("%s:%s", cand.RelAddr, cand.RelPort)
+		addr := cand.HostPort()
 		if conn, err = net.Dial(cand.Transport, addr); err != nil {
 			log.Warnln(s.TAG, "connect fail", addr, err)
 			continue
@@ -219,8 +252,21 @@ func (s *Service) iceLoop(retCh chan error) {
 		retCh <- errors.New("ice to server failed")
 		return
 	} else {
+		s.iceMtx.Lock()
+		if s.iceDisposed {
+			// disposed while dialing
+			s.iceMtx.Unlock()
+			conn.Close()
+			log.Warnln(s.TAG, "disposed, close conn for ice")
+			retCh <- errors.New("service disposed")
+			return
+		}
+		s.iceConn = conn
+		s.iceMtx.Unlock()
+
 		log.Println(s.TAG, "success conn for ice, isTcp:", isTcp)
 		s.remoteAddr = conn.RemoteAddr()
+		s.user.onServiceConnected()
 		retCh <- nil
 	}
 
@@ -279,7 +325,7 @@ func (s *Service) iceLoop(retCh chan error) {
 		}
 	}
 
-	s.exitTick <- true
+	s.quit()
 }
 
 func (s *Service) Run() {
@@ -321,6 +367,7 @@ func (s *Service) Run() {
 				case nice.EventStateNiceConnected:
 					s.ready = true
 					log.Println(s.TAG, "agent ice connected")
+					s.user.onServiceConnected()
 				case nice.EventStateNiceReady:
 					s.ready = true
 					log.Println(s.TAG, "agent ice ready")
@@ -336,9 +383,11 @@ func (s *Service) Run() {
 			//log.Println(s.TAG, "agent received:", len(d))
 			s.onRecvData(d)
 		case <-tickChan:
+			s.statMtx.Lock()
 			if !s.stat.checkTimeout(5000) {
 				log.Print2f(s.TAG, "agent[%s] stat - %s\n", agentKey, s.stat)
 			}
+			s.statMtx.Unlock()
 		case <-s.exitTick:
 			quit = true
 		}
@@ -0,0 +1,71 @@
+package webrtc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/PeterXu/xrtc/util"
+)
+
+func TestServiceDisposeDialing(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	s := NewService(NewUser(true, true), nil)
+	cand := util.NewCandidate("1", 1, "tcp", 1010827775, "127.0.0.1", port, util.CandidateHost)
+	cand.TcpType = "passive"
+	s.iceCands = []util.Candidate{*cand}
+
+	// disposed before the dial is done
+	s.dispose()
+	retCh := make(chan error, 1)
+	go s.iceLoop(retCh)
+	if err := <-retCh; err == nil {
+		t.Fatal("ice connected after dispose")
+	}
+
+	// the conn dialed is closed by iceLoop
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if nerr, ok := err.(net.Error); err == nil || (ok && nerr.Timeout()) {
+		t.Errorf("conn for ice not closed: %v", err)
+	}
+}
+
+func TestServiceUserRace(t *testing.T) {
+	user := NewUser(false, true)
+	chanSend := make(chan interface{}, 100)
+	conn := NewConnection(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, chanSend)
+	conn.setUser(user)
+	user.addConnection(conn)
+	user.service = NewService(user, nil)
+
+	// the service goroutine(run with -race)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 50; i++ {
+			user.service.onRecvData([]byte("data"))
+		}
+		user.onServiceClose()
+		close(done)
+	}()
+	for i := 0; i < 50; i++ {
+		user.notify(kEventSessionClosed)
+	}
+	user.dispose(kCloseReasonDeleted)
+	<-done
+
+	if ev := user.notify(kEventSessionClosed); ev.Reason != kCloseReasonDeleted && ev.Reason != kCloseReasonUpstream {
+		t.Errorf("wrong reason: %s", ev.Reason)
+	}
+}
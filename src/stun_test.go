@@ -0,0 +1,602 @@
+package webrtc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PeterXu/xrtc/util"
+)
+
+func TestStunXorMappedAddress(t *testing.T) {
+	transId := "abcdefghijkl"
+	tests := []struct {
+		addr *net.UDPAddr
+		size int
+		xor  []byte // expected xored ip
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5000}, 8, []byte{0x20, 0x10, 0xa7, 0x46}},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}, 20, nil},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if !util.GenStunMessageResponse(&buf, "pwd", transId, tt.addr) {
+			t.Fatalf("%v: gen failed", tt.addr)
+		}
+		data := buf.Bytes()
+		// header(20) + type(2) + len(2) + reserved(1) + family(1) + port(2)
+		if size := int(data[22])<<8 | int(data[23]); size != tt.size {
+			t.Errorf("%v: want attr len %d, got %d", tt.addr, tt.size, size)
+		}
+		if tt.xor != nil && !bytes.Equal(data[28:32], tt.xor) {
+			t.Errorf("%v: want xored ip % x, got % x", tt.addr, tt.xor, data[28:32])
+		}
+
+		var msg util.StunMessage
+		if !msg.Read(data) {
+			t.Fatalf("%v: read failed", tt.addr)
+		}
+		attr, ok := msg.GetAttribute(util.STUN_ATTR_XOR_MAPPED_ADDRESS).(*util.StunXorAddressAttribute)
+		if !ok {
+			t.Fatalf("%v: no xor mapped address", tt.addr)
+		}
+		if !net.IP(attr.XorIP).Equal(tt.addr.IP) || int(attr.XorPort) != tt.addr.Port {
+			t.Errorf("%v: decoded %v:%d", tt.addr, net.IP(attr.XorIP), attr.XorPort)
+		}
+	}
+
+	if ip := util.ParseHostIp("[2001:db8::1]:5000"); ip != "2001:db8::1" {
+		t.Errorf("want ipv6 host, got %q", ip)
+	}
+}
+
+func TestStunResponder(t *testing.T) {
+	params := &NetParams{
+		EnableStun:       true,
+		StunOrigin:       true,
+		StunOtherAddress: "5.6.7.8:3478",
+		Candidates: []string{
+			makeHostCandidate(1, "udp", "1.2.3.4", "6000"),
+			makeHostCandidate(2, "udp", "2001:db8::2", "6000"),
+		},
+	}
+	r := NewStunResponder(params)
+	from := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+
+	var buf bytes.Buffer
+	req := util.NewStunMessageRequest()
+	req.Write(&buf)
+	data := r.Response(buf.Bytes(), from)
+
+	var resp util.StunMessage
+	if data == nil || !resp.Read(data) || resp.Dtype != util.STUN_BINDING_RESPONSE || resp.TransId != req.TransId {
+		t.Fatalf("invalid binding response")
+	}
+	mapped, ok := resp.GetAttribute(util.STUN_ATTR_XOR_MAPPED_ADDRESS).(*util.StunXorAddressAttribute)
+	if !ok || mapped.UDPAddr().String() != from.String() {
+		t.Fatalf("want xor mapped %v", from)
+	}
+	origin, ok := resp.GetAttribute(util.STUN_ATTR_RESPONSE_ORIGIN).(*util.StunAddressAttribute)
+	if !ok || origin.String() != "1.2.3.4:6000" {
+		t.Fatalf("want response origin 1.2.3.4:6000")
+	}
+	other, ok := resp.GetAttribute(util.STUN_ATTR_OTHER_ADDRESS).(*util.StunAddressAttribute)
+	if !ok || other.String() != "5.6.7.8:3478" {
+		t.Fatalf("want other address 5.6.7.8:3478")
+	}
+
+	// ipv6 client gets ipv6 origin
+	from6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::9"), Port: 5000}
+	data = r.Response(buf.Bytes(), from6)
+	resp = util.StunMessage{}
+	if data == nil || !resp.Read(data) {
+		t.Fatalf("invalid ipv6 binding response")
+	}
+	if origin, ok := resp.GetAttribute(util.STUN_ATTR_RESPONSE_ORIGIN).(*util.StunAddressAttribute); !ok ||
+		origin.String() != "[2001:db8::2]:6000" {
+		t.Fatalf("want ipv6 response origin")
+	}
+
+	// ice request with USERNAME is not answered
+	buf.Reset()
+	util.GenStunMessageRequest(&buf, "offer", "answer", "pwd")
+	if r.Response(buf.Bytes(), from) != nil {
+		t.Fatalf("want no response for ice request")
+	}
+
+	// disabled
+	params.EnableStun = false
+	if r := NewStunResponder(params); r.Response(data, from) != nil {
+		t.Fatalf("want nil responder")
+	}
+}
+
+// the test vectors of RFC 5769
+var (
+	kStunSampleRequest = `
+		00 01 00 58 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+		80 22 00 10 53 54 55 4e 20 74 65 73 74 20 63 6c 69 65 6e 74
+		00 24 00 04 6e 00 01 ff
+		80 29 00 08 93 2f f9 b1 51 26 3b 36
+		00 06 00 09 65 76 74 6a 3a 68 36 76 59 20 20 20
+		00 08 00 14 9a ea a7 0c bf d8 cb 56 78 1e f2 b5 b2 d3 f2 49 c1 b5 71 a2
+		80 28 00 04 e5 7a 3b cf`
+	kStunSampleIPv4Response = `
+		01 01 00 3c 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+		80 22 00 0b 74 65 73 74 20 76 65 63 74 6f 72 20
+		00 20 00 08 00 01 a1 47 e1 12 a6 43
+		00 08 00 14 2b 91 f5 99 fd 9e 90 c3 8c 74 89 f9 2a f9 ba 53 f0 6b e7 d7
+		80 28 00 04 c0 7d 4c 96`
+	kStunSampleIPv6Response = `
+		01 01 00 48 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+		80 22 00 0b 74 65 73 74 20 76 65 63 74 6f 72 20
+		00 20 00 14 00 02 a1 47 01 13 a9 fa a5 d3 f1 79 bc 25 f4 b5 be d2 b9 d9
+		00 08 00 14 a3 82 95 4e 4b e6 7b f1 17 84 c9 7c 82 92 c2 75 bf e3 ed 41
+		80 28 00 04 c8 fb 0b 4c`
+	kStunSampleLongTermRequest = `
+		00 01 00 60 21 12 a4 42 78 ad 34 33 c6 ad 72 c0 29 da 41 2e
+		00 06 00 12 e3 83 9e e3 83 88 e3 83 aa e3 83 83 e3 82 af e3 82 b9 00 00
+		00 15 00 1c 66 2f 2f 34 39 39 6b 39 35 34 64 36 4f 4c 33 34 6f 4c 39 46 53 54 76 79 36 34 73 41
+		00 14 00 0b 65 78 61 6d 70 6c 65 2e 6f 72 67 00
+		00 08 00 14 f6 70 24 65 6d d6 4a 3e 02 b8 e0 71 2e 85 c9 a2 8c a8 96 66`
+)
+
+func stunHex(t testing.TB, text string) []byte {
+	data, err := hex.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// makeStun returns a stun message of raw attributes(type, length and value without padding).
+func makeStun(dtype uint16, attrs ...[]byte) []byte {
+	body := []byte{}
+	for _, attr := range attrs {
+		body = append(body, attr...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	data := make([]byte, 20, 20+len(body))
+	binary.BigEndian.PutUint16(data[0:], dtype)
+	binary.BigEndian.PutUint16(data[2:], uint16(len(body)))
+	binary.BigEndian.PutUint32(data[4:], 0x2112A442)
+	copy(data[8:], "abcdefghijkl")
+	return append(data, body...)
+}
+
+func TestStunDecodeVectors(t *testing.T) {
+	longTermKey := md5.Sum([]byte("\u30de\u30c8\u30ea\u30c3\u30af\u30b9:example.org:TheMatrIX"))
+	tests := []struct {
+		name     string
+		data     string
+		key      string
+		attrs    map[util.StunAttributeType]string // byte string attributes
+		mapped   string
+		priority uint32
+		tie      uint64
+	}{
+		{"request", kStunSampleRequest, "VOkJxbRl1RmTxUk/WvJxBt",
+			map[util.StunAttributeType]string{
+				util.STUN_ATTR_SOFTWARE: "STUN test client",
+				util.STUN_ATTR_USERNAME: "evtj:h6vY",
+			}, "", 0x6e0001ff, 0x932ff9b151263b36},
+		{"ipv4 response", kStunSampleIPv4Response, "VOkJxbRl1RmTxUk/WvJxBt",
+			map[util.StunAttributeType]string{util.STUN_ATTR_SOFTWARE: "test vector"},
+			"192.0.2.1:32853", 0, 0},
+		{"ipv6 response", kStunSampleIPv6Response, "VOkJxbRl1RmTxUk/WvJxBt",
+			map[util.StunAttributeType]string{util.STUN_ATTR_SOFTWARE: "test vector"},
+			"[2001:db8:1234:5678:11:2233:4455:6677]:32853", 0, 0},
+		{"long-term request", kStunSampleLongTermRequest, string(longTermKey[:]),
+			map[util.StunAttributeType]string{
+				util.STUN_ATTR_USERNAME: "\u30de\u30c8\u30ea\u30c3\u30af\u30b9",
+				util.STUN_ATTR_NONCE:    "f//499k954d6OL34oL9FSTvy64sA",
+				util.STUN_ATTR_REALM:    "example.org",
+			}, "", 0, 0},
+	}
+	for _, tt := range tests {
+		data := stunHex(t, tt.data)
+		var msg util.StunMessage
+		if err := msg.Decode(data); err != nil {
+			t.Fatalf("%s: decode failed: %v", tt.name, err)
+		}
+		if !util.ValidateStunMessageIntegrity(data, tt.key) {
+			t.Errorf("%s: invalid integrity", tt.name)
+		}
+		for atype, value := range tt.attrs {
+			if got := stunString(&msg, atype); got != value {
+				t.Errorf("%s: attr %#04x want %q, got %q", tt.name, atype, value, got)
+			}
+		}
+		if len(tt.mapped) > 0 {
+			attr, ok := msg.GetAttribute(util.STUN_ATTR_XOR_MAPPED_ADDRESS).(*util.StunXorAddressAttribute)
+			if !ok || attr.UDPAddr().String() != tt.mapped {
+				t.Errorf("%s: want mapped %s", tt.name, tt.mapped)
+			}
+		}
+		if tt.priority != 0 {
+			if attr, ok := msg.GetAttribute(util.STUN_ATTR_PRIORITY).(*util.StunUInt32Attribute); !ok ||
+				attr.Value() != tt.priority {
+				t.Errorf("%s: want priority %#x", tt.name, tt.priority)
+			}
+		}
+		if tt.tie != 0 {
+			if attr, ok := msg.GetAttribute(util.STUN_ATTR_ICE_CONTROLLED).(*util.StunUInt64Attribute); !ok ||
+				attr.Value() != tt.tie {
+				t.Errorf("%s: want ice-controlled %#x", tt.name, tt.tie)
+			}
+		}
+
+		// any changed byte fails the fingerprint or integrity
+		data[len(data)-30] ^= 0x01
+		if msg.Decode(data) == nil && util.ValidateStunMessageIntegrity(data, tt.key) {
+			t.Errorf("%s: want failure for changed data", tt.name)
+		}
+	}
+}
+
+func TestStunDecodeErrors(t *testing.T) {
+	priority := []byte{0x00, 0x24, 0x00, 0x04, 0, 0, 0, 1}
+	afterFingerprint := makeStun(0x0001, []byte{0x80, 0x28, 0x00, 0x04, 0, 0, 0, 0}, priority)
+	binary.BigEndian.PutUint32(afterFingerprint[24:], crc32.ChecksumIEEE(afterFingerprint[0:20])^0x5354554E)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		code    int // -1 for success
+		unknown []util.StunAttributeType
+	}{
+		{"valid", makeStun(0x0001, priority), -1, nil},
+		{"short", []byte{0x00, 0x01, 0x00}, 0, nil},
+		{"rtp", append([]byte{0x80}, make([]byte, 19)...), 0, nil},
+		{"wrong length", makeStun(0x0001, priority)[0:24], 0, nil},
+		{"truncated attribute", makeStun(0x0001, []byte{0x00, 0x24, 0x00, 0x08, 0, 0, 0, 1}), 400, nil},
+		{"wrong uint32 length", makeStun(0x0001, []byte{0x00, 0x24, 0x00, 0x02, 0, 1}), 400, nil},
+		{"wrong integrity length", makeStun(0x0001, []byte{0x00, 0x08, 0x00, 0x04, 0, 0, 0, 0}), 400, nil},
+		{"wrong address family", makeStun(0x0001, []byte{0x00, 0x01, 0x00, 0x08, 0, 3, 0, 1, 1, 2, 3, 4}), 400, nil},
+		{"wrong address length", makeStun(0x0001, []byte{0x00, 0x20, 0x00, 0x14, 0, 1, 0, 1,
+			1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}), 400, nil},
+		{"attribute after fingerprint", afterFingerprint, 400, nil},
+		{"unknown optional", makeStun(0x0001, []byte{0x80, 0x31, 0x00, 0x01, 7}, priority), -1, nil},
+		{"unknown required", makeStun(0x0001, []byte{0x00, 0x31, 0x00, 0x01, 7}, priority,
+			[]byte{0x00, 0x33, 0x00, 0x00}), 420, []util.StunAttributeType{0x0031, 0x0033}},
+	}
+	for _, tt := range tests {
+		var msg util.StunMessage
+		err := msg.Decode(tt.data)
+		if tt.code < 0 {
+			if err != nil {
+				t.Errorf("%s: want success, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Code != tt.code || !reflect.DeepEqual(err.Unknown, tt.unknown) {
+			t.Errorf("%s: want %d %v, got %v", tt.name, tt.code, tt.unknown, err)
+		}
+	}
+
+	// 420 response with UNKNOWN-ATTRIBUTES, to plain stun request
+	params := &NetParams{EnableStun: true}
+	data := NewStunResponder(params).Response(makeStun(0x0001, []byte{0x00, 0x31, 0x00, 0x00}), &net.UDPAddr{
+		IP: net.ParseIP("10.0.0.1"), Port: 5000})
+	var resp util.StunMessage
+	if data == nil || resp.Decode(data) != nil || resp.Dtype != util.STUN_BINDING_ERROR_RESPONSE {
+		t.Fatalf("want binding error response")
+	}
+	code, _ := resp.GetAttribute(util.STUN_ATTR_ERROR_CODE).(*util.StunErrorCodeAttribute)
+	list, _ := resp.GetAttribute(util.STUN_ATTR_UNKNOWN_ATTRIBUTES).(*util.StunUInt16ListAttribute)
+	if code == nil || code.Code() != 420 || list == nil || !reflect.DeepEqual(list.Values, []uint16{0x0031}) {
+		t.Fatalf("want 420 with unknown attributes")
+	}
+}
+
+// newStunTestHub returns a hub without its loop, the stun is handled by test.
+func newStunTestHub(t *testing.T) *MaxHub {
+	hub := &MaxHub{
+		TAG:         "[MAXHUB]",
+		connections: make(map[string]*Connection),
+		clients:     make(map[string]*User),
+		cache:       NewMemCache(0),
+		metrics:     NewMetrics(),
+	}
+	t.Cleanup(func() {
+		for key, user := range hub.clients {
+			hub.removeUser(key, user)
+		}
+		hub.cache.Close()
+	})
+	return hub
+}
+
+// recvStun returns the stun message sent to client.
+func recvStun(t *testing.T, chanSend chan interface{}) *util.StunMessage {
+	select {
+	case item := <-chanSend:
+		var msg util.StunMessage
+		if err := msg.Decode(item.(*HubMessage).data); err != nil {
+			t.Fatalf("invalid stun to client: %v", err)
+		}
+		return &msg
+	case <-time.After(time.Second):
+		t.Fatal("no stun to client")
+	}
+	return nil
+}
+
+func TestHubStunErrorResponse(t *testing.T) {
+	hub := newStunTestHub(t)
+	request := &RegisterRequest{
+		OfferIce:  SdpIceInfo{Ufrag: "offer", Pwd: "offerpwd"},
+		AnswerIce: SdpIceInfo{Ufrag: "answer", Pwd: "answerpwd"},
+	}
+	hub.cache.Set(request.iceKey(), NewCacheItem(request))
+
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	unknown := []byte{0x00, 0x31, 0x00, 0x00}
+	chanSend := make(chan interface{}, 1)
+
+	// the first check with unknown comprehension-required attribute
+	data := makeStun(0x0001, append([]byte{0x00, 0x06, 0x00, 0x0c}, "answer:offer"...), unknown)
+	hub.handleStunBindingRequest(data, addr, chanSend)
+	var msg *HubMessage
+	select {
+	case item := <-chanSend:
+		msg = item.(*HubMessage)
+	default:
+		t.Fatal("no stun error response")
+	}
+
+	var req util.StunMessage
+	stunErr := req.Decode(data)
+	var want bytes.Buffer
+	util.GenStunErrorResponse(&want, "answerpwd", &req, stunErr)
+	if msg.to != addr || !bytes.Equal(msg.data, want.Bytes()) {
+		t.Errorf("wrong stun error response")
+	}
+	var resp util.StunMessage
+	resp.Decode(msg.data)
+	code, _ := resp.GetAttribute(util.STUN_ATTR_ERROR_CODE).(*util.StunErrorCodeAttribute)
+	if resp.Dtype != util.STUN_BINDING_ERROR_RESPONSE || code == nil || code.Code() != 420 {
+		t.Errorf("want 420 response")
+	}
+	if len(hub.clients) != 0 || len(hub.connections) != 0 {
+		t.Errorf("user created for invalid stun")
+	}
+
+	// dropped for unknown user
+	data = makeStun(0x0001, append([]byte{0x00, 0x06, 0x00, 0x0c}, "answer:other"...), unknown)
+	hub.handleStunBindingRequest(data, addr, chanSend)
+	if len(chanSend) != 0 {
+		t.Errorf("response to unknown user")
+	}
+}
+
+func TestIceRole(t *testing.T) {
+	request := func(atype util.StunAttributeType, tieBreaker uint64) *util.StunMessage {
+		req := util.NewStunMessageRequest()
+		req.AddAttribute(util.NewStunUInt64Attribute(atype, tieBreaker))
+		return req
+	}
+
+	tests := []struct {
+		role        string
+		tieBreaker  uint64
+		atype       util.StunAttributeType
+		peer        uint64
+		conflict    bool
+		controlling bool
+	}{
+		// no conflict
+		{kIceRoleControlled, 10, util.STUN_ATTR_ICE_CONTROLLING, 20, false, false},
+		{kIceRoleControlling, 10, util.STUN_ATTR_ICE_CONTROLLED, 20, false, true},
+		// both controlling
+		{kIceRoleControlling, 20, util.STUN_ATTR_ICE_CONTROLLING, 10, true, true},
+		{kIceRoleControlling, 10, util.STUN_ATTR_ICE_CONTROLLING, 20, false, false},
+		// both controlled
+		{kIceRoleControlled, 20, util.STUN_ATTR_ICE_CONTROLLED, 10, false, true},
+		{kIceRoleControlled, 10, util.STUN_ATTR_ICE_CONTROLLED, 20, true, false},
+	}
+	for i, tt := range tests {
+		role := NewIceRole(tt.role, false)
+		role.tieBreaker = tt.tieBreaker
+		if conflict := role.checkRequest(request(tt.atype, tt.peer)); conflict != tt.conflict {
+			t.Errorf("case %d: conflict %v, want %v", i, conflict, tt.conflict)
+		}
+		if role.isControlling() != tt.controlling {
+			t.Errorf("case %d: role %v", i, role)
+		}
+	}
+
+	// the role attributes in request
+	role := NewIceRole(kIceRoleControlling, false)
+	var buf bytes.Buffer
+	if !util.GenStunMessageRequest(&buf, "offer", "answer", "pwd", role.attributes()...) {
+		t.Fatal("fail to gen request")
+	}
+	var req util.StunMessage
+	if err := req.Decode(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if attr, ok := req.GetAttribute(util.STUN_ATTR_ICE_CONTROLLING).(*util.StunUInt64Attribute); !ok || attr.Value() != role.tieBreaker {
+		t.Errorf("no ICE-CONTROLLING with tie-breaker")
+	}
+	if req.GetAttribute(util.STUN_ATTR_USE_CANDIDATE) == nil || req.GetAttribute(util.STUN_ATTR_PRIORITY) == nil {
+		t.Errorf("no USE-CANDIDATE or PRIORITY")
+	}
+	if !util.ValidateStunMessageIntegrity(buf.Bytes(), "pwd") {
+		t.Errorf("invalid message integrity")
+	}
+
+	role.switchRole()
+	if role.isControlling() || req.GetAttribute(util.STUN_ATTR_ICE_CONTROLLED) != nil {
+		t.Errorf("switch role failed: %v", role)
+	}
+	if checkIceRole("", false) != nil || checkIceRole(kIceRoleControlling, false) != nil || checkIceRole("lite", false) == nil {
+		t.Errorf("invalid checkIceRole")
+	}
+}
+
+func TestHubIceRole(t *testing.T) {
+	hub := newStunTestHub(t)
+	candidates := []string{"a=candidate:1 1 udp 2013266431 127.0.0.1 9 typ host"}
+	request := &RegisterRequest{
+		OfferIce:   SdpIceInfo{Ufrag: "offer", Pwd: "offerpwd"},
+		AnswerIce:  SdpIceInfo{Ufrag: "answer", Pwd: "answerpwd"},
+		Candidates: candidates,
+		IceRole:    kIceRoleControlling,
+	}
+	hub.cache.Set(request.iceKey(), NewCacheItem(request))
+	direct := &RegisterRequest{
+		OfferIce:   SdpIceInfo{Ufrag: "offer2", Pwd: "offerpwd"},
+		AnswerIce:  SdpIceInfo{Ufrag: "answer2", Pwd: "answerpwd"},
+		Candidates: candidates,
+	}
+	hub.cache.Set(direct.iceKey(), NewCacheItem(direct))
+
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	chanSend := make(chan interface{}, 10)
+	check := func(offer, answer string, atype util.StunAttributeType) []byte {
+		var buf bytes.Buffer
+		util.GenStunMessageRequest(&buf, offer, answer, "answerpwd", util.NewStunUInt64Attribute(atype, 0))
+		return buf.Bytes()
+	}
+
+	// the client is controlling too, and the tie-breaker of xrtc wins
+	hub.handleStunBindingRequest(check("offer", "answer", util.STUN_ATTR_ICE_CONTROLLING), addr, chanSend)
+	user := hub.clients["answer:offer"]
+	if user == nil || user.isIceDirect() {
+		t.Fatalf("xrtc is not ice agent to client")
+	}
+	resp := recvStun(t, chanSend)
+	code, _ := resp.GetAttribute(util.STUN_ATTR_ERROR_CODE).(*util.StunErrorCodeAttribute)
+	if resp.Dtype != util.STUN_BINDING_ERROR_RESPONSE || code == nil || code.Code() != kStunErrorRoleConflict {
+		t.Fatalf("want 487 role conflict")
+	}
+
+	// the client switched to controlled, then xrtc responds and checks with nomination
+	conn := hub.findConnection(addr)
+	conn.onRecvData(check("offer", "answer", util.STUN_ATTR_ICE_CONTROLLED))
+	if resp := recvStun(t, chanSend); resp.Dtype != util.STUN_BINDING_RESPONSE {
+		t.Fatalf("want binding response, got %v", resp.Dtype)
+	}
+	req := recvStun(t, chanSend)
+	if req.Dtype != util.STUN_BINDING_REQUEST || req.GetAttribute(util.STUN_ATTR_ICE_CONTROLLING) == nil ||
+		req.GetAttribute(util.STUN_ATTR_USE_CANDIDATE) == nil {
+		t.Errorf("want binding request of controlling")
+	}
+	hub.removeUser("answer:offer", user)
+
+	// forwarded to server without ice_role
+	hub.handleStunBindingRequest(check("offer2", "answer2", util.STUN_ATTR_ICE_CONTROLLING), &net.UDPAddr{
+		IP: net.ParseIP("10.0.0.2"), Port: 5000}, chanSend)
+	if user := hub.clients["answer2:offer2"]; user == nil || !user.isIceDirect() {
+		t.Fatalf("xrtc is ice agent without ice_role")
+	}
+	if len(chanSend) != 0 {
+		t.Errorf("direct stun responded by xrtc")
+	}
+}
+
+func TestHubIceLite(t *testing.T) {
+	hub := newStunTestHub(t)
+	request := &RegisterRequest{
+		OfferIce:   SdpIceInfo{Ufrag: "offer", Pwd: "offerpwd"},
+		AnswerIce:  SdpIceInfo{Ufrag: "answer", Pwd: "answerpwd"},
+		Candidates: []string{"a=candidate:1 1 udp 2013266431 127.0.0.1 9 typ host"},
+		IceLite:    true,
+	}
+	hub.cache.Set(request.iceKey(), NewCacheItem(request))
+
+	// the ice-lite xrtc responds to the nominated check, and never checks
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	chanSend := make(chan interface{}, 10)
+	var buf bytes.Buffer
+	util.GenStunMessageRequest(&buf, "offer", "answer", "answerpwd",
+		util.NewStunUInt64Attribute(util.STUN_ATTR_ICE_CONTROLLING, 0),
+		util.NewStunByteStringAttribute(util.STUN_ATTR_USE_CANDIDATE, nil))
+	hub.handleStunBindingRequest(buf.Bytes(), addr, chanSend)
+	user := hub.clients["answer:offer"]
+	if user == nil || user.isIceDirect() {
+		t.Fatalf("ice-lite xrtc is not ice agent to client")
+	}
+	if resp := recvStun(t, chanSend); resp.Dtype != util.STUN_BINDING_RESPONSE {
+		t.Fatalf("want binding response, got %v", resp.Dtype)
+	}
+	conn := hub.findConnection(addr)
+	if conn == nil || !conn.isReady() || user.activeConn != conn || conn.hadStunChecking != 0 || len(chanSend) != 0 {
+		t.Errorf("ice-lite should accept nomination without checks")
+	}
+}
+
+func TestIceLite(t *testing.T) {
+	if checkIceRole("", true) != nil || checkIceRole(kIceRoleControlling, true) == nil {
+		t.Errorf("ice-lite should be controlled only")
+	}
+	role := NewIceRole(kIceRoleControlling, true)
+	role.switchRole()
+	if role.isControlling() {
+		t.Errorf("ice-lite is controlling")
+	}
+	req := util.NewStunMessageRequest()
+	req.AddAttribute(util.NewStunUInt64Attribute(util.STUN_ATTR_ICE_CONTROLLED, 0))
+	if !role.checkRequest(req) || role.isControlling() {
+		t.Errorf("ice-lite should respond 487 to controlled peer")
+	}
+
+	sdp := "v=0\r\ns=-\r\nt=0 0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=mid:0\r\n"
+	want := "v=0\r\ns=-\r\nt=0 0\r\na=ice-lite\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=mid:0\r\n"
+	if out := string(util.SetSdpIceLite([]byte(sdp))); out != want {
+		t.Errorf("SetSdpIceLite: %q", out)
+	}
+	if out := string(util.SetSdpIceLite([]byte(want))); out != want {
+		t.Errorf("SetSdpIceLite twice: %q", out)
+	}
+
+	// only respond to checks, and accept nomination
+	user := NewUser(false, false)
+	user.sendIce = SdpIceInfo{Ufrag: "answer", Pwd: "answerpwd"}
+	user.recvIce = SdpIceInfo{Ufrag: "offer", Pwd: "offerpwd"}
+	user.setIceRole("", true)
+	chanSend := make(chan interface{}, 10)
+	conn := NewConnection(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, chanSend)
+	conn.setUser(user)
+	other := NewConnection(&net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5000}, chanSend)
+	other.setUser(user)
+	user.addConnection(other)
+	user.addConnection(conn)
+
+	check := func(nominated bool) {
+		var buf bytes.Buffer
+		attrs := []util.StunAttribute{util.NewStunUInt64Attribute(util.STUN_ATTR_ICE_CONTROLLING, 1)}
+		if nominated {
+			attrs = append(attrs, util.NewStunByteStringAttribute(util.STUN_ATTR_USE_CANDIDATE, nil))
+		}
+		util.GenStunMessageRequest(&buf, "offer", "answer", "answerpwd", attrs...)
+		conn.onRecvData(buf.Bytes())
+		if len(chanSend) != 1 {
+			t.Fatalf("no binding response, %d", len(chanSend))
+		}
+		msg := (<-chanSend).(*HubMessage)
+		var resp util.StunMessage
+		if err := resp.Decode(msg.data); err != nil || resp.Dtype != util.STUN_BINDING_RESPONSE {
+			t.Fatalf("invalid binding response: %v", err)
+		}
+	}
+
+	check(false)
+	if conn.isReady() || user.activeConn == conn || conn.hadStunChecking != 0 {
+		t.Errorf("ice-lite should not check or select without nomination")
+	}
+	check(true)
+	if !conn.isReady() || user.activeConn != conn || conn.hadStunChecking != 0 {
+		t.Errorf("ice-lite should accept nomination")
+	}
+}
@@ -0,0 +1,108 @@
+package webrtc
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/PeterXu/xrtc/util"
+	log "github.com/PeterXu/xrtc/util"
+)
+
+// StunResponder is a plain stun server(RFC 5389) on the ice port,
+// which answers the binding requests without USERNAME from non-session clients,
+// e.g. browsers using xrtc as "stun:" server for srflx candidates.
+type StunResponder struct {
+	TAG     string
+	origins []*net.UDPAddr // public addresses of listener
+	other   *net.UDPAddr   // optional alternate address(RFC 5780)
+	origin  bool
+}
+
+// NewStunResponder returns nil if stun is disabled in the listener.
+func NewStunResponder(params *NetParams) *StunResponder {
+	if !params.EnableStun {
+		return nil
+	}
+	r := &StunResponder{TAG: "[STUN]", origin: params.StunOrigin}
+	for _, candidate := range params.Candidates {
+		if cand := util.ParseCandidate(candidate); cand != nil {
+			if ip := cand.IP(); ip != nil {
+				r.origins = append(r.origins, &net.UDPAddr{IP: ip, Port: cand.Port})
+			}
+		}
+	}
+	if len(params.StunOtherAddress) > 0 {
+		if addr, err := net.ResolveUDPAddr("udp", params.StunOtherAddress); err == nil {
+			r.other = addr
+		} else {
+			log.Warnln(r.TAG, "invalid other address:", params.StunOtherAddress, err)
+		}
+	}
+	log.Println(r.TAG, "enabled, origins:", r.origins, ", other:", r.other)
+	return r
+}
+
+// Response returns the binding response for one plain binding request,
+// or nil if not(e.g. ice request with USERNAME).
+func (r *StunResponder) Response(data []byte, from net.Addr) []byte {
+	if r == nil || !util.IsStunPacket(data) {
+		return nil
+	}
+	var req util.StunMessage
+	err := req.Decode(data)
+	if req.Dtype != util.STUN_BINDING_REQUEST || req.GetAttribute(util.STUN_ATTR_USERNAME) != nil {
+		return nil
+	}
+	if err != nil {
+		if err.Code == 0 {
+			return nil
+		}
+		// 400 or 420 with UNKNOWN-ATTRIBUTES
+		return r.write(&req, util.NewStunErrorResponse(&req, err))
+	}
+
+	resp := util.NewStunMessageResponse(req.TransId)
+	if req.IsLegacy() {
+		// RFC 3489 client
+		resp.AddAttribute(util.NewStunAddressAttribute(util.STUN_ATTR_MAPPED_ADDRESS, from))
+	} else {
+		resp.AddAttribute(util.NewStunXorAddressAttribute(util.STUN_ATTR_XOR_MAPPED_ADDRESS, from, req.TransId))
+	}
+	if origin := r.responseOrigin(from); origin != nil {
+		resp.AddAttribute(util.NewStunAddressAttribute(util.STUN_ATTR_RESPONSE_ORIGIN, origin))
+	}
+	if r.other != nil {
+		resp.AddAttribute(util.NewStunAddressAttribute(util.STUN_ATTR_OTHER_ADDRESS, r.other))
+	}
+	return r.write(&req, resp)
+}
+
+func (r *StunResponder) write(req, resp *util.StunMessage) []byte {
+	if !req.IsLegacy() {
+		resp.AddFingerprint()
+	}
+	var buf bytes.Buffer
+	if !resp.Write(&buf) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// responseOrigin returns the public address with the same family of client.
+func (r *StunResponder) responseOrigin(from net.Addr) *net.UDPAddr {
+	if !r.origin || len(r.origins) == 0 {
+		return nil
+	}
+	ipv4 := true
+	if host, _, err := net.SplitHostPort(from.String()); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			ipv4 = false
+		}
+	}
+	for _, origin := range r.origins {
+		if (origin.IP.To4() != nil) == ipv4 {
+			return origin
+		}
+	}
+	return r.origins[0]
+}
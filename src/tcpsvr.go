@@ -18,6 +18,7 @@ type TcpServer struct {
 	ln     net.Listener
 	mtx    sync.Mutex
 	config *NetConfig
+	stun   *StunResponder
 	pool   *util.GoPool
 }
 
@@ -43,6 +44,7 @@ func NewTcpServer(hub *MaxHub, cfg *NetConfig) *TcpServer {
 		hub:    hub,
 		ln:     l,
 		config: cfg,
+		stun:   NewStunResponder(&cfg.Net),
 		pool:   util.NewGoPool(1024),
 	}
 	go svr.Run()
@@ -129,6 +131,19 @@ func (h *TcpHandler) Process() bool {
 	kClientLen := len(util.SslClientHello)
 	kServerHello := util.SslServerHello
 
+	// stun/turn over tcp: without framing(RFC 4571)
+	if head, err := h.conn.Peek(8); err == nil && util.IsTurnTcpStream(head) {
+		turn := h.svr.hub.Turn()
+		if !h.svr.config.Net.EnableTurn {
+			turn = nil
+		}
+		if turn != nil || h.svr.stun != nil {
+			log.Println(h.TAG, "setup stun/turn-tcp for", h.conn.RemoteAddr())
+			h.ServeTURN(turn, h.svr.stun)
+			return true
+		}
+	}
+
 	data, err := h.conn.Peek(kClientLen)
 	if len(data) < 3 || err != nil {
 		log.Warn2f(h.TAG, "no enough data, err: %v", err)
@@ -234,6 +249,41 @@ func (h *TcpHandler) ServeTCP() {
 	log.Println(h.TAG, "ice main end")
 }
 
+// ServeTURN serves turn messages, ChannelData and plain stun from client over tcp,
+// the turn or stun may be nil.
+func (h *TcpHandler) ServeTURN(turn *TurnServer, stun *StunResponder) {
+	defer h.conn.Close()
+
+	addr := h.conn.RemoteAddr()
+	var mtx sync.Mutex
+	send := func(data []byte) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		_, err := h.conn.Write(data)
+		return err
+	}
+
+	rbuf := make([]byte, 1024*128)
+	for {
+		nret, err := util.ReadTurnTcpPacket(h.conn, rbuf[0:])
+		if err != nil {
+			log.Warnln(h.TAG, "turn read data fail:", err)
+			break
+		}
+		h.stat.updateRecv(nret)
+		data := make([]byte, nret)
+		copy(data, rbuf[0:nret])
+		if resp := stun.Response(data, addr); resp != nil {
+			send(resp)
+		} else if turn != nil && util.IsTurnPacket(data) {
+			turn.HandlePacket(data, addr, true, send)
+		}
+	}
+
+	turn.RemoveAllocation(util.NetAddrString(addr))
+	log.Println(h.TAG, "turn main end")
+}
+
 func (h *TcpHandler) writing() {
 	tickChan := time.NewTicker(time.Second * 10).C
 
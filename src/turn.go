@@ -0,0 +1,711 @@
+package webrtc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PeterXu/xrtc/util"
+	log "github.com/PeterXu/xrtc/util"
+)
+
+const (
+	kTurnDefaultRealm       = "xrtc"
+	kTurnDefaultLifetime    = 10 * time.Minute
+	kTurnMaxLifetime        = time.Hour
+	kTurnCredentialTtl      = 24 * time.Hour
+	kTurnMaxAllocations     = 1000
+	kTurnPermissionLifetime = 5 * time.Minute
+	kTurnChannelLifetime    = 10 * time.Minute
+	kTurnNonceLifetime      = time.Hour
+	kTurnSweepInterval      = 10 * time.Second
+	kTurnTransportUdp       = 17
+)
+
+// the error codes of TURN responses
+var kTurnErrorReasons = map[int]string{
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	437: "Allocation Mismatch",
+	438: "Stale Nonce",
+	440: "Address Family not Supported",
+	441: "Wrong Credentials",
+	442: "Unsupported Transport Protocol",
+	486: "Allocation Quota Reached",
+	508: "Insufficient Capacity",
+}
+
+// TurnSender sends data back to the client of allocation.
+type TurnSender func(data []byte) error
+
+// IceServer is the ice server(RTCIceServer) for client.
+type IceServer struct {
+	Urls       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// TurnServer is a TURN(RFC 8656) server on the ice port of udp/tcp listeners(enable_turn).
+// Only udp relay is supported, and the peers are limited to local candidates and allowed_peers,
+// so the clients behind strict firewalls can reach the proxy by relay candidates.
+type TurnServer struct {
+	TAG      string
+	params   TurnParams
+	nonceKey []byte
+	relayIP  net.IP
+	allocs   map[string]*TurnAllocation // 5-tuple(client addr) => allocation
+	selfIPs  map[string]bool            // ips of local candidates
+	exitTick chan bool
+
+	sync.Mutex
+}
+
+// NewTurnServer returns nil if no credentials.
+func NewTurnServer(params *TurnParams) *TurnServer {
+	if len(params.Users) == 0 && len(params.Secret) == 0 {
+		return nil
+	}
+	relayIP := params.RelayIP
+	if len(relayIP) == 0 {
+		relayIP = util.LocalIPString()
+	}
+	s := &TurnServer{
+		TAG:      "[TURN]",
+		params:   *params,
+		nonceKey: []byte(util.RandomString(32)),
+		relayIP:  net.ParseIP(util.LookupIP(relayIP)),
+		allocs:   make(map[string]*TurnAllocation),
+		selfIPs:  make(map[string]bool),
+		exitTick: make(chan bool),
+	}
+	if s.relayIP == nil {
+		log.Warnln(s.TAG, "invalid relay ip:", relayIP)
+		return nil
+	}
+	log.Println(s.TAG, "relay ip:", s.relayIP, ", realm:", s.params.Realm)
+	go s.Run()
+	return s
+}
+
+// SetLocalCandidates allows the local candidates as peers.
+func (s *TurnServer) SetLocalCandidates(candidates []string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, candidate := range candidates {
+		if ip := util.ParseCandidateIp(candidate); len(ip) > 0 {
+			s.selfIPs[ip] = true
+		}
+	}
+}
+
+func (s *TurnServer) allowPeer(ip net.IP) bool {
+	s.Lock()
+	self := s.selfIPs[ip.String()]
+	s.Unlock()
+	if self {
+		return true
+	}
+	for _, ipnet := range s.params.AllowedPeers {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Credentials returns the REST API credentials(username/password) for user,
+// or empty if no shared secret.
+func (s *TurnServer) Credentials(user string) (string, string) {
+	if s == nil || len(s.params.Secret) == 0 {
+		return "", ""
+	}
+	expiry := time.Now().Add(s.params.CredentialTtl).Unix()
+	username := strconv.FormatInt(expiry, 10)
+	if len(user) > 0 {
+		username += ":" + user
+	}
+	return username, turnSecretPassword(s.params.Secret, username)
+}
+
+// turnSecretPassword returns base64(hmac-sha1(secret, username)).
+func turnSecretPassword(secret, username string) string {
+	macFunc := hmac.New(sha1.New, []byte(secret))
+	macFunc.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(macFunc.Sum(nil))
+}
+
+// password returns the password of long-term credentials or REST API credentials("expiry[:user]").
+func (s *TurnServer) password(username string) (string, bool) {
+	if password, ok := s.params.Users[username]; ok {
+		return password, true
+	}
+	if len(s.params.Secret) > 0 {
+		expiry := strings.SplitN(username, ":", 2)[0]
+		if ts, err := strconv.ParseInt(expiry, 10, 64); err == nil && time.Now().Unix() < ts {
+			return turnSecretPassword(s.params.Secret, username), true
+		}
+	}
+	return "", false
+}
+
+// makeNonce returns hex(expiry) + hex(hmac(expiry)[:8]).
+func (s *TurnServer) makeNonce() string {
+	expiry := strconv.FormatInt(time.Now().Add(kTurnNonceLifetime).Unix(), 16)
+	return expiry + s.signNonce(expiry)
+}
+
+func (s *TurnServer) signNonce(expiry string) string {
+	macFunc := hmac.New(sha1.New, s.nonceKey)
+	macFunc.Write([]byte(expiry))
+	return hex.EncodeToString(macFunc.Sum(nil)[0:8])
+}
+
+func (s *TurnServer) checkNonce(nonce string) bool {
+	if len(nonce) <= 16 {
+		return false
+	}
+	expiry, sign := nonce[0:len(nonce)-16], nonce[len(nonce)-16:]
+	if !hmac.Equal([]byte(sign), []byte(s.signNonce(expiry))) {
+		return false
+	}
+	ts, err := strconv.ParseInt(expiry, 16, 64)
+	return err == nil && time.Now().Unix() < ts
+}
+
+// authenticate checks the long-term credentials, and returns username and key, or error code.
+func (s *TurnServer) authenticate(data []byte, msg *util.StunMessage) (string, string, int) {
+	username := stunString(msg, util.STUN_ATTR_USERNAME)
+	realm := stunString(msg, util.STUN_ATTR_REALM)
+	nonce := stunString(msg, util.STUN_ATTR_NONCE)
+	if len(username) == 0 || len(realm) == 0 || len(nonce) == 0 {
+		return "", "", 401
+	}
+	if !s.checkNonce(nonce) {
+		return "", "", 438
+	}
+	password, ok := s.password(username)
+	if !ok {
+		return "", "", 401
+	}
+	sum := md5.Sum([]byte(username + ":" + s.params.Realm + ":" + password))
+	key := string(sum[:])
+	if !util.ValidateStunMessageIntegrity(data, key) {
+		return "", "", 401
+	}
+	return username, key, 0
+}
+
+func stunString(msg *util.StunMessage, attrType util.StunAttributeType) string {
+	if attr, ok := msg.GetAttribute(attrType).(*util.StunByteStringAttribute); ok {
+		return string(attr.Data)
+	}
+	return ""
+}
+
+func stunUint32(msg *util.StunMessage, attrType util.StunAttributeType) (uint32, bool) {
+	if attr, ok := msg.GetAttribute(attrType).(*util.StunUInt32Attribute); ok {
+		return attr.Value(), true
+	}
+	return 0, false
+}
+
+func stunPeers(msg *util.StunMessage) []*net.UDPAddr {
+	var peers []*net.UDPAddr
+	for _, attr := range msg.OrderAttrs {
+		if attr.GetType() == util.TURN_ATTR_XOR_PEER_ADDRESS {
+			if xattr, ok := attr.(*util.StunXorAddressAttribute); ok {
+				peers = append(peers, xattr.UDPAddr())
+			}
+		}
+	}
+	return peers
+}
+
+// HandlePacket handles one TURN message or ChannelData from client,
+// stream is true for tcp(ChannelData padded).
+func (s *TurnServer) HandlePacket(data []byte, from net.Addr, stream bool, send TurnSender) {
+	key := util.NetAddrString(from)
+	if channel, payload, ok := util.ParseChannelData(data); ok {
+		if alloc := s.getAllocation(key); alloc != nil {
+			alloc.sendToChannel(channel, payload)
+		}
+		return
+	}
+
+	var msg util.StunMessage
+	if err := msg.Decode(data); err != nil {
+		log.Warnln(s.TAG, "invalid turn message from", key, err)
+		if err.Code != 0 && msg.Class() == util.STUN_CLASS_REQUEST {
+			send(s.writeMessage(util.NewStunErrorResponse(&msg, err), "", nil))
+		}
+		return
+	}
+	switch msg.Class() {
+	case util.STUN_CLASS_INDICATION:
+		if msg.Method() == util.TURN_METHOD_SEND {
+			if alloc := s.getAllocation(key); alloc != nil {
+				alloc.sendIndication(&msg)
+			}
+		}
+	case util.STUN_CLASS_REQUEST:
+		if resp := s.handleRequest(key, data, &msg, from, stream, send); resp != nil {
+			send(resp)
+		}
+	}
+}
+
+func (s *TurnServer) handleRequest(key string, data []byte, msg *util.StunMessage,
+	from net.Addr, stream bool, send TurnSender) []byte {
+	username, hmacKey, code := s.authenticate(data, msg)
+	if code != 0 {
+		return s.errorResponse(msg, code, "",
+			util.NewStunByteStringAttribute(util.STUN_ATTR_REALM, []byte(s.params.Realm)),
+			util.NewStunByteStringAttribute(util.STUN_ATTR_NONCE, []byte(s.makeNonce())))
+	}
+
+	if msg.Method() == util.TURN_METHOD_ALLOCATE {
+		return s.allocate(key, username, hmacKey, msg, from, stream, send)
+	}
+
+	alloc := s.getAllocation(key)
+	if alloc == nil {
+		return s.errorResponse(msg, 437, hmacKey)
+	}
+	if alloc.username != username {
+		return s.errorResponse(msg, 441, hmacKey)
+	}
+
+	switch msg.Method() {
+	case util.TURN_METHOD_REFRESH:
+		lifetime := s.lifetime(msg)
+		if lifetime == 0 {
+			s.RemoveAllocation(key)
+		} else {
+			alloc.refresh(lifetime)
+		}
+		return s.response(msg, hmacKey,
+			util.NewStunUInt32Attribute(util.TURN_ATTR_LIFETIME, uint32(lifetime/time.Second)))
+	case util.TURN_METHOD_CREATE_PERMISSION:
+		peers := stunPeers(msg)
+		if len(peers) == 0 {
+			return s.errorResponse(msg, 400, hmacKey)
+		}
+		for _, peer := range peers {
+			if !s.allowPeer(peer.IP) {
+				log.Warnln(s.TAG, "forbidden peer:", peer, "for", key)
+				return s.errorResponse(msg, 403, hmacKey)
+			}
+		}
+		for _, peer := range peers {
+			alloc.addPermission(peer.IP)
+		}
+		return s.response(msg, hmacKey)
+	case util.TURN_METHOD_CHANNEL_BIND:
+		channel, ok := stunUint32(msg, util.TURN_ATTR_CHANNEL_NUMBER)
+		peers := stunPeers(msg)
+		if !ok || len(peers) != 1 {
+			return s.errorResponse(msg, 400, hmacKey)
+		}
+		if !s.allowPeer(peers[0].IP) {
+			return s.errorResponse(msg, 403, hmacKey)
+		}
+		if !alloc.bindChannel(uint16(channel>>16), peers[0]) {
+			return s.errorResponse(msg, 400, hmacKey)
+		}
+		return s.response(msg, hmacKey)
+	default:
+		return s.errorResponse(msg, 400, hmacKey)
+	}
+}
+
+// lifetime returns the lifetime of Allocate/Refresh, bounded by config(0 to delete).
+func (s *TurnServer) lifetime(msg *util.StunMessage) time.Duration {
+	value, ok := stunUint32(msg, util.TURN_ATTR_LIFETIME)
+	if !ok {
+		return s.params.Lifetime
+	}
+	lifetime := time.Duration(value) * time.Second
+	if lifetime == 0 && msg.Method() == util.TURN_METHOD_REFRESH {
+		return 0
+	}
+	if lifetime < s.params.Lifetime {
+		lifetime = s.params.Lifetime
+	} else if lifetime > s.params.MaxLifetime {
+		lifetime = s.params.MaxLifetime
+	}
+	return lifetime
+}
+
+func (s *TurnServer) allocate(key, username, hmacKey string, msg *util.StunMessage,
+	from net.Addr, stream bool, send TurnSender) []byte {
+	if alloc := s.getAllocation(key); alloc != nil {
+		// the retransmit gets the same success response(RFC 8656 7.2)
+		if resp := alloc.allocateResponse(msg.TransId); resp != nil {
+			return resp
+		}
+		return s.errorResponse(msg, 437, hmacKey)
+	}
+	transport, ok := stunUint32(msg, util.TURN_ATTR_REQUESTED_TRANSPORT)
+	if !ok {
+		return s.errorResponse(msg, 400, hmacKey)
+	}
+	if transport>>24 != kTurnTransportUdp {
+		return s.errorResponse(msg, 442, hmacKey)
+	}
+	if family, ok := stunUint32(msg, util.TURN_ATTR_REQUESTED_ADDRESS_FAMILY); ok {
+		isIPv4 := (s.relayIP.To4() != nil)
+		if (family>>24 == 0x01) != isIPv4 {
+			return s.errorResponse(msg, 440, hmacKey)
+		}
+	}
+
+	s.Lock()
+	count := len(s.allocs)
+	s.Unlock()
+	if count >= s.params.MaxAllocations {
+		return s.errorResponse(msg, 486, hmacKey)
+	}
+
+	relay, err := s.listenRelay()
+	if err != nil {
+		log.Warnln(s.TAG, "listen relay failed:", err)
+		return s.errorResponse(msg, 508, hmacKey)
+	}
+	relayAddr := &net.UDPAddr{IP: s.relayIP, Port: relay.LocalAddr().(*net.UDPAddr).Port}
+	lifetime := s.lifetime(msg)
+	resp := s.response(msg, hmacKey,
+		util.NewStunXorAddressAttribute(util.TURN_ATTR_XOR_RELAYED_ADDRESS, relayAddr, msg.TransId),
+		util.NewStunUInt32Attribute(util.TURN_ATTR_LIFETIME, uint32(lifetime/time.Second)),
+		util.NewStunXorAddressAttribute(util.STUN_ATTR_XOR_MAPPED_ADDRESS, from, msg.TransId))
+	alloc := NewTurnAllocation(s, key, username, relay, stream, send)
+	alloc.refresh(lifetime)
+	alloc.allocTransId = msg.TransId
+	alloc.allocResp = resp
+
+	s.Lock()
+	if _, ok := s.allocs[key]; ok {
+		s.Unlock()
+		alloc.Close()
+		return s.errorResponse(msg, 437, hmacKey)
+	}
+	s.allocs[key] = alloc
+	s.Unlock()
+
+	log.Println(s.TAG, "allocate", relayAddr, "for", key, username, lifetime)
+	go alloc.Run()
+	return resp
+}
+
+// listenRelay listens one udp port in range(min_port-max_port), or any port.
+func (s *TurnServer) listenRelay() (*net.UDPConn, error) {
+	if s.params.MinPort <= 0 {
+		return net.ListenUDP("udp", &net.UDPAddr{})
+	}
+	size := s.params.MaxPort - s.params.MinPort + 1
+	start := 0
+	if n, err := rand.Int(rand.Reader, big.NewInt(int64(size))); err == nil {
+		start = int(n.Int64())
+	}
+	var err error
+	for i := 0; i < size; i++ {
+		port := s.params.MinPort + (start+i)%size
+		var conn *net.UDPConn
+		if conn, err = net.ListenUDP("udp", &net.UDPAddr{Port: port}); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (s *TurnServer) response(req *util.StunMessage, hmacKey string, attrs ...util.StunAttribute) []byte {
+	resp := &util.StunMessage{Dtype: req.Method() | util.STUN_CLASS_SUCCESS, TransId: req.TransId}
+	return s.writeMessage(resp, hmacKey, attrs)
+}
+
+// errorResponse returns error response, without integrity if no hmacKey(e.g. 401/438).
+func (s *TurnServer) errorResponse(req *util.StunMessage, code int, hmacKey string, attrs ...util.StunAttribute) []byte {
+	resp := &util.StunMessage{Dtype: req.Method() | util.STUN_CLASS_ERROR, TransId: req.TransId}
+	attrs = append([]util.StunAttribute{util.NewStunErrorCodeAttribute(code, kTurnErrorReasons[code])}, attrs...)
+	return s.writeMessage(resp, hmacKey, attrs)
+}
+
+func (s *TurnServer) writeMessage(msg *util.StunMessage, hmacKey string, attrs []util.StunAttribute) []byte {
+	for _, attr := range attrs {
+		msg.AddAttribute(attr)
+	}
+	if len(hmacKey) > 0 {
+		msg.AddMessageIntegrity(hmacKey)
+	}
+	msg.AddFingerprint()
+
+	var buf bytes.Buffer
+	if !msg.Write(&buf) {
+		log.Warnln(s.TAG, "write turn message failed")
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (s *TurnServer) getAllocation(key string) *TurnAllocation {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.allocs[key]
+}
+
+// RemoveAllocation deletes the allocation of client(e.g. tcp closed).
+func (s *TurnServer) RemoveAllocation(key string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	alloc, ok := s.allocs[key]
+	delete(s.allocs, key)
+	s.Unlock()
+	if ok {
+		log.Println(s.TAG, "remove allocation for", key)
+		alloc.Close()
+	}
+}
+
+// sweep removes the expired allocations, permissions and channels.
+func (s *TurnServer) sweep() {
+	now := time.Now()
+	var expired []*TurnAllocation
+	s.Lock()
+	for key, alloc := range s.allocs {
+		if alloc.sweep(now) {
+			expired = append(expired, alloc)
+			delete(s.allocs, key)
+		}
+	}
+	s.Unlock()
+
+	for _, alloc := range expired {
+		log.Println(s.TAG, "allocation expired for", alloc.key)
+		alloc.Close()
+	}
+}
+
+func (s *TurnServer) Close() {
+	if s == nil {
+		return
+	}
+	s.exitTick <- true
+	s.Lock()
+	allocs := s.allocs
+	s.allocs = make(map[string]*TurnAllocation)
+	s.Unlock()
+	for _, alloc := range allocs {
+		alloc.Close()
+	}
+}
+
+func (s *TurnServer) Run() {
+	tickChan := time.NewTicker(kTurnSweepInterval).C
+	for {
+		select {
+		case <-s.exitTick:
+			close(s.exitTick)
+			log.Println(s.TAG, "Run exit...")
+			return
+		case <-tickChan:
+			s.sweep()
+		}
+	}
+}
+
+type turnChannel struct {
+	peer    *net.UDPAddr
+	expires time.Time
+}
+
+// TurnAllocation is the relayed address of one client.
+type TurnAllocation struct {
+	TAG         string
+	key         string // client 5-tuple
+	username    string
+	server      *TurnServer
+	relay       *net.UDPConn
+	stream      bool
+	send        TurnSender
+	expires     time.Time
+	permissions map[string]time.Time    // peer ip => expires
+	channels    map[uint16]*turnChannel // channel => peer
+	peers       map[string]uint16       // peer addr => channel
+
+	// the Allocate request and its success response, for retransmits
+	allocTransId string
+	allocResp    []byte
+
+	sync.Mutex
+}
+
+func NewTurnAllocation(server *TurnServer, key, username string, relay *net.UDPConn,
+	stream bool, send TurnSender) *TurnAllocation {
+	return &TurnAllocation{
+		TAG:         server.TAG + "[" + key + "]",
+		key:         key,
+		username:    username,
+		server:      server,
+		relay:       relay,
+		stream:      stream,
+		send:        send,
+		permissions: make(map[string]time.Time),
+		channels:    make(map[uint16]*turnChannel),
+		peers:       make(map[string]uint16),
+	}
+}
+
+// allocateResponse returns the success response of the same Allocate, or nil for others.
+func (a *TurnAllocation) allocateResponse(transId string) []byte {
+	if transId != a.allocTransId {
+		return nil
+	}
+	return a.allocResp
+}
+
+func (a *TurnAllocation) refresh(lifetime time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	a.expires = time.Now().Add(lifetime)
+}
+
+func (a *TurnAllocation) addPermission(ip net.IP) {
+	a.Lock()
+	defer a.Unlock()
+	a.permissions[ip.String()] = time.Now().Add(kTurnPermissionLifetime)
+}
+
+func (a *TurnAllocation) permitted(ip net.IP) bool {
+	a.Lock()
+	defer a.Unlock()
+	expires, ok := a.permissions[ip.String()]
+	return ok && time.Now().Before(expires)
+}
+
+// bindChannel binds or refreshes channel, which also installs permission of peer.
+func (a *TurnAllocation) bindChannel(channel uint16, peer *net.UDPAddr) bool {
+	if channel < util.TurnChannelMin || channel > util.TurnChannelMax {
+		return false
+	}
+	a.Lock()
+	defer a.Unlock()
+	if ch, ok := a.channels[channel]; ok && ch.peer.String() != peer.String() {
+		return false
+	}
+	if bound, ok := a.peers[peer.String()]; ok && bound != channel {
+		return false
+	}
+	now := time.Now()
+	a.channels[channel] = &turnChannel{peer: peer, expires: now.Add(kTurnChannelLifetime)}
+	a.peers[peer.String()] = channel
+	a.permissions[peer.IP.String()] = now.Add(kTurnPermissionLifetime)
+	return true
+}
+
+// sweep removes the expired permissions/channels, and returns true if allocation is expired.
+func (a *TurnAllocation) sweep(now time.Time) bool {
+	a.Lock()
+	defer a.Unlock()
+	for ip, expires := range a.permissions {
+		if now.After(expires) {
+			delete(a.permissions, ip)
+		}
+	}
+	for channel, ch := range a.channels {
+		if now.After(ch.expires) {
+			delete(a.channels, channel)
+			delete(a.peers, ch.peer.String())
+		}
+	}
+	return now.After(a.expires)
+}
+
+// sendIndication relays the data of Send indication to peer.
+func (a *TurnAllocation) sendIndication(msg *util.StunMessage) {
+	data, ok := msg.GetAttribute(util.TURN_ATTR_DATA).(*util.StunByteStringAttribute)
+	peers := stunPeers(msg)
+	if !ok || len(peers) != 1 {
+		return
+	}
+	a.sendToPeer(peers[0], data.Data)
+}
+
+// sendToChannel relays ChannelData to the peer bound.
+func (a *TurnAllocation) sendToChannel(channel uint16, data []byte) {
+	a.Lock()
+	ch, ok := a.channels[channel]
+	a.Unlock()
+	if ok {
+		a.sendToPeer(ch.peer, data)
+	}
+}
+
+func (a *TurnAllocation) sendToPeer(peer *net.UDPAddr, data []byte) {
+	if !a.permitted(peer.IP) {
+		return
+	}
+	if _, err := a.relay.WriteToUDP(data, peer); err != nil {
+		log.Warnln(a.TAG, "relay to", peer, "err:", err)
+	}
+}
+
+// Run relays data from peers to client, by ChannelData or Data indication.
+func (a *TurnAllocation) Run() {
+	rbuf := make([]byte, 1024*64)
+	for {
+		nret, peer, err := a.relay.ReadFromUDP(rbuf)
+		if err != nil {
+			log.Println(a.TAG, "relay end:", err)
+			return
+		}
+		if !a.permitted(peer.IP) {
+			continue
+		}
+
+		a.Lock()
+		channel, ok := a.peers[peer.String()]
+		a.Unlock()
+
+		var data []byte
+		if ok {
+			data = util.MakeChannelData(channel, rbuf[0:nret], a.stream)
+		} else {
+			transId := util.RandomString(12)
+			msg := &util.StunMessage{Dtype: util.TURN_METHOD_DATA | util.STUN_CLASS_INDICATION, TransId: transId}
+			msg.AddAttribute(util.NewStunXorAddressAttribute(util.TURN_ATTR_XOR_PEER_ADDRESS, peer, transId))
+			payload := make([]byte, nret)
+			copy(payload, rbuf[0:nret])
+			msg.AddAttribute(util.NewStunByteStringAttribute(util.TURN_ATTR_DATA, payload))
+			var buf bytes.Buffer
+			if !msg.Write(&buf) {
+				continue
+			}
+			data = buf.Bytes()
+		}
+		if err := a.send(data); err != nil {
+			log.Warnln(a.TAG, "send to client err:", err)
+		}
+	}
+}
+
+func (a *TurnAllocation) Close() {
+	a.relay.Close()
+}
@@ -0,0 +1,204 @@
+package webrtc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/PeterXu/xrtc/util"
+)
+
+type turnTestClient struct {
+	t      *testing.T
+	server *TurnServer
+	from   net.Addr
+	recv   chan []byte
+	realm  string
+	nonce  string
+	key    string
+}
+
+func (c *turnTestClient) send(data []byte) {
+	c.server.HandlePacket(data, c.from, false, func(data []byte) error {
+		c.recv <- data
+		return nil
+	})
+}
+
+func (c *turnTestClient) wait() []byte {
+	select {
+	case data := <-c.recv:
+		return data
+	case <-time.After(2 * time.Second):
+		c.t.Fatal("wait turn data timeout")
+		return nil
+	}
+}
+
+// request sends one request(with credentials after 401), and returns the response.
+func (c *turnTestClient) request(method util.StunMessageType, attrs ...util.StunAttribute) *util.StunMessage {
+	return c.requestWithId(util.RandomString(12), method, attrs...)
+}
+
+// requestWithId is request with the transaction id(e.g. retransmit).
+func (c *turnTestClient) requestWithId(transId string, method util.StunMessageType,
+	attrs ...util.StunAttribute) *util.StunMessage {
+	msg := &util.StunMessage{Dtype: method | util.STUN_CLASS_REQUEST, TransId: transId}
+	for _, attr := range attrs {
+		msg.AddAttribute(attr)
+	}
+	if len(c.nonce) > 0 {
+		msg.AddAttribute(util.NewStunByteStringAttribute(util.STUN_ATTR_USERNAME, []byte("alice")))
+		msg.AddAttribute(util.NewStunByteStringAttribute(util.STUN_ATTR_REALM, []byte(c.realm)))
+		msg.AddAttribute(util.NewStunByteStringAttribute(util.STUN_ATTR_NONCE, []byte(c.nonce)))
+		msg.AddMessageIntegrity(c.key)
+	}
+	var buf bytes.Buffer
+	msg.Write(&buf)
+	c.send(buf.Bytes())
+
+	data := c.wait()
+	var resp util.StunMessage
+	if !resp.Read(data) || resp.TransId != transId || resp.Method() != method {
+		c.t.Fatalf("invalid response for %x", method)
+	}
+	if resp.Class() == util.STUN_CLASS_SUCCESS && !util.ValidateStunMessageIntegrity(data, c.key) {
+		c.t.Fatalf("invalid integrity of response for %x", method)
+	}
+	return &resp
+}
+
+func turnErrorCode(msg *util.StunMessage) int {
+	if attr, ok := msg.GetAttribute(util.STUN_ATTR_ERROR_CODE).(*util.StunErrorCodeAttribute); ok {
+		return attr.Code()
+	}
+	return 0
+}
+
+func TestTurnServer(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	server := NewTurnServer(&TurnParams{
+		Realm:          "xrtc",
+		Users:          map[string]string{"alice": "secret"},
+		Secret:         "rest-secret",
+		CredentialTtl:  time.Hour,
+		Lifetime:       kTurnDefaultLifetime,
+		MaxLifetime:    kTurnMaxLifetime,
+		MaxAllocations: 10,
+		RelayIP:        "127.0.0.1",
+		AllowedPeers:   []*net.IPNet{loopback},
+	})
+	defer server.Close()
+
+	client := &turnTestClient{
+		t:      t,
+		server: server,
+		from:   &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000},
+		recv:   make(chan []byte, 10),
+	}
+	transport := util.NewStunUInt32Attribute(util.TURN_ATTR_REQUESTED_TRANSPORT, kTurnTransportUdp<<24)
+
+	// 401 with realm/nonce, then authenticated
+	resp := client.request(util.TURN_METHOD_ALLOCATE, transport)
+	if code := turnErrorCode(resp); code != 401 {
+		t.Fatalf("want 401, got %d", code)
+	}
+	client.realm = stunString(resp, util.STUN_ATTR_REALM)
+	client.nonce = stunString(resp, util.STUN_ATTR_NONCE)
+	sum := md5.Sum([]byte("alice:" + client.realm + ":secret"))
+	client.key = string(sum[:])
+
+	allocId := util.RandomString(12)
+	resp = client.requestWithId(allocId, util.TURN_METHOD_ALLOCATE, transport)
+	relayed, ok := resp.GetAttribute(util.TURN_ATTR_XOR_RELAYED_ADDRESS).(*util.StunXorAddressAttribute)
+	if !ok {
+		t.Fatalf("allocate failed: %d", turnErrorCode(resp))
+	}
+	relayAddr := relayed.UDPAddr()
+	resp = client.requestWithId(allocId, util.TURN_METHOD_ALLOCATE, transport)
+	if again, ok := resp.GetAttribute(util.TURN_ATTR_XOR_RELAYED_ADDRESS).(*util.StunXorAddressAttribute); !ok ||
+		again.UDPAddr().String() != relayAddr.String() {
+		t.Fatalf("want the same success response for retransmit, got %d", turnErrorCode(resp))
+	}
+	if code := turnErrorCode(client.request(util.TURN_METHOD_ALLOCATE, transport)); code != 437 {
+		t.Fatalf("want 437 for second allocate, got %d", code)
+	}
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+	readPeer := func() string {
+		buf := make([]byte, 1500)
+		peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := peer.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[0:n])
+	}
+
+	// permission and Data/Send indication
+	forbidden := &net.UDPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53}
+	if code := turnErrorCode(client.request(util.TURN_METHOD_CREATE_PERMISSION,
+		util.NewStunXorAddressAttribute(util.TURN_ATTR_XOR_PEER_ADDRESS, forbidden, ""))); code != 403 {
+		t.Fatalf("want 403 for forbidden peer, got %d", code)
+	}
+	resp = client.request(util.TURN_METHOD_CREATE_PERMISSION,
+		util.NewStunXorAddressAttribute(util.TURN_ATTR_XOR_PEER_ADDRESS, peerAddr, ""))
+	if resp.Class() != util.STUN_CLASS_SUCCESS {
+		t.Fatalf("create permission failed: %d", turnErrorCode(resp))
+	}
+
+	peer.WriteToUDP([]byte("hello"), relayAddr)
+	var ind util.StunMessage
+	if !ind.Read(client.wait()) || ind.Method() != util.TURN_METHOD_DATA || stunString(&ind, util.TURN_ATTR_DATA) != "hello" {
+		t.Fatalf("want data indication, got %v", ind)
+	}
+
+	transId := util.RandomString(12)
+	send := &util.StunMessage{Dtype: util.TURN_METHOD_SEND | util.STUN_CLASS_INDICATION, TransId: transId}
+	send.AddAttribute(util.NewStunXorAddressAttribute(util.TURN_ATTR_XOR_PEER_ADDRESS, peerAddr, transId))
+	send.AddAttribute(util.NewStunByteStringAttribute(util.TURN_ATTR_DATA, []byte("world")))
+	var buf bytes.Buffer
+	send.Write(&buf)
+	client.send(buf.Bytes())
+	if data := readPeer(); data != "world" {
+		t.Fatalf("want world, got %s", data)
+	}
+
+	// channel
+	resp = client.request(util.TURN_METHOD_CHANNEL_BIND,
+		util.NewStunUInt32Attribute(util.TURN_ATTR_CHANNEL_NUMBER, 0x4001<<16),
+		util.NewStunXorAddressAttribute(util.TURN_ATTR_XOR_PEER_ADDRESS, peerAddr, ""))
+	if resp.Class() != util.STUN_CLASS_SUCCESS {
+		t.Fatalf("channel bind failed: %d", turnErrorCode(resp))
+	}
+	peer.WriteToUDP([]byte("ping"), relayAddr)
+	if channel, data, ok := util.ParseChannelData(client.wait()); !ok || channel != 0x4001 || string(data) != "ping" {
+		t.Fatalf("want channel data, got %x %s", channel, data)
+	}
+	client.send(util.MakeChannelData(0x4001, []byte("pong"), false))
+	if data := readPeer(); data != "pong" {
+		t.Fatalf("want pong, got %s", data)
+	}
+
+	// delete by refresh(0)
+	client.request(util.TURN_METHOD_REFRESH, util.NewStunUInt32Attribute(util.TURN_ATTR_LIFETIME, 0))
+	if server.getAllocation(util.NetAddrString(client.from)) != nil {
+		t.Fatalf("allocation not deleted")
+	}
+
+	// REST API credentials
+	username, password := server.Credentials("bob")
+	if pwd, ok := server.password(username); !ok || pwd != password {
+		t.Fatalf("invalid REST API credentials: %s", username)
+	}
+	if _, ok := server.password("1:bob"); ok {
+		t.Fatalf("want expired credentials")
+	}
+}
@@ -15,6 +15,7 @@ type UdpServer struct {
 	config *NetConfig
 
 	conn     *net.UDPConn
+	stun     *StunResponder
 	stat     *NetStat
 	clients  map[string]*NetStat
 	chanRecv chan interface{}
@@ -34,6 +35,7 @@ func NewUdpServer(hub *MaxHub, cfg *NetConfig) *UdpServer {
 				hub:      hub,
 				config:   cfg,
 				conn:     conn,
+				stun:     NewStunResponder(&cfg.Net),
 				stat:     NewNetStat(0, 0),
 				clients:  make(map[string]*NetStat),
 				chanRecv: make(chan interface{}, 1000),
@@ -79,6 +81,14 @@ func (u *UdpServer) Run() {
 			u.stat.updateRecv(nret)
 			data := make([]byte, nret)
 			copy(data, rbuf[0:nret])
+			if resp := u.stun.Response(data, raddr); resp != nil {
+				u.chanRecv <- NewHubMessage(resp, nil, raddr, nil)
+				continue
+			}
+			if turn := u.hub.Turn(); turn != nil && u.config.Net.EnableTurn && util.IsTurnPacket(data) {
+				turn.HandlePacket(data, raddr, false, u.turnSender(raddr))
+				continue
+			}
 			sendChan <- NewHubMessage(data, raddr, nil, u.chanRecv)
 		}
 	}
@@ -88,6 +98,14 @@ func (u *UdpServer) Run() {
 	log.Println(u.TAG, "main end")
 }
 
+// turnSender sends turn data to client by writing goroutine.
+func (u *UdpServer) turnSender(to net.Addr) TurnSender {
+	return func(data []byte) error {
+		u.chanRecv <- NewHubMessage(data, nil, to, nil)
+		return nil
+	}
+}
+
 func (u *UdpServer) writing() {
 	tickChan := time.NewTicker(time.Second * 10).C
 
@@ -1,6 +1,8 @@
 package webrtc
 
 import (
+	"sync"
+
 	"github.com/PeterXu/xrtc/util"
 	log "github.com/PeterXu/xrtc/util"
 )
@@ -14,10 +16,18 @@ type User struct {
 	chanSend    chan interface{}       // data to inner(server)
 	service     *Service               // inner webrtc server
 
+	// mtx guards leave/closed/connections/activeConn,
+	// which are changed by hub and read by service goroutine.
+	mtx        sync.Mutex
 	leave      bool
+	hadMedia   bool        // had the first media from client
+	closed     string      // the reason of closed
+	hook       *Webhook    // session events
+	sessionKey string      // session_key from register request
 	activeConn *Connection // active conn
 	sendIce    SdpIceInfo
 	recvIce    SdpIceInfo
+	iceRole    *IceRole // role on client leg
 
 	utime uint64 // update time
 	ctime uint64 // create time
@@ -30,6 +40,7 @@ func NewUser(iceTcp, iceDirect bool) *User {
 		iceTcp:      iceTcp,
 		iceDirect:   iceDirect,
 		connections: make(map[string]*Connection),
+		iceRole:     NewIceRole(kIceRoleControlled, false),
 		chanSend:    make(chan interface{}, 100),
 		utime:       now,
 		ctime:       now,
@@ -40,6 +51,42 @@ func (u *User) getIceKey() string {
 	return u.recvIce.Ufrag + ":" + u.sendIce.Ufrag
 }
 
+func (u *User) setSessionKey(key string) {
+	u.sessionKey = key
+}
+
+func (u *User) getSessionKey() string {
+	return u.sessionKey
+}
+
+func (u *User) setIceRole(role string, lite bool) {
+	u.iceRole = NewIceRole(role, lite)
+}
+
+func (u *User) getIceRole() *IceRole {
+	return u.iceRole
+}
+
+func (u *User) setWebhook(hook *Webhook) {
+	u.hook = hook
+}
+
+// notify posts one session event to webhook.
+func (u *User) notify(event string) *WebhookEvent {
+	// the same key with cache: "answer_ufrag:offer_ufrag"
+	ev := NewWebhookEvent(event, u.sessionKey, u.sendIce.Ufrag+":"+u.recvIce.Ufrag)
+	if event == kEventSessionClosed {
+		u.mtx.Lock()
+		ev.Reason = u.closed
+		u.mtx.Unlock()
+		if u.service != nil {
+			ev.Stat = u.service.statInfo()
+		}
+	}
+	u.hook.Post(ev)
+	return ev
+}
+
 func (u *User) setIceInfo(offerIce, answerIce *SdpIceInfo, candidates []string) bool {
 	log.Println(u.TAG, "set ice info:", offerIce, answerIce)
 	u.recvIce = *offerIce  // recv from offer(client -> proxy)
@@ -64,6 +111,8 @@ func (u *User) isIceDirect() bool {
 }
 
 func (u *User) addConnection(conn *Connection) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
 	if conn != nil && conn.getAddr() != nil {
 		u.connections[util.NetAddrString(conn.getAddr())] = conn
 		if u.activeConn == nil {
@@ -75,23 +124,56 @@ func (u *User) addConnection(conn *Connection) {
 }
 
 func (u *User) delConnection(conn *Connection) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
 	if conn != nil {
 		delete(u.connections, util.NetAddrString(conn.getAddr()))
 	}
 }
 
+// nominate selects the conn nominated by client(USE-CANDIDATE).
+func (u *User) nominate(conn *Connection) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	if u.activeConn != conn {
+		log.Println(u.TAG, "nominated conn:", util.NetAddrString(conn.getAddr()))
+		u.activeConn = conn
+	}
+}
+
 func (u *User) sendToInner(conn *Connection, data []byte) {
-	if u.leave {
+	u.mtx.Lock()
+	leave := u.leave
+	if !leave {
+		u.activeConn = conn
+	}
+	u.mtx.Unlock()
+	if leave {
 		return
 	}
-	u.activeConn = conn
+	if !u.hadMedia && util.IsRtpRtcpPacket(data) {
+		u.hadMedia = true
+		u.notify(kEventSessionMedia)
+	}
 	u.chanSend <- data
 }
 
 func (u *User) sendToOuter(data []byte) {
-	if u.leave {
+	conn := u.getActiveConn()
+	if conn == nil {
+		log.Warnln(u.TAG, "no active connection")
 		return
 	}
+	conn.sendData(data)
+}
+
+// getActiveConn returns the active conn(or the first ready), nil if left.
+func (u *User) getActiveConn() *Connection {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	if u.leave {
+		return nil
+	}
 
 	if u.activeConn == nil {
 		for k, v := range u.connections {
@@ -102,13 +184,7 @@ func (u *User) sendToOuter(data []byte) {
 			}
 		}
 	}
-
-	if u.activeConn == nil {
-		log.Warnln(u.TAG, "no active connection")
-		return
-	}
-
-	u.activeConn.sendData(data)
+	return u.activeConn
 }
 
 func (u *User) isTimeout() bool {
@@ -118,18 +194,35 @@ func (u *User) isTimeout() bool {
 	return false
 }
 
-func (u *User) dispose() {
-	log.Println(u.TAG, "dispose, connection size=", len(u.connections))
+func (u *User) dispose(reason string) {
+	log.Println(u.TAG, "dispose, connection size=", len(u.connections), reason)
+	u.mtx.Lock()
 	u.leave = true
+	if len(u.closed) == 0 {
+		u.closed = reason
+	}
+	u.mtx.Unlock()
+	u.notify(kEventSessionClosed)
 	if u.service != nil {
 		u.service.dispose()
 	}
+	u.mtx.Lock()
 	if len(u.connections) > 0 {
 		u.connections = make(map[string]*Connection)
 	}
+	u.mtx.Unlock()
+}
+
+func (u *User) onServiceConnected() {
+	u.notify(kEventUpstreamConnected)
 }
 
 func (u *User) onServiceClose() {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	if !u.leave && len(u.closed) == 0 {
+		u.closed = kCloseReasonUpstream
+	}
 	u.leave = true
 }
 
@@ -0,0 +1,156 @@
+package webrtc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/PeterXu/xrtc/util"
+	log "github.com/PeterXu/xrtc/util"
+)
+
+const (
+	kDefaultWebhookRetries = 3
+	kDefaultWebhookTimeout = 3 * time.Second
+	kWebhookWorkers        = 16
+	kWebhookQueueSize      = 1024 // pending deliveries, dropped if full
+	kWebhookEventHeader    = "X-Xrtc-Event"
+	kWebhookSignHeader     = "X-Xrtc-Signature" // "sha256=hex(hmac-sha256(secret, body))"
+)
+
+// These are session lifecycle events.
+const (
+	kEventSessionRegistered = "session.registered"         // register request cached
+	kEventSessionStun       = "session.stun"               // first client stun
+	kEventUpstreamConnected = "session.upstream_connected" // ice connected with webrtc server
+	kEventSessionMedia      = "session.media"              // first media from client
+	kEventSessionClosed     = "session.closed"             // with reason and stat
+	kEventSessionUnreached  = "session.unreached"          // expired without any client stun
+)
+
+// These are reasons of session closed.
+const (
+	kCloseReasonTimeout  = "timeout"
+	kCloseReasonDeleted  = "deleted"
+	kCloseReasonUpstream = "upstream_closed"
+)
+
+type WebhookEvent struct {
+	Event      string       `json:"event"`
+	Time       uint64       `json:"time"` // ms
+	SessionKey string       `json:"session_key,omitempty"`
+	IceKey     string       `json:"ice_key,omitempty"`
+	Reason     string       `json:"reason,omitempty"`
+	Stat       *NetStatInfo `json:"stat,omitempty"`
+}
+
+func NewWebhookEvent(event, sessionKey, iceKey string) *WebhookEvent {
+	return &WebhookEvent{
+		Event:      event,
+		Time:       util.NowMs64(),
+		SessionKey: sessionKey,
+		IceKey:     iceKey,
+	}
+}
+
+// Webhook posts session events to http receivers.
+type Webhook struct {
+	TAG    string
+	params WebhookParams
+	client *http.Client
+	queue  *util.TaskQueue
+}
+
+// NewWebhook returns nil if no receivers.
+func NewWebhook(params *WebhookParams) *Webhook {
+	if len(params.Urls) == 0 {
+		return nil
+	}
+	timeout := params.Timeout
+	if timeout <= 0 {
+		timeout = kDefaultWebhookTimeout
+	}
+	return &Webhook{
+		TAG:    "[WEBHOOK]",
+		params: *params,
+		client: &http.Client{Timeout: timeout},
+		queue:  util.NewTaskQueue(kWebhookWorkers, kWebhookQueueSize),
+	}
+}
+
+// Post sends event to all receivers asynchronously, and never blocks:
+// the event is dropped if too many deliveries pending.
+func (w *Webhook) Post(event *WebhookEvent) {
+	if w == nil {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Warnln(w.TAG, "marshal event err:", err)
+		return
+	}
+	for _, url := range w.params.Urls {
+		url := url
+		if !w.queue.Post(func() { w.deliver(url, event.Event, body) }) {
+			log.Warnln(w.TAG, "queue full or closed, drop", event.Event, "to", url)
+		}
+	}
+}
+
+// Close stops the delivery workers, and the pending events are dropped.
+func (w *Webhook) Close() {
+	if w == nil {
+		return
+	}
+	w.queue.Close()
+}
+
+func (w *Webhook) deliver(url, event string, body []byte) {
+	delay := 500 * time.Millisecond
+	for i := 0; i <= w.params.Retries; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if err := w.send(url, event, body); err != nil {
+			log.Warnln(w.TAG, "post", event, "to", url, "err:", err, ", retry:", i)
+			continue
+		}
+		return
+	}
+	log.Warnln(w.TAG, "drop", event, "to", url)
+}
+
+func (w *Webhook) send(url, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(kWebhookEventHeader, event)
+	if len(w.params.Secret) > 0 {
+		req.Header.Set(kWebhookSignHeader, "sha256="+signWebhookBody(w.params.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	util.ReadHttpRawBody(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("http status " + resp.Status)
+	}
+	return nil
+}
+
+// signWebhookBody returns hex(hmac-sha256(secret, body)).
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,103 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PeterXu/xrtc/util"
+)
+
+func TestWebhookPost(t *testing.T) {
+	const secret = "xrtc-secret"
+
+	events := make(chan *WebhookEvent, 1)
+	tries := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries += 1
+		if tries == 1 {
+			// the first post failed, then retry
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := util.ReadHttpRawBody(r.Body)
+		if sign := r.Header.Get(kWebhookSignHeader); sign != "sha256="+signWebhookBody(secret, body) {
+			t.Errorf("invalid signature: %s", sign)
+		}
+		if event := r.Header.Get(kWebhookEventHeader); event != kEventSessionClosed {
+			t.Errorf("invalid event header: %s", event)
+		}
+
+		var ev WebhookEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Error(err)
+		}
+		events <- &ev
+	}))
+	defer svr.Close()
+
+	hook := NewWebhook(&WebhookParams{
+		Urls:    []string{svr.URL},
+		Secret:  secret,
+		Retries: 1,
+	})
+
+	ev := NewWebhookEvent(kEventSessionClosed, "session1", "answer:offer")
+	ev.Reason = kCloseReasonDeleted
+	ev.Stat = NewNetStat(10, 20).Info()
+	hook.Post(ev)
+
+	select {
+	case got := <-events:
+		if got.SessionKey != "session1" || got.Reason != kCloseReasonDeleted {
+			t.Errorf("invalid event: %v", got)
+		}
+		if got.Stat == nil || got.Stat.SendBytes != 10 || got.Stat.RecvBytes != 20 {
+			t.Errorf("invalid event stat: %v", got.Stat)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("webhook timeout")
+	}
+}
+
+func TestWebhookDisabled(t *testing.T) {
+	hook := NewWebhook(&WebhookParams{})
+	if hook != nil {
+		t.Error("webhook should be nil without urls")
+	}
+	// no panic for nil webhook
+	hook.Post(NewWebhookEvent(kEventSessionStun, "", ""))
+	hook.Close()
+}
+
+func TestWebhookHang(t *testing.T) {
+	hang := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer svr.Close()
+	defer close(hang)
+
+	hook := NewWebhook(&WebhookParams{
+		Urls:    []string{svr.URL},
+		Retries: 3,
+		Timeout: time.Minute,
+	})
+
+	// all workers hang and the queue overflows, but Post never blocks
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < kWebhookWorkers+kWebhookQueueSize+100; i++ {
+			hook.Post(NewWebhookEvent(kEventSessionStun, "session1", "answer:offer"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook post blocked")
+	}
+}
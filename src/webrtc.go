@@ -7,8 +7,17 @@ import (
 )
 
 type Webrtc interface {
-	Cache() *Cache
+	Cache() Cache
+	Webhook() *Webhook
+	Backends() *BackendPool
+	Registry() SessionRegistry // nil if not cluster
+	Metrics() *Metrics
+	Routing() *RoutingPolicy
 	Candidates() []string // proxy candidates
+	ProxyGroups() []*ProxyGroup
+	Turn() *TurnServer // nil if not enabled
+	IceServers(user string) []*IceServer
+	DeleteSession(key string) error
 	Close()
 }
 
@@ -53,8 +62,19 @@ func Inst() Webrtc {
 	if gMaxHub == nil {
 		config := loadConfig(kDefaultConfig)
 		if config != nil {
-			hub := NewMaxHub()
+			setGeoIP(NewGeoIP(&config.GeoIP))
+			hub := NewMaxHub(NewCache(&config.Cache))
+			hub.SetRouting(NewRoutingPolicy(&config.Routing))
+			hub.SetWebhook(NewWebhook(&config.Webhook))
+			hub.SetBackends(NewBackendPool(&config.Backends))
+			hub.SetRegistry(NewSessionRegistry(&config.Cluster, hub.Cache(), hub.deleteLocalSession))
+			hub.SetProxies(config.Proxies)
+			hub.SetTurn(NewTurnServer(&config.Turn))
+			if len(config.Proxies) > 0 && hub.Registry() == nil {
+				log.Warnln(hub.TAG, "proxies are ignored without cluster")
+			}
 			startServers(hub, config)
+			hub.Turn().SetLocalCandidates(hub.Candidates())
 			gMaxHub = hub
 		}
 	}
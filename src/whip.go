@@ -0,0 +1,238 @@
+package webrtc
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/PeterXu/xrtc/util"
+	util "github.com/PeterXu/xrtc/util"
+)
+
+// WHIP(RFC 9725) and WHEP reverse-proxy
+const (
+	kApiWhip             = "/whip"
+	kApiWhep             = "/whep"
+	kWhipResourcePath    = "/resource/"
+	kWhipResourceTimeout = 24 * 3600 * 1000 // ms
+	kWhipUpstreamTimeout = 10 * time.Second
+
+	kSdpContentType     = "application/sdp"
+	kTrickleContentType = "application/trickle-ice-sdpfrag"
+)
+
+// headers forwarded to upstream
+var kWhipRequestHeaders = []string{"Content-Type", "Authorization", "Accept", "If-Match"}
+
+// headers forwarded to client
+var kWhipResponseHeaders = []string{"Content-Type", "ETag", "Link", "Accept-Patch"}
+
+var gWhipClient = &http.Client{Timeout: kWhipUpstreamTimeout}
+
+// WhipResource is one proxied WHIP/WHEP session.
+type WhipResource struct {
+	Url     string           // upstream resource url
+	Request *RegisterRequest // the registered offer/answer
+}
+
+func whipCacheKey(id string) string {
+	return "whip:" + id
+}
+
+// handleWhip serves WHIP/WHEP endpoint(prefix) and its resources.
+//
+//	POST   {prefix}               - create session (sdp offer)
+//	PATCH  {prefix}/resource/{id} - trickle/ice restart
+//	DELETE {prefix}/resource/{id} - terminate session
+func (p *HttpServerHandler) handleWhip(w http.ResponseWriter, r *http.Request, prefix, upstream string) {
+	if len(upstream) == 0 {
+		writeApiError(w, NewApiError(http.StatusNotFound, kApiCodeNotFound, "no upstream for "+prefix))
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, prefix)
+	if len(rest) == 0 || rest == "/" {
+		if r.Method != http.MethodPost {
+			writeApiError(w, NewApiError(http.StatusMethodNotAllowed, kApiCodeMethodNotAllowed, "only POST allowed"))
+			return
+		}
+		p.whipCreate(w, r, prefix, upstream)
+		return
+	}
+
+	if !strings.HasPrefix(rest, kWhipResourcePath) {
+		writeApiError(w, NewApiError(http.StatusNotFound, kApiCodeNotFound, "no such resource: "+rest))
+		return
+	}
+
+	id := strings.TrimPrefix(rest, kWhipResourcePath)
+	item := Inst().Cache().Get(whipCacheKey(id))
+	if item == nil {
+		writeApiError(w, NewApiError(http.StatusNotFound, kApiCodeNotFound, "no such resource: "+id))
+		return
+	}
+	res := item.data.(*WhipResource)
+
+	switch r.Method {
+	case http.MethodPatch:
+		p.whipPatch(w, r, id, res)
+	case http.MethodDelete:
+		p.whipDelete(w, r, id, res)
+	default:
+		writeApiError(w, NewApiError(http.StatusMethodNotAllowed, kApiCodeMethodNotAllowed, "only PATCH/DELETE allowed"))
+	}
+}
+
+func (p *HttpServerHandler) whipCreate(w http.ResponseWriter, r *http.Request, prefix, upstream string) {
+	offer, err := util.ReadHttpBody(r.Body, r.Header.Get("Content-Encoding"))
+	if err != nil || len(offer) == 0 {
+		writeApiError(w, NewApiError(http.StatusBadRequest, kApiCodeBadBody, "no sdp offer"))
+		return
+	}
+
+	offer = []byte(p.policy.Apply(string(offer)))
+	resp, answer, err := forwardWhip(r, http.MethodPost, upstream, offer)
+	if err != nil {
+		log.Warnln(p.TAG, "whip upstream error:", err)
+		writeApiError(w, NewApiError(http.StatusBadGateway, kApiCodeUpstream, err.Error()))
+		return
+	}
+	if resp.StatusCode != http.StatusCreated {
+		copyWhipResponse(w, resp, answer)
+		return
+	}
+
+	id := p.UUID()
+	jreq := &RegisterRequest{SessionKey: id, OfferSdp: string(offer), AnswerSdp: string(answer)}
+	if err := jreq.loadSdp(); err != nil {
+		writeApiError(w, NewApiError(http.StatusBadGateway, kApiCodeUpstream, err.Error()))
+		return
+	}
+
+	registered, _, apiErr := p.registerRequest(r.RemoteAddr, jreq)
+	if apiErr != nil {
+		writeApiError(w, apiErr)
+		return
+	}
+
+	// upstream resource url (maybe relative)
+	location := upstream
+	if base, err := url.Parse(upstream); err == nil {
+		if ref, err := url.Parse(resp.Header.Get("Location")); err == nil {
+			location = base.ResolveReference(ref).String()
+		}
+	}
+	res := &WhipResource{Url: location, Request: jreq}
+	Inst().Cache().Set(whipCacheKey(id), NewCacheItemEx(res, kWhipResourceTimeout))
+	log.Println(p.TAG, "whip resource:", id, location)
+
+	copyWhipHeaders(w, resp)
+	w.Header().Set("Content-Type", kSdpContentType)
+	w.Header().Set("Location", prefix+kWhipResourcePath+id)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(p.policy.Apply(registered.AnswerSdp)))
+}
+
+func (p *HttpServerHandler) whipPatch(w http.ResponseWriter, r *http.Request, id string, res *WhipResource) {
+	frag, err := util.ReadHttpBody(r.Body, r.Header.Get("Content-Encoding"))
+	if err != nil {
+		writeApiError(w, NewApiError(http.StatusBadRequest, kApiCodeBadBody, err.Error()))
+		return
+	}
+
+	resp, body, err := forwardWhip(r, http.MethodPatch, res.Url, frag)
+	if err != nil {
+		log.Warnln(p.TAG, "whip upstream error:", err)
+		writeApiError(w, NewApiError(http.StatusBadGateway, kApiCodeUpstream, err.Error()))
+		return
+	}
+	if resp.StatusCode != http.StatusOK || len(body) == 0 {
+		// trickle: 204 without body
+		copyWhipResponse(w, resp, body)
+		return
+	}
+
+	// ice restart: new ice info from sdpfrag of request/response
+	jreq := &RegisterRequest{SessionKey: id, OfferSdp: string(frag), AnswerSdp: string(body)}
+	if err := jreq.loadSdp(); err != nil {
+		writeApiError(w, NewApiError(http.StatusBadGateway, kApiCodeUpstream, err.Error()))
+		return
+	}
+	if len(jreq.Candidates) == 0 {
+		jreq.Candidates = res.Request.Candidates
+	}
+
+	registered, _, apiErr := p.registerRequest(r.RemoteAddr, jreq)
+	if apiErr != nil {
+		writeApiError(w, apiErr)
+		return
+	}
+	log.Println(p.TAG, "whip ice restart:", id, jreq.iceKey())
+	if oldKey := res.Request.iceKey(); oldKey != jreq.iceKey() {
+		// the session of old ice is replaced
+		if err := Inst().DeleteSession(oldKey); err != nil {
+			log.Warnln(p.TAG, "whip delete old session err:", err)
+		}
+	}
+	res.Request = jreq
+
+	copyWhipHeaders(w, resp)
+	w.Header().Set("Content-Type", kTrickleContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(registered.AnswerSdp))
+}
+
+func (p *HttpServerHandler) whipDelete(w http.ResponseWriter, r *http.Request, id string, res *WhipResource) {
+	resp, body, err := forwardWhip(r, http.MethodDelete, res.Url, nil)
+	if err != nil {
+		log.Warnln(p.TAG, "whip upstream error:", err)
+	}
+
+	log.Println(p.TAG, "whip delete:", id)
+	Inst().DeleteSession(res.Request.iceKey())
+	Inst().Cache().Delete(whipCacheKey(id))
+
+	if resp != nil {
+		copyWhipResponse(w, resp, body)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// forwardWhip sends the request to upstream and returns its response and body.
+func forwardWhip(r *http.Request, method, upstream string, body []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, upstream, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, key := range kWhipRequestHeaders {
+		if value := r.Header.Get(key); len(value) > 0 {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := gWhipClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	rbody, err := util.ReadHttpBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	return resp, rbody, err
+}
+
+func copyWhipHeaders(w http.ResponseWriter, resp *http.Response) {
+	for _, key := range kWhipResponseHeaders {
+		if value := resp.Header.Get(key); len(value) > 0 {
+			w.Header().Set(key, value)
+		}
+	}
+}
+
+func copyWhipResponse(w http.ResponseWriter, resp *http.Response, body []byte) {
+	copyWhipHeaders(w, resp)
+	w.WriteHeader(resp.StatusCode)
+	if len(body) > 0 {
+		w.Write(body)
+	}
+}
@@ -0,0 +1,128 @@
+package webrtc
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var kWhipOfferSdp = strings.ReplaceAll(kJanusClientSdp, "cliu", "whpc")
+var kWhipAnswerSdp = strings.ReplaceAll(kJanusServerSdp, "janu", "whps")
+
+const kWhipRestartOffer = "a=ice-ufrag:whc2\r\n" +
+	"a=ice-pwd:restartpasswordrestartpa\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"a=mid:0\r\n"
+
+const kWhipRestartAnswer = "a=ice-ufrag:whs2\r\n" +
+	"a=ice-pwd:restartpasswordrestartpb\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"a=mid:0\r\n"
+
+const kWhipTrickle = "a=ice-ufrag:whpc\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"a=mid:0\r\n" +
+	"a=candidate:1 1 udp 2013266431 192.168.1.2 7000 typ host\r\n"
+
+func TestWhipProxy(t *testing.T) {
+	newTestHub(t)
+
+	var mtx sync.Mutex
+	var methods []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mtx.Lock()
+		methods = append(methods, r.Method+" "+r.URL.Path)
+		mtx.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			w.Header().Set("Location", "resource/1")
+			w.Header().Set("ETag", `"e1"`)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(kWhipAnswerSdp))
+		case http.MethodPatch:
+			if r.Header.Get("Content-Type") != kTrickleContentType {
+				t.Errorf("wrong patch content type: %s", r.Header.Get("Content-Type"))
+			}
+			if strings.Contains(string(body), "a=ice-pwd:") {
+				// ice restart
+				w.Header().Set("Content-Type", kTrickleContentType)
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(kWhipRestartAnswer))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer upstream.Close()
+
+	handler := NewHttpServeHandler("test", &HttpParams{ProxyMode: kProxyAlways, WhipUpstream: upstream.URL + "/whip/"})
+	svr := httptest.NewServer(handler)
+	defer svr.Close()
+
+	send := func(method, path, ctype, body string) (*http.Response, string) {
+		req, _ := http.NewRequest(method, svr.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", ctype)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	// create
+	resp, answer := send(http.MethodPost, kApiWhip, kSdpContentType, kWhipOfferSdp)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("wrong status: %d, %s", resp.StatusCode, answer)
+	}
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, kApiWhip+kWhipResourcePath) || resp.Header.Get("ETag") != `"e1"` {
+		t.Errorf("wrong headers: %v", resp.Header)
+	}
+	if !strings.Contains(answer, kTestProxyCandidate) || strings.Contains(answer, "10.0.0.1 8000") {
+		t.Errorf("answer candidates not replaced:\n%s", answer)
+	}
+	if Inst().Cache().Get("whps:whpc") == nil {
+		t.Errorf("whip session not registered")
+	}
+
+	// trickle
+	if resp, _ := send(http.MethodPatch, location, kTrickleContentType, kWhipTrickle); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("wrong trickle status: %d", resp.StatusCode)
+	}
+
+	// ice restart, the old session is deleted
+	resp, frag := send(http.MethodPatch, location, kTrickleContentType, kWhipRestartOffer)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(frag, "a=ice-ufrag:whs2") ||
+		!strings.Contains(frag, kTestProxyCandidate) {
+		t.Errorf("wrong restart: %d\n%s", resp.StatusCode, frag)
+	}
+	if Inst().Cache().Get("whs2:whc2") == nil || Inst().Cache().Get("whps:whpc") != nil {
+		t.Errorf("wrong sessions after ice restart")
+	}
+
+	// delete
+	if resp, _ := send(http.MethodDelete, location, "", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong delete status: %d", resp.StatusCode)
+	}
+	if Inst().Cache().Get("whs2:whc2") != nil {
+		t.Errorf("session not deleted")
+	}
+	if resp, _ := send(http.MethodDelete, location, "", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("resource not deleted: %d", resp.StatusCode)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	want := []string{"POST /whip/", "PATCH /whip/resource/1", "PATCH /whip/resource/1", "DELETE /whip/resource/1"}
+	if strings.Join(methods, ",") != strings.Join(want, ",") {
+		t.Errorf("wrong upstream requests: %v", methods)
+	}
+}
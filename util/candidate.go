@@ -0,0 +1,303 @@
+package util
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// candidate types
+const (
+	CandidateHost  = "host"
+	CandidateSrflx = "srflx"
+	CandidatePrflx = "prflx"
+	CandidateRelay = "relay"
+)
+
+// the known extensions of candidate
+const (
+	kCandTcpType     = "tcptype"
+	kCandGeneration  = "generation"
+	kCandUfrag       = "ufrag"
+	kCandNetworkId   = "network-id"
+	kCandNetworkCost = "network-cost"
+)
+
+var errCandidate = errors.New("invalid candidate")
+
+// CandidateExtension is one "<name> <value>" extension of candidate.
+type CandidateExtension struct {
+	Name  string
+	Value string
+}
+
+// Candidate is the ice candidate attribute(RFC 8839 5.1):
+//
+//	candidate:<foundation> <component-id> <transport> <priority>
+//	  <connection-address> <port> typ <cand-type>
+//	  [raddr <rel-addr>] [rport <rel-port>] *(<extension-name> <extension-value>)
+//
+// e.g.
+//
+//	a=candidate:1 1 udp 2113937151 192.168.1.1 5000 typ host generation 0
+//	a=candidate:2 1 tcp 1518280447 192.168.1.1 443 typ host tcptype passive
+//	a=candidate:3 1 udp 1686052607 1.2.3.4 5000 typ srflx raddr 192.168.1.1 rport 5000
+//	a=candidate:4 1 udp 2113937151 0b6c2a1e-9a2f-4c1b-8d5e-3f7a9c1d2e4b.local 5000 typ host
+//
+// The optional int extensions are -1 if absent.
+type Candidate struct {
+	Foundation  string
+	ComponentId int    // 1-256, e.g., RTP-1, RTCP-2
+	Transport   string // udp/tcp(lowercase)
+	Priority    uint32 // 1-(2^32 - 1)
+	Address     string // connection address: ip or fqdn(e.g. mDNS "<uuid>.local")
+	Port        int
+	Type        string // host/srflx/prflx/relay
+	RelAddr     string // raddr, "" if absent
+	RelPort     int    // rport
+	TcpType     string // active/passive/so, "" for udp
+	Generation  int
+	Ufrag       string
+	NetworkId   int
+	NetworkCost int
+	Extensions  []CandidateExtension // the unknown extensions in order
+}
+
+// NewCandidate returns a candidate without optional fields.
+func NewCandidate(foundation string, component int, transport string, priority uint32,
+	address string, port int, ctype string) *Candidate {
+	return &Candidate{
+		Foundation:  foundation,
+		ComponentId: component,
+		Transport:   strings.ToLower(transport),
+		Priority:    priority,
+		Address:     address,
+		Port:        port,
+		Type:        ctype,
+		RelPort:     -1,
+		Generation:  -1,
+		NetworkId:   -1,
+		NetworkCost: -1,
+	}
+}
+
+// ParseCandidates returns the valid candidates of "a=candidate:" lines.
+func ParseCandidates(lines []string) []Candidate {
+	var cands []Candidate
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "a=candidate:") {
+			continue
+		}
+		if cand := ParseCandidate(line); cand != nil {
+			cands = append(cands, *cand)
+		}
+	}
+	return cands
+}
+
+// ParseCandidate parses "[a=]candidate:...", nil if invalid.
+func ParseCandidate(line string) *Candidate {
+	cand, err := UnmarshalCandidate(line)
+	if err != nil {
+		Warnln("[sdp] invalid sdp candidate:", line, err)
+		return nil
+	}
+	return cand
+}
+
+// UnmarshalCandidate parses "[a=]candidate:..." by the grammar of RFC 8839.
+func UnmarshalCandidate(line string) (*Candidate, error) {
+	line = strings.TrimPrefix(strings.TrimSpace(line), "a=")
+	if !strings.HasPrefix(line, "candidate:") {
+		return nil, errors.New("no candidate prefix")
+	}
+	items := strings.Fields(strings.TrimPrefix(line, "candidate:"))
+	if len(items) < 8 || items[6] != "typ" {
+		return nil, errCandidate
+	}
+
+	if !isIceChars(items[0], 1, 32) {
+		return nil, errors.New("invalid foundation")
+	}
+	component, err := strconv.Atoi(items[1])
+	if err != nil || component < 1 || component > 256 {
+		return nil, errors.New("invalid component-id")
+	}
+	priority, err := strconv.ParseUint(items[3], 10, 32)
+	if err != nil || priority == 0 {
+		return nil, errors.New("invalid priority")
+	}
+	port, err := parseCandidatePort(items[5])
+	if err != nil {
+		return nil, err
+	}
+	if !isCandidateToken(items[2]) || !isCandidateToken(items[7]) || len(items[4]) == 0 {
+		return nil, errCandidate
+	}
+
+	cand := NewCandidate(items[0], component, items[2], uint32(priority), items[4], port, items[7])
+	items = items[8:]
+	if len(items)%2 != 0 {
+		return nil, errors.New("invalid extensions")
+	}
+	for i := 0; i < len(items); i += 2 {
+		name, value := items[i], items[i+1]
+		switch name {
+		case "raddr":
+			cand.RelAddr = value
+		case "rport":
+			if cand.RelPort, err = parseCandidatePort(value); err != nil {
+				return nil, err
+			}
+		case kCandTcpType:
+			cand.TcpType = value
+		case kCandGeneration:
+			cand.Generation, err = parseCandidateInt(value)
+		case kCandUfrag:
+			cand.Ufrag = value
+		case kCandNetworkId:
+			cand.NetworkId, err = parseCandidateInt(value)
+		case kCandNetworkCost:
+			cand.NetworkCost, err = parseCandidateInt(value)
+		default:
+			cand.Extensions = append(cand.Extensions, CandidateExtension{name, value})
+		}
+		if err != nil {
+			return nil, errors.New("invalid " + name)
+		}
+	}
+	return cand, nil
+}
+
+// Marshal returns "candidate:..." without "a=".
+func (c *Candidate) Marshal() string {
+	items := []string{
+		"candidate:" + c.Foundation,
+		strconv.Itoa(c.ComponentId),
+		c.Transport,
+		strconv.FormatUint(uint64(c.Priority), 10),
+		c.Address,
+		strconv.Itoa(c.Port),
+		"typ", c.Type,
+	}
+	if len(c.RelAddr) > 0 {
+		items = append(items, "raddr", c.RelAddr)
+	}
+	if c.RelPort >= 0 {
+		items = append(items, "rport", strconv.Itoa(c.RelPort))
+	}
+	if len(c.TcpType) > 0 {
+		items = append(items, kCandTcpType, c.TcpType)
+	}
+	if c.Generation >= 0 {
+		items = append(items, kCandGeneration, strconv.Itoa(c.Generation))
+	}
+	if len(c.Ufrag) > 0 {
+		items = append(items, kCandUfrag, c.Ufrag)
+	}
+	if c.NetworkId >= 0 {
+		items = append(items, kCandNetworkId, strconv.Itoa(c.NetworkId))
+	}
+	if c.NetworkCost >= 0 {
+		items = append(items, kCandNetworkCost, strconv.Itoa(c.NetworkCost))
+	}
+	for _, ext := range c.Extensions {
+		items = append(items, ext.Name, ext.Value)
+	}
+	return strings.Join(items, " ")
+}
+
+// SdpLine returns "a=candidate:...".
+func (c *Candidate) SdpLine() string {
+	return "a=" + c.Marshal()
+}
+
+func (c *Candidate) String() string {
+	return c.Marshal()
+}
+
+// IsMdns returns true for mDNS hostname(".local"), which can't be resolved by dns.
+func (c *Candidate) IsMdns() bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(c.Address, ".")), ".local")
+}
+
+// IP returns the connection ip, nil for fqdn.
+func (c *Candidate) IP() net.IP {
+	return net.ParseIP(c.Address)
+}
+
+// HostPort returns "address:port"(e.g. "[::1]:5000").
+func (c *Candidate) HostPort() string {
+	return net.JoinHostPort(c.Address, strconv.Itoa(c.Port))
+}
+
+func parseCandidatePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, errors.New("invalid port")
+	}
+	return port, nil
+}
+
+func parseCandidateInt(value string) (int, error) {
+	num, err := strconv.Atoi(value)
+	if err != nil || num < 0 {
+		return 0, errCandidate
+	}
+	return num, nil
+}
+
+// IsIceUfrag checks ice-ufrag, 4-256 ice-chars(RFC 8839 5.4).
+func IsIceUfrag(ufrag string) bool {
+	return isIceChars(ufrag, 4, 256)
+}
+
+// isIceChars checks ice-char(ALPHA / DIGIT / "+" / "/") with length.
+func isIceChars(value string, min, max int) bool {
+	if len(value) < min || len(value) > max {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !isAlphaNum(c) && c != '+' && c != '/' {
+			return false
+		}
+	}
+	return true
+}
+
+// isCandidateToken checks transport/cand-type token.
+func isCandidateToken(value string) bool {
+	if len(value) == 0 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !isAlphaNum(c) && c != '-' && c != '.' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphaNum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// ParseCandidateHost returns the connection address(ip or fqdn) of candidate.
+func ParseCandidateHost(line string) string {
+	if cand := ParseCandidate(line); cand != nil {
+		return cand.Address
+	}
+	return ""
+}
+
+// ParseCandidateIp returns the ip of candidate, "" for unresolved or mDNS hostname.
+func ParseCandidateIp(line string) string {
+	cand := ParseCandidate(line)
+	if cand == nil || cand.IsMdns() {
+		return ""
+	}
+	return LookupIP(cand.Address)
+}
@@ -1,5 +1,7 @@
 package util
 
+import "sync"
+
 type GoPool struct {
 	work chan func()
 	sema chan struct{}
@@ -28,3 +30,53 @@ func (p *GoPool) worker(task func()) {
 		task = <-p.work
 	}
 }
+
+// TaskQueue is a bounded queue drained by fixed workers,
+// and Post never blocks the caller.
+type TaskQueue struct {
+	work chan func()
+	exit chan struct{}
+	once sync.Once
+}
+
+func NewTaskQueue(workers, size int) *TaskQueue {
+	q := &TaskQueue{
+		work: make(chan func(), size),
+		exit: make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// Post returns false if the queue is full or closed, and the task is dropped.
+func (q *TaskQueue) Post(task func()) bool {
+	select {
+	case <-q.exit:
+		return false
+	default:
+	}
+	select {
+	case q.work <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the workers, and the pending tasks are dropped.
+func (q *TaskQueue) Close() {
+	q.once.Do(func() { close(q.exit) })
+}
+
+func (q *TaskQueue) worker() {
+	for {
+		select {
+		case task := <-q.work:
+			task()
+		case <-q.exit:
+			return
+		}
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"hash/crc32"
 	"io"
 	"net"
-	"unsafe"
 )
 
 // StunMessageType 2-bytes
@@ -23,6 +22,22 @@ const (
 	STUN_BINDING_ERROR_RESPONSE                 = 0x0111
 )
 
+// The methods and classes of STUN/TURN(RFC 8656) messages, type = method | class.
+const (
+	STUN_METHOD_BINDING           StunMessageType = 0x0001
+	TURN_METHOD_ALLOCATE                          = 0x0003
+	TURN_METHOD_REFRESH                           = 0x0004
+	TURN_METHOD_SEND                              = 0x0006
+	TURN_METHOD_DATA                              = 0x0007
+	TURN_METHOD_CREATE_PERMISSION                 = 0x0008
+	TURN_METHOD_CHANNEL_BIND                      = 0x0009
+
+	STUN_CLASS_REQUEST    StunMessageType = 0x0000
+	STUN_CLASS_INDICATION                 = 0x0010
+	STUN_CLASS_SUCCESS                    = 0x0100
+	STUN_CLASS_ERROR                      = 0x0110
+)
+
 // StunAttributeType 2-bytes
 type StunAttributeType uint16
 
@@ -41,15 +56,37 @@ const (
 	STUN_ATTR_NONCE                                = 0x0015 // ByteString
 	STUN_ATTR_XOR_MAPPED_ADDRESS                   = 0x0020 // XorAddress
 	STUN_ATTR_SOFTWARE                             = 0x8022 // ByteString
-	STUN_ATTR_ALTERNATE_SERVER                     = 0x8023 // ByteString
+	STUN_ATTR_ALTERNATE_SERVER                     = 0x8023 // Address
 	STUN_ATTR_FINGERPRINT                          = 0x8028 // UInt32
+	STUN_ATTR_RESPONSE_ORIGIN                      = 0x802B // Address, RFC 5780
+	STUN_ATTR_OTHER_ADDRESS                        = 0x802C // Address, RFC 5780
 	STUN_ATTR_RETRANSMIT_COUNT                     = 0xFF00 // UInt32
 
+	// RFC 8489 STUN attributes.
+	STUN_ATTR_MESSAGE_INTEGRITY_SHA256 = 0x001C // ByteString, 16-32 bytes
+	STUN_ATTR_PASSWORD_ALGORITHM       = 0x001D // ByteString
+	STUN_ATTR_USERHASH                 = 0x001E // ByteString, 32 bytes
+	STUN_ATTR_PASSWORD_ALGORITHMS      = 0x8002 // ByteString
+	STUN_ATTR_ALTERNATE_DOMAIN         = 0x8003 // ByteString
+
 	// RFC 5245 ICE STUN attributes.
 	STUN_ATTR_PRIORITY        = 0x0024 // UInt32
 	STUN_ATTR_USE_CANDIDATE   = 0x0025 // No content, Length = 0
+	STUN_ATTR_ICE_CONTROLLED  = 0x8029 // UInt64
 	STUN_ATTR_ICE_CONTROLLING = 0x802A // UInt64
 	STUN_ATTR_NETWORK_INFO    = 0xC057 // UInt32
+
+	// RFC 8656 TURN attributes.
+	TURN_ATTR_CHANNEL_NUMBER           = 0x000C // UInt32
+	TURN_ATTR_LIFETIME                 = 0x000D // UInt32
+	TURN_ATTR_XOR_PEER_ADDRESS         = 0x0012 // XorAddress
+	TURN_ATTR_DATA                     = 0x0013 // ByteString
+	TURN_ATTR_XOR_RELAYED_ADDRESS      = 0x0016 // XorAddress
+	TURN_ATTR_REQUESTED_ADDRESS_FAMILY = 0x0017 // UInt32
+	TURN_ATTR_EVEN_PORT                = 0x0018 // ByteString, 1 byte
+	TURN_ATTR_REQUESTED_TRANSPORT      = 0x0019 // UInt32
+	TURN_ATTR_DONT_FRAGMENT            = 0x001A // No content, Length = 0
+	TURN_ATTR_RESERVATION_TOKEN        = 0x0022 // ByteString, 8 bytes
 )
 
 // StunAttributeValueType 4bytes
@@ -70,6 +107,107 @@ const (
 	STUN_VALUE_UINT16_LIST                        = 7
 )
 
+// max bytes of REALM/NONCE/SOFTWARE and error reason(128 characters).
+const kStunMaxStringLen = 763
+
+// stunAttrSpec is the value type and valid length of one known attribute.
+type stunAttrSpec struct {
+	vtype  StunAttributeValueType
+	minLen uint16
+	maxLen uint16
+}
+
+// All known attributes, others are unknown for decoding.
+var kStunAttrSpecs = map[StunAttributeType]stunAttrSpec{
+	// comprehension-required
+	STUN_ATTR_MAPPED_ADDRESS:           {STUN_VALUE_ADDRESS, 8, 20},
+	STUN_ATTR_USERNAME:                 {STUN_VALUE_BYTE_STRING, 0, 512},
+	STUN_ATTR_MESSAGE_INTEGRITY:        {STUN_VALUE_BYTE_STRING, 20, 20},
+	STUN_ATTR_ERROR_CODE:               {STUN_VALUE_ERROR_CODE, 4, 4 + kStunMaxStringLen},
+	STUN_ATTR_UNKNOWN_ATTRIBUTES:       {STUN_VALUE_UINT16_LIST, 0, 0xFFFF},
+	STUN_ATTR_REALM:                    {STUN_VALUE_BYTE_STRING, 0, kStunMaxStringLen},
+	STUN_ATTR_NONCE:                    {STUN_VALUE_BYTE_STRING, 0, kStunMaxStringLen},
+	STUN_ATTR_MESSAGE_INTEGRITY_SHA256: {STUN_VALUE_BYTE_STRING, 16, 32},
+	STUN_ATTR_PASSWORD_ALGORITHM:       {STUN_VALUE_BYTE_STRING, 4, 0xFFFF},
+	STUN_ATTR_USERHASH:                 {STUN_VALUE_BYTE_STRING, 32, 32},
+	STUN_ATTR_XOR_MAPPED_ADDRESS:       {STUN_VALUE_XOR_ADDRESS, 8, 20},
+	STUN_ATTR_PRIORITY:                 {STUN_VALUE_UINT32, 4, 4},
+	STUN_ATTR_USE_CANDIDATE:            {STUN_VALUE_BYTE_STRING, 0, 0},
+	TURN_ATTR_CHANNEL_NUMBER:           {STUN_VALUE_UINT32, 4, 4},
+	TURN_ATTR_LIFETIME:                 {STUN_VALUE_UINT32, 4, 4},
+	TURN_ATTR_XOR_PEER_ADDRESS:         {STUN_VALUE_XOR_ADDRESS, 8, 20},
+	TURN_ATTR_DATA:                     {STUN_VALUE_BYTE_STRING, 0, 0xFFFF},
+	TURN_ATTR_XOR_RELAYED_ADDRESS:      {STUN_VALUE_XOR_ADDRESS, 8, 20},
+	TURN_ATTR_REQUESTED_ADDRESS_FAMILY: {STUN_VALUE_UINT32, 4, 4},
+	TURN_ATTR_EVEN_PORT:                {STUN_VALUE_BYTE_STRING, 1, 1},
+	TURN_ATTR_REQUESTED_TRANSPORT:      {STUN_VALUE_UINT32, 4, 4},
+	TURN_ATTR_DONT_FRAGMENT:            {STUN_VALUE_BYTE_STRING, 0, 0},
+	TURN_ATTR_RESERVATION_TOKEN:        {STUN_VALUE_BYTE_STRING, 8, 8},
+
+	// comprehension-optional
+	STUN_ATTR_PASSWORD_ALGORITHMS: {STUN_VALUE_BYTE_STRING, 0, 0xFFFF},
+	STUN_ATTR_ALTERNATE_DOMAIN:    {STUN_VALUE_BYTE_STRING, 0, 255},
+	STUN_ATTR_SOFTWARE:            {STUN_VALUE_BYTE_STRING, 0, kStunMaxStringLen},
+	STUN_ATTR_ALTERNATE_SERVER:    {STUN_VALUE_ADDRESS, 8, 20},
+	STUN_ATTR_FINGERPRINT:         {STUN_VALUE_UINT32, 4, 4},
+	STUN_ATTR_ICE_CONTROLLED:      {STUN_VALUE_UINT64, 8, 8},
+	STUN_ATTR_ICE_CONTROLLING:     {STUN_VALUE_UINT64, 8, 8},
+	STUN_ATTR_RESPONSE_ORIGIN:     {STUN_VALUE_ADDRESS, 8, 20},
+	STUN_ATTR_OTHER_ADDRESS:       {STUN_VALUE_ADDRESS, 8, 20},
+	STUN_ATTR_NETWORK_INFO:        {STUN_VALUE_UINT32, 4, 4},
+	STUN_ATTR_RETRANSMIT_COUNT:    {STUN_VALUE_UINT32, 4, 4},
+}
+
+// GetStunAttributeValueType returns the value type of attribute, STUN_VALUE_UNKNOWN if unknown.
+func GetStunAttributeValueType(atype StunAttributeType) StunAttributeValueType {
+	if spec, ok := kStunAttrSpecs[atype]; ok {
+		return spec.vtype
+	}
+	return STUN_VALUE_UNKNOWN
+}
+
+// IsStunComprehensionRequired returns true for attribute type in 0x0000-0x7FFF.
+func IsStunComprehensionRequired(atype StunAttributeType) bool {
+	return atype < 0x8000
+}
+
+// newStunAttribute creates an empty attribute of value type for reading.
+func newStunAttribute(vtype StunAttributeValueType) StunAttribute {
+	switch vtype {
+	case STUN_VALUE_ADDRESS:
+		return &StunAddressAttribute{}
+	case STUN_VALUE_XOR_ADDRESS:
+		return &StunXorAddressAttribute{}
+	case STUN_VALUE_UINT32:
+		return &StunUInt32Attribute{}
+	case STUN_VALUE_UINT64:
+		return &StunUInt64Attribute{}
+	case STUN_VALUE_BYTE_STRING:
+		return &StunByteStringAttribute{}
+	case STUN_VALUE_ERROR_CODE:
+		return &StunErrorCodeAttribute{}
+	case STUN_VALUE_UINT16_LIST:
+		return &StunUInt16ListAttribute{}
+	}
+	return nil
+}
+
+// StunError is the failure of decoding one stun message.
+// The Code is 400(malformed) or 420(unknown comprehension-required attributes) for error response,
+// or 0 if the message should be discarded silently(e.g. not stun or wrong fingerprint).
+type StunError struct {
+	Code    int
+	Reason  string
+	Unknown []StunAttributeType // for 420
+}
+
+func (e *StunError) Error() string {
+	if e.Code == 0 {
+		return e.Reason
+	}
+	return fmt.Sprintf("%d %s", e.Code, e.Reason)
+}
+
 // StunAddressFamily 1byte
 type StunAddressFamily uint8
 
@@ -141,118 +279,116 @@ type IceMessage struct {
 // Read Parses the STUN packet in the given buffer and records it here. The
 // return value indicates whether this was successful.
 func (m *StunMessage) Read(data []byte) bool {
-	if len(data) < kStunHeaderSize {
-		Warnln("[ice] invalid stun size=", len(data))
-		return false
-	}
-
-	// check the 1st byte
-	utype := data[0]
-	if utype != 0 && utype != 1 {
-		Warnln("[ice] invalid utype=", utype)
+	if err := m.Decode(data); err != nil {
+		Warnln("[ice] invalid stun message:", err)
 		return false
 	}
+	return true
+}
 
-	// create Reader
-	buf := bytes.NewReader(data)
+// Decode parses the STUN packet strictly(RFC 5389/8489):
+//
+//   - the header and length of each attribute must be valid, or 400.
+//   - the unknown comprehension-required attributes are collected for 420,
+//     and the unknown comprehension-optional ones are ignored.
+//   - the attributes after MESSAGE-INTEGRITY are ignored, except FINGERPRINT(must be the last).
+//   - the wrong FINGERPRINT is discarded(code 0).
+//
+// The header(type and transaction id) and attributes are still recorded for error response.
+func (m *StunMessage) Decode(data []byte) *StunError {
+	m.Attrs = make(map[StunAttributeType]StunAttribute)
+	m.OrderAttrs = nil
 
-	// 0-2, read stun message type
-	ReadBig(buf, &m.Dtype)
-	//Println("[ice] message type=", m.Dtype)
-	if (m.Dtype & 0x8000) != 0 {
-		// RTP and RTCP set the MSB of first byte, since first two bits are version,
-		// and version is always 2 (10). If set, this is not a STUN packet.
-		Warnln("[ice] not stun message, (RTP/RTCP)type=", m.Dtype)
-		return false
-	}
-
-	// 2-4, read stun message size
-	ReadBig(buf, &m.Length)
-	//Println("[ice] message length=", m.Length)
-	if (m.Length & 0x0003) != 0 {
-		Warnln("[ice] invalid message length=", m.Length)
-		return false
+	if len(data) < kStunHeaderSize {
+		return &StunError{Reason: fmt.Sprintf("invalid stun size %d", len(data))}
 	}
 
-	// 4-8, read stun magic
-	ReadBig(buf, &m.Magic)
-	//Println("[ice] read magic:", m.Magic, kStunMagicCookie)
-
-	// 8-20, read stun transaction id
-	var transId [kStunTransactionIdLength]byte
-	if ret, err := buf.Read(transId[:]); err != nil || ret != kStunTransactionIdLength {
-		Warnln("[ice] invalid transid ret=", ret, ", err=", err)
-		return false
+	// RTP and RTCP set the MSB of first byte, since first two bits are version,
+	// and version is always 2 (10). If set, this is not a STUN packet.
+	if (data[0] & 0xC0) != 0 {
+		return &StunError{Reason: fmt.Sprintf("not stun message, type=%#x", data[0])}
 	}
 
+	// 0-2 type, 2-4 body length, 4-8 magic, 8-20 transaction id
+	m.Dtype = StunMessageType(binary.BigEndian.Uint16(data[0:]))
+	m.Length = binary.BigEndian.Uint16(data[2:])
+	m.Magic = binary.BigEndian.Uint32(data[4:])
 	if m.Magic != kStunMagicCookie {
 		// If magic cookie is invalid it means that the peer implements
 		// RFC3489 instead of RFC5389.
-		m.TransId = string(ValueToBytes(m.Magic)[:]) + string(transId[:])
+		m.TransId = string(data[4:kStunHeaderSize])
 	} else {
-		m.TransId = string(transId[:])
+		m.TransId = string(data[kStunTransactionIdOffset:kStunHeaderSize])
 	}
-	// kStunTransactionIdLength/kStunLegacyTransactionIdLength
-	//Printf("[ice] message magic=%x, transId=%s_%d\n", m.Magic, m.TransId, len(m.TransId))
 
-	if int(m.Length) != buf.Len() {
-		// TODO: length= 80 , Len= 696
-		// invalid length= 108 , Len= 31
-		Warnln("[ice] invalid length=", m.Length, ", Len=", buf.Len(), len(data), m.TransId)
-		return false
+	if (m.Length&0x0003) != 0 || int(m.Length) != len(data)-kStunHeaderSize {
+		return &StunError{Reason: fmt.Sprintf("invalid message length=%d, size=%d", m.Length, len(data))}
 	}
 
-	if m.Attrs == nil {
-		m.Attrs = make(map[StunAttributeType]StunAttribute)
-	}
+	var unknown []StunAttributeType
+	integrity := false
+	pos := kStunHeaderSize
+	for pos < len(data) {
+		if pos+kStunAttributeHeaderSize > len(data) {
+			return &StunError{Code: 400, Reason: "truncated attribute header"}
+		}
+		attrType := StunAttributeType(binary.BigEndian.Uint16(data[pos:]))
+		attrLen := binary.BigEndian.Uint16(data[pos+2:])
+		begin := pos + kStunAttributeHeaderSize
+		end := begin + int(attrLen)
+		next := end + (4-int(attrLen)%4)%4
+		if next > len(data) {
+			return &StunError{Code: 400, Reason: fmt.Sprintf("truncated attribute %#04x, length=%d", attrType, attrLen)}
+		}
+		if m.GetAttribute(STUN_ATTR_FINGERPRINT) != nil {
+			return &StunError{Code: 400, Reason: "attribute after FINGERPRINT"}
+		}
+		fpos := pos
+		pos = next
 
-	for {
-		if buf.Len() < 4 {
-			//Warnln("[ice] no more data len=", buf.Len())
-			break
+		if integrity && attrType != STUN_ATTR_FINGERPRINT && attrType != STUN_ATTR_MESSAGE_INTEGRITY_SHA256 {
+			continue
 		}
 
-		var attrType StunAttributeType
-		var attrLen uint16
-		ReadBig(buf, &attrType)
-		ReadBig(buf, &attrLen)
-		//Println("[ice] attrType, attrLen=", attrType, attrLen)
+		spec, ok := kStunAttrSpecs[attrType]
+		if !ok {
+			if IsStunComprehensionRequired(attrType) {
+				unknown = append(unknown, attrType)
+			}
+			continue
+		}
+		if attrLen < spec.minLen || attrLen > spec.maxLen {
+			return &StunError{Code: 400, Reason: fmt.Sprintf("invalid length %d of attribute %#04x", attrLen, attrType)}
+		}
+
+		attr := newStunAttribute(spec.vtype)
+		attr.SetInfo(attrType, attrLen, m.TransId)
+		buf := bytes.NewReader(data[begin:end])
+		if !attr.Read(buf) || buf.Len() != 0 {
+			return &StunError{Code: 400, Reason: fmt.Sprintf("invalid value of attribute %#04x", attrType)}
+		}
 
-		var attr StunAttribute
 		switch attrType {
-		case STUN_ATTR_MAPPED_ADDRESS:
-			attr = &StunAddressAttribute{}
-		case STUN_ATTR_XOR_MAPPED_ADDRESS:
-			attr = &StunXorAddressAttribute{}
-		case STUN_ATTR_USERNAME:
-			attr = &StunByteStringAttribute{}
-		case STUN_ATTR_ERROR_CODE:
-			attr = &StunErrorCodeAttribute{}
-		//case STUN_ATTR_MESSAGE_INTEGRITY:
-		//case STUN_ATTR_FINGERPRINT:
-		//case STUN_ATTR_PRIORITY:
-		//case STUN_ATTR_USE_CANDIDATE:
-		//case STUN_ATTR_ICE_CONTROLLING:
-		//case STUN_ATTR_NETWORK_INFO:
-		default:
-			newLen := attrLen
-			if remainder := attrLen % 4; remainder > 0 {
-				padding := 4 - remainder
-				newLen += padding
+		case STUN_ATTR_MESSAGE_INTEGRITY:
+			integrity = true
+		case STUN_ATTR_FINGERPRINT:
+			crc := crc32.ChecksumIEEE(data[0:fpos]) ^ STUN_FINGERPRINT_XOR_VALUE
+			if crc != attr.(*StunUInt32Attribute).Value() {
+				return &StunError{Reason: "wrong fingerprint"}
 			}
-			buf.Seek(int64(newLen), io.SeekCurrent)
 		}
 
-		// save attr
-		if attr != nil {
-			attr.SetInfo(attrType, attrLen, m.TransId)
-			attr.Read(buf)
+		// the first one is used for duplicated attributes
+		if _, ok := m.Attrs[attrType]; !ok {
 			m.Attrs[attrType] = attr
-			m.OrderAttrs = append(m.OrderAttrs, attr)
 		}
+		m.OrderAttrs = append(m.OrderAttrs, attr)
 	}
 
-	return true
+	if len(unknown) > 0 {
+		return &StunError{Code: 420, Reason: "Unknown Attribute", Unknown: unknown}
+	}
+	return nil
 }
 
 // Write writes this object into a STUN packet. The return value indicates whether
@@ -301,6 +437,16 @@ func (m *StunMessage) IsLegacy() bool {
 	return false
 }
 
+// Method returns the method bits of message type.
+func (m *StunMessage) Method() StunMessageType {
+	return m.Dtype & 0x3EEF
+}
+
+// Class returns the class bits(request/indication/success/error) of message type.
+func (m *StunMessage) Class() StunMessageType {
+	return m.Dtype & 0x0110
+}
+
 func (m *StunMessage) SetType(dtype StunMessageType) {
 	m.Dtype = dtype
 }
@@ -488,15 +634,28 @@ type StunAddressAttribute struct {
 	ip     net.IP
 }
 
+// NewStunAddressAttribute creates an attribute for writing.
+func NewStunAddressAttribute(attrType StunAttributeType, addr net.Addr) *StunAddressAttribute {
+	attr := &StunAddressAttribute{}
+	attr.SetType(attrType)
+	attr.SetAddr(addr)
+	return attr
+}
+
+// UDPAddr returns the address after reading.
+func (a *StunAddressAttribute) UDPAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: a.ip, Port: int(a.port)}
+}
+
 func (a *StunAddressAttribute) String() string {
-	return a.ip.String() + ":" + fmt.Sprint(a.port)
+	return net.JoinHostPort(a.ip.String(), fmt.Sprint(a.port))
 }
 
 func (a *StunAddressAttribute) GetLen2() uint16 {
 	if a.family == STUN_ADDRESS_IPV4 {
-		return 1 + 1 + 2 + 4
+		return 1 + 1 + 2 + net.IPv4len
 	} else {
-		return 1 + 1 + 2 + 20
+		return 1 + 1 + 2 + net.IPv6len
 	}
 }
 
@@ -521,18 +680,24 @@ func (a *StunAddressAttribute) Read(buf *bytes.Reader) bool {
 	}
 
 	// read ip
-	if a.family == STUN_ADDRESS_IPV4 {
-		a.ip = make([]byte, 4)
-		if ReadBig(buf, a.ip) != nil {
-			Warnln("[ice] read ipv4 failed")
-			return false
-		}
-	} else if a.family == STUN_ADDRESS_IPV6 {
-		a.ip = make([]byte, 20)
-		if ReadBig(buf, a.ip) != nil {
-			Warnln("[ice] read ipv6 failed")
-			return false
-		}
+	var ipLen int
+	switch a.family {
+	case STUN_ADDRESS_IPV4:
+		ipLen = net.IPv4len
+	case STUN_ADDRESS_IPV6:
+		ipLen = net.IPv6len
+	default:
+		Warnln("[ice] invalid address family:", a.family)
+		return false
+	}
+	if a.attrLen != 0 && int(a.attrLen) != 4+ipLen {
+		Warnln("[ice] invalid address length:", a.attrLen)
+		return false
+	}
+	a.ip = make([]byte, ipLen)
+	if ReadBig(buf, a.ip) != nil {
+		Warnln("[ice] read ip failed")
+		return false
 	}
 
 	return true
@@ -563,11 +728,15 @@ func (a *StunAddressAttribute) SetAddr(addr net.Addr) {
 }
 
 func (a *StunAddressAttribute) SetIP(ip net.IP) {
-	a.ip = ip
-	if ip.To4() != nil {
+	if ip4 := ip.To4(); ip4 != nil {
+		a.ip = ip4
 		a.family = STUN_ADDRESS_IPV4
-	} else {
+	} else if ip6 := ip.To16(); ip6 != nil {
+		a.ip = ip6
 		a.family = STUN_ADDRESS_IPV6
+	} else {
+		a.ip = nil
+		a.family = STUN_ADDRESS_UNDEF
 	}
 }
 
@@ -585,15 +754,25 @@ type StunXorAddressAttribute struct {
 	XorPort uint16
 }
 
+// NewStunXorAddressAttribute creates an attribute for writing, transId is required by ipv6.
+func NewStunXorAddressAttribute(attrType StunAttributeType, addr net.Addr, transId string) *StunXorAddressAttribute {
+	attr := &StunXorAddressAttribute{}
+	attr.SetInfo(attrType, 0, transId)
+	attr.Addr.SetAddr(addr)
+	return attr
+}
+
+// UDPAddr returns the decoded address after reading.
+func (a *StunXorAddressAttribute) UDPAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IP(a.XorIP), Port: int(a.XorPort)}
+}
+
 func (a *StunXorAddressAttribute) GetLen2() uint16 {
-	if a.Addr.family == STUN_ADDRESS_IPV4 {
-		return 1 + 1 + 2 + 4
-	} else {
-		return 1 + 1 + 2 + 20
-	}
+	return a.Addr.GetLen2()
 }
 
 func (a *StunXorAddressAttribute) Read(buf *bytes.Reader) bool {
+	a.Addr.SetInfo(a.attrType, a.attrLen, a.transId)
 	if !a.Addr.Read(buf) {
 		return false
 	}
@@ -618,31 +797,27 @@ func (a *StunXorAddressAttribute) Write(buf *bytes.Buffer) bool {
 	a.GetXoredIP()
 	// 2bytes
 	WriteBig(buf, a.XorPort)
-	// 4bytes
+	// 4bytes(ipv4) or 16bytes(ipv6)
 	WriteBig(buf, a.XorIP)
 	return true
 }
 
+// GetXoredIP xors ip with magic cookie(ipv4), or magic cookie and transaction id(ipv6).
+// It is the same for encoding and decoding.
 func (a *StunXorAddressAttribute) GetXoredIP() {
-	magic := HostToNet32(kStunMagicCookie)
-	if a.Addr.family == STUN_ADDRESS_IPV4 {
-		ip32 := HostToNet32(BytesToUint32(a.Addr.ip.To4()))
-		xorip := Uint32ToBytes(ip32 ^ magic)
-		a.XorIP = xorip
-	} else if a.Addr.family == STUN_ADDRESS_IPV6 {
-		//TODO
-		a.XorIP = make([]byte, 20)
-		if len(a.Addr.transId) == kStunTransactionIdLength {
-			var transIds [3]uint32
-			copy((*[12]byte)(unsafe.Pointer(&transIds[0]))[:], a.transId)
-
-			dst := (*[4]uint32)(unsafe.Pointer(&a.XorIP[0]))[:]
-			ipv6 := (*[4]uint32)(unsafe.Pointer(&a.Addr.ip[0]))[:]
-			dst[0] = ipv6[0] ^ magic
-			for i := 1; i < 4; i++ {
-				dst[i] = ipv6[i] ^ transIds[i-1]
-			}
-		}
+	var mask [net.IPv6len]byte
+	binary.BigEndian.PutUint32(mask[0:4], kStunMagicCookie)
+	copy(mask[4:], a.transId)
+
+	var ip net.IP
+	if a.Addr.family == STUN_ADDRESS_IPV4 && len(a.Addr.ip) >= net.IPv4len {
+		ip = a.Addr.ip[len(a.Addr.ip)-net.IPv4len:]
+	} else if a.Addr.family == STUN_ADDRESS_IPV6 && len(a.Addr.ip) == net.IPv6len {
+		ip = a.Addr.ip
+	}
+	a.XorIP = make([]byte, len(ip))
+	for i := range ip {
+		a.XorIP[i] = ip[i] ^ mask[i]
 	}
 }
 
@@ -672,7 +847,7 @@ func (a *StunByteStringAttribute) Read(buf *bytes.Reader) bool {
 	}
 
 	a.Data = make([]byte, a.attrLen)
-	if _, err := buf.Read(a.Data); err != nil {
+	if _, err := io.ReadFull(buf, a.Data); err != nil {
 		a.Data = nil
 		Warnln("[ice] fail to read for StunByteStringAttribute")
 		return false
@@ -697,6 +872,12 @@ type StunUInt32Attribute struct {
 	bits uint32
 }
 
+func NewStunUInt32Attribute(attrType StunAttributeType, value uint32) *StunUInt32Attribute {
+	attr := &StunUInt32Attribute{bits: value}
+	attr.SetType(attrType)
+	return attr
+}
+
 func (a *StunUInt32Attribute) GetLen2() uint16 {
 	return 4
 }
@@ -705,6 +886,10 @@ func (a *StunUInt32Attribute) SetValue(value uint32) {
 	a.bits = value
 }
 
+func (a *StunUInt32Attribute) Value() uint32 {
+	return a.bits
+}
+
 func (a *StunUInt32Attribute) GetBit(index int) bool {
 	return ((a.bits >> uint32(index)) & 0x1) == 0x01
 }
@@ -718,10 +903,12 @@ func (a *StunUInt32Attribute) SetBit(index int, value bool) {
 
 func (a *StunUInt32Attribute) Read(buf *bytes.Reader) bool {
 	if a.GetLen() != 4 {
+		// skip the wrong attribute
+		buf.Seek(int64(a.GetLen()), io.SeekCurrent)
+		a.ConsumePadding(buf, int(a.GetLen()))
 		return false
 	}
-	ReadBig(buf, a.bits)
-	return true
+	return ReadBig(buf, &a.bits) == nil
 }
 
 func (a *StunUInt32Attribute) Write(buf *bytes.Buffer) bool {
@@ -729,6 +916,76 @@ func (a *StunUInt32Attribute) Write(buf *bytes.Buffer) bool {
 	return true
 }
 
+// StunUInt64Attribute implements STUN attributes that record a 64-bit integer.
+type StunUInt64Attribute struct {
+	StunAttributeBase
+	bits uint64
+}
+
+func NewStunUInt64Attribute(attrType StunAttributeType, value uint64) *StunUInt64Attribute {
+	attr := &StunUInt64Attribute{bits: value}
+	attr.SetType(attrType)
+	return attr
+}
+
+func (a *StunUInt64Attribute) GetLen2() uint16 {
+	return 8
+}
+
+func (a *StunUInt64Attribute) SetValue(value uint64) {
+	a.bits = value
+}
+
+func (a *StunUInt64Attribute) Value() uint64 {
+	return a.bits
+}
+
+func (a *StunUInt64Attribute) Read(buf *bytes.Reader) bool {
+	if a.GetLen() != 8 {
+		return false
+	}
+	return ReadBig(buf, &a.bits) == nil
+}
+
+func (a *StunUInt64Attribute) Write(buf *bytes.Buffer) bool {
+	WriteBig(buf, a.bits)
+	return true
+}
+
+// StunUInt16ListAttribute implements STUN attributes that record a list of attribute names.
+type StunUInt16ListAttribute struct {
+	StunAttributeBase
+	Values []uint16
+}
+
+func NewStunUInt16ListAttribute(attrType StunAttributeType, values []uint16) *StunUInt16ListAttribute {
+	attr := &StunUInt16ListAttribute{Values: values}
+	attr.SetType(attrType)
+	return attr
+}
+
+func (a *StunUInt16ListAttribute) GetLen2() uint16 {
+	return uint16(2 * len(a.Values))
+}
+
+func (a *StunUInt16ListAttribute) Read(buf *bytes.Reader) bool {
+	if (a.attrLen%2) != 0 || !a.Check(buf) {
+		return false
+	}
+	a.Values = make([]uint16, a.attrLen/2)
+	if ReadBig(buf, a.Values) != nil {
+		return false
+	}
+	a.ConsumePadding(buf, int(a.attrLen))
+	return true
+}
+
+func (a *StunUInt16ListAttribute) Write(buf *bytes.Buffer) bool {
+	WriteBig(buf, a.Values)
+	a.WritePadding(buf, 2*len(a.Values))
+	return true
+}
+
 // Implements STUN attributes that record an error code.
 // MIN_SIZE = 4
 type StunErrorCodeAttribute struct {
@@ -738,12 +995,24 @@ type StunErrorCodeAttribute struct {
 	Reason string
 }
 
+func NewStunErrorCodeAttribute(code int, reason string) *StunErrorCodeAttribute {
+	attr := &StunErrorCodeAttribute{}
+	attr.SetType(STUN_ATTR_ERROR_CODE)
+	attr.SetCode(code)
+	attr.SetReason(reason)
+	return attr
+}
+
+func (a *StunErrorCodeAttribute) GetLen2() uint16 {
+	return uint16(4 + len(a.Reason))
+}
+
 func (a *StunErrorCodeAttribute) Read(buf *bytes.Reader) bool {
-	if buf.Len() < 4 {
+	if a.attrLen < 4 || !a.Check(buf) {
 		return false
 	}
 
-	reasonLen := buf.Len() - 4
+	reasonLen := int(a.attrLen) - 4
 
 	var val uint32
 	if ReadBig(buf, &val) != nil {
@@ -765,16 +1034,23 @@ func (a *StunErrorCodeAttribute) Read(buf *bytes.Reader) bool {
 		}
 		a.Reason = string(data)
 	}
+	a.ConsumePadding(buf, int(a.attrLen))
 	//Println("[ice] read error-code:", a)
 	return true
 }
 
 func (a *StunErrorCodeAttribute) Write(buf *bytes.Buffer) bool {
-	return false
+	var zero uint16 = 0
+	WriteBig(buf, zero)
+	WriteBig(buf, a.Class)
+	WriteBig(buf, a.Number)
+	buf.WriteString(a.Reason)
+	a.WritePadding(buf, len(a.Reason))
+	return true
 }
 
 func (a *StunErrorCodeAttribute) Code() int {
-	return int(a.Class*100) + int(a.Number)
+	return int(a.Class)*100 + int(a.Number)
 }
 
 func (a *StunErrorCodeAttribute) SetCode(code int) {
@@ -786,13 +1062,17 @@ func (a *StunErrorCodeAttribute) SetReason(reason string) {
 	a.Reason = reason
 }
 
-// GenStunMessageRequest generates stun request packet
-func GenStunMessageRequest(buf *bytes.Buffer, sendUfrag, recvUfrag, recvPwd string) bool {
+// GenStunMessageRequest generates stun request packet,
+// attrs are the extra ice attributes(e.g. PRIORITY/ICE-CONTROLLING).
+func GenStunMessageRequest(buf *bytes.Buffer, sendUfrag, recvUfrag, recvPwd string, attrs ...StunAttribute) bool {
 	sendKey := recvUfrag + ":" + sendUfrag
 	usernameAttr := NewStunByteStringAttribute(STUN_ATTR_USERNAME, []byte(sendKey))
 
 	req := NewStunMessageRequest()
 	req.AddAttribute(usernameAttr)
+	for _, attr := range attrs {
+		req.AddAttribute(attr)
+	}
 	req.AddMessageIntegrity(recvPwd)
 	req.AddFingerprint()
 	return req.Write(buf)
@@ -801,7 +1081,7 @@ func GenStunMessageRequest(buf *bytes.Buffer, sendUfrag, recvUfrag, recvPwd stri
 // GenStunMessageResponse generates stun response packet
 func GenStunMessageResponse(buf *bytes.Buffer, passwd string, transId string, addr net.Addr) bool {
 	xorAttr := &StunXorAddressAttribute{}
-	xorAttr.SetType(STUN_ATTR_XOR_MAPPED_ADDRESS)
+	xorAttr.SetInfo(STUN_ATTR_XOR_MAPPED_ADDRESS, 0, transId) // transId for ipv6
 	xorAttr.Addr.SetAddr(addr)
 
 	resp := NewStunMessageResponse(transId)
@@ -811,6 +1091,28 @@ func GenStunMessageResponse(buf *bytes.Buffer, passwd string, transId string, ad
 	return resp.Write(buf)
 }
 
+// NewStunErrorResponse creates the error response(400/420) for one request failed in Decode.
+func NewStunErrorResponse(req *StunMessage, err *StunError) *StunMessage {
+	resp := &StunMessage{Dtype: req.Method() | STUN_CLASS_ERROR, TransId: req.TransId}
+	resp.AddAttribute(NewStunErrorCodeAttribute(err.Code, err.Reason))
+	if len(err.Unknown) > 0 {
+		values := make([]uint16, len(err.Unknown))
+		for i, atype := range err.Unknown {
+			values[i] = uint16(atype)
+		}
+		resp.AddAttribute(NewStunUInt16ListAttribute(STUN_ATTR_UNKNOWN_ATTRIBUTES, values))
+	}
+	return resp
+}
+
+// GenStunErrorResponse generates stun error response packet(400/420) with integrity
+func GenStunErrorResponse(buf *bytes.Buffer, passwd string, req *StunMessage, err *StunError) bool {
+	resp := NewStunErrorResponse(req, err)
+	resp.AddMessageIntegrity(passwd)
+	resp.AddFingerprint()
+	return resp.Write(buf)
+}
+
 // The packet length of dtls/rtp/rtcp
 const (
 	kDtlsRecordHeaderLen int = 13
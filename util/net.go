@@ -99,17 +99,47 @@ func SetSocketReuseAddr(sock SocketFD) {
 	}
 }
 
-// tries to determine a non-loopback address for local machine
-func LocalIP() (net.IP, error) {
+// LocalIPs returns all global unicast addresses(ipv4/ipv6) of local machine.
+func LocalIPs() ([]net.IP, error) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return nil, err
 	}
+	var ips []net.IP
 	for _, addr := range addrs {
 		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
-			if ipnet.IP.To4() != nil || ipnet.IP.To16() != nil {
-				return ipnet.IP, nil
-			}
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// tries to determine a non-loopback address for local machine, ipv4 preferred.
+func LocalIP() (net.IP, error) {
+	ips, err := LocalIPs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip, nil
+		}
+	}
+	if len(ips) > 0 {
+		return ips[0], nil
+	}
+	return nil, nil
+}
+
+// tries to determine a global ipv6 address for local machine.
+func LocalIPv6() (net.IP, error) {
+	ips, err := LocalIPs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			return ip, nil
 		}
 	}
 	return nil, nil
@@ -117,7 +147,15 @@ func LocalIP() (net.IP, error) {
 
 // return a non-loopback address string for local machine.
 func LocalIPString() string {
-	ip, err := LocalIP()
+	return localIPString(LocalIP())
+}
+
+// return a global ipv6 address string for local machine.
+func LocalIPv6String() string {
+	return localIPString(LocalIPv6())
+}
+
+func localIPString(ip net.IP, err error) string {
 	if err != nil {
 		Warnln(uTAG, "Error determining local ip address. ", err)
 		return ""
@@ -130,28 +168,39 @@ func LocalIPString() string {
 }
 
 // looks up host using the local resolver.
-// It returns a host's IPv4 address (non-loopback).
+// It returns the ip itself for ip host("[ipv6]" allowed),
+// or a host's IPv4 address (non-loopback) preferred to IPv6.
 func LookupIP(host string) string {
-	hostIp := host
+	host = strings.Trim(host, "[]")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
 	if ips, err := net.LookupIP(host); err == nil {
+		var ipv6 string
 		for _, ip := range ips {
-			if ip.IsGlobalUnicast() && (ip.To4() != nil) {
-				hostIp = ip.String()
-				break
+			if !ip.IsGlobalUnicast() {
+				continue
 			}
+			if ip.To4() != nil {
+				return ip.String()
+			}
+			if len(ipv6) == 0 {
+				ipv6 = ip.String()
+			}
+		}
+		if len(ipv6) > 0 {
+			return ipv6
 		}
 	}
-	return hostIp
+	return host
 }
 
+// ParseHostPort parses "host:port", "[ipv6]:port", or host without port.
 func ParseHostPort(addr string) (string, int) {
-	parts := strings.Split(addr, ":")
-	if len(parts) == 1 {
-		return parts[0], 0
-	} else if len(parts) == 2 {
-		return parts[0], Atoi(parts[1])
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		return host, Atoi(port)
 	}
-	return "", 0
+	return strings.Trim(addr, "[]"), 0
 }
 
 func ParseHostIp(addr string) string {
@@ -136,6 +136,31 @@ type FidInfo struct {
 	rtx  uint32
 }
 
+// SDP ssrc group: a=ssrc-group
+// a=ssrc-group:FID 1081040086 1081040087
+// a=ssrc-group:FEC-FR 1081040086 1081040088
+// a=ssrc-group:SIM 1081040086 1081040090 1081040094
+type SsrcGroup struct {
+	Semantics string // FID/FEC-FR/SIM/..
+	Ssrcs     []uint32
+}
+
+// SDP restriction identifier: a=rid(RFC 8851)
+// a=rid:h send pt=96;max-width=1280
+type RidInfo struct {
+	Id        string
+	Direction string // send/recv
+	Params    string // restrictions, e.g. "pt=96;max-width=1280"
+}
+
+// SDP simulcast: a=simulcast(RFC 8853)
+// a=simulcast:send h;~m;l recv 1,2
+// Each layer is a list of alternative rids, and "~" prefix for paused.
+type SimulcastInfo struct {
+	Send [][]string
+	Recv [][]string
+}
+
 // SDP sctp: a=sctpmap
 // a=sctpmap:5000 webrtc-datachannel 1024
 type SctpInfo struct {
@@ -147,6 +172,7 @@ type SctpInfo struct {
 
 func NewMediaAttr(mtype, proto string) *MediaAttr {
 	return &MediaAttr{mtype: mtype, proto: proto,
+		direction:  kDirectionSendRecv, // default(RFC 4566)
 		fmtps:      make(map[int]*FmtpInfo),
 		av_rtpmaps: make(map[string]*RtpMapInfo)}
 }
@@ -154,6 +180,7 @@ func NewMediaAttr(mtype, proto string) *MediaAttr {
 // SDP media attribute lines
 type MediaAttr struct {
 	mtype            string            // m=
+	port             int               // m=, 0 for rejected
 	proto            string            // m=
 	ptypes           []string          // m=
 	ice_ufrag        string            // a=ice-ufrag:..
@@ -171,7 +198,11 @@ type MediaAttr struct {
 	rtcp_fbs         []*RtcpFbInfo     // a=rtcp-fb:..
 	extmaps          []*ExtMapInfo     // a=extmap:..
 	fid_ssrcs        []*FidInfo        // a=ssrc-group:FID ..
+	ssrc_groups      []*SsrcGroup      // a=ssrc-group:.. (FID/FEC-FR/SIM)
 	ssrcs            []*SsrcInfo       // a=ssrc:..
+	rids             []*RidInfo        // a=rid:..
+	simulcast        *SimulcastInfo    // a=simulcast:..
+	extmap_mixed     bool              // a=extmap-allow-mixed
 	msids            []string          // a=msid:..
 	sctp             *SctpInfo         // a=sctpmap: or a=sctp-port:
 	max_message_size int               // a=max-message-size:
@@ -188,7 +219,11 @@ type MediaAttr struct {
 	use_fid      bool
 }
 
+// GetSsrcs returns the main/rtx of the first layer(SIM), or the first FID/ssrc.
 func (a *MediaAttr) GetSsrcs() *SSRC {
+	if ssrcs := a.GetSimulcastSsrcs(); len(ssrcs) > 0 {
+		return ssrcs[0]
+	}
 	ssrc := &SSRC{}
 	if len(a.fid_ssrcs) > 0 {
 		ssrc.main = a.fid_ssrcs[0].main
@@ -201,15 +236,49 @@ func (a *MediaAttr) GetSsrcs() *SSRC {
 	return ssrc
 }
 
+// GetSimulcastSsrcs returns main/rtx of each layer in a=ssrc-group:SIM, the rtx is from FID.
+func (a *MediaAttr) GetSimulcastSsrcs() []*SSRC {
+	var ssrcs []*SSRC
+	for _, group := range a.GetSsrcGroups("SIM") {
+		for _, main := range group.Ssrcs {
+			ssrc := &SSRC{main: main}
+			for _, fid := range a.fid_ssrcs {
+				if fid.main == main {
+					ssrc.rtx = fid.rtx
+					break
+				}
+			}
+			ssrcs = append(ssrcs, ssrc)
+		}
+		break
+	}
+	return ssrcs
+}
+
+// GetSsrcGroups returns the ssrc groups of semantics(e.g. FID), or all for "".
+func (a *MediaAttr) GetSsrcGroups(semantics string) []*SsrcGroup {
+	var groups []*SsrcGroup
+	for _, group := range a.ssrc_groups {
+		if len(semantics) == 0 || group.Semantics == semantics {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
 // SDP media lines
 type MediaSdp struct {
 	owner         string       // o=..
 	source        string       // s=..
 	ice_lite      bool         // a=ice-lite
+	ice_ufrag     string       // global a=ice-ufrag:..
+	ice_pwd       string       // global a=ice-pwd:..
 	ice_options   string       // global a=ice-options:..
 	fingerprint   StringPair   // global a=fingerprint:sha-256 ..
 	group_bundles []string     // a=group:BUNDLE ..
 	msid_semantic MsidSemantic // a=msid-sematic: ..
+	extmap_mixed  bool         // global a=extmap-allow-mixed
+	medias        []*MediaAttr // all m= in order(transceivers)
 	audios        []*MediaAttr // m=audio ..
 	videos        []*MediaAttr // m=video ..
 	applications  []*MediaAttr // m=application ..
@@ -252,6 +321,11 @@ func (m *MediaSdp) parseSdp(data []byte) bool {
 			} else {
 				mattr = NewMediaAttr(fields[0], "")
 			}
+			if len(fields) >= 2 {
+				// "<port>[/<number of ports>]"
+				mattr.port = Atoi(strings.Split(fields[1], "/")[0])
+			}
+			m.medias = append(m.medias, mattr)
 			if fields[0] == "audio" {
 				m.audios = append(m.audios, mattr)
 			} else if fields[0] == "video" {
@@ -280,6 +354,14 @@ func (m *MediaSdp) parseSdp_a(line []byte, media *MediaAttr) {
 			m.ice_lite = true
 			return
 		}
+		if akey == "extmap-allow-mixed" {
+			if media == nil {
+				m.extmap_mixed = true
+			} else {
+				media.extmap_mixed = true
+			}
+			return
+		}
 
 		if media == nil {
 			Warnln("[sdp] no valid media for line=", string(line[:]))
@@ -335,6 +417,12 @@ func (m *MediaSdp) parseSdp_a(line []byte, media *MediaAttr) {
 		if akey == "ice-options" {
 			m.ice_options = fields[1]
 			return
+		} else if akey == "ice-ufrag" {
+			m.ice_ufrag = strings.TrimSpace(fields[1])
+			return
+		} else if akey == "ice-pwd" {
+			m.ice_pwd = strings.TrimSpace(fields[1])
+			return
 		} else if akey == "fingerprint" {
 			attrs := strings.SplitN(fields[1], " ", 2)
 			if len(attrs) == 2 {
@@ -406,24 +494,24 @@ func (m *MediaSdp) parseSdp_a(line []byte, media *MediaAttr) {
 	} else if akey == "extmap" {
 		attrs := strings.SplitN(fields[1], " ", 2)
 		if len(attrs) == 2 {
-			extmap := &ExtMapInfo{id: Atoi(attrs[0]), uri: attrs[1]}
 			keys := strings.Split(attrs[0], "/")
+			extmap := &ExtMapInfo{id: Atoi(keys[0]), uri: attrs[1]}
 			if len(keys) >= 2 {
 				extmap.direction = keys[1]
 			}
 			media.extmaps = append(media.extmaps, extmap)
 		}
 	} else if akey == "ssrc-group" {
-		attrs := strings.SplitN(fields[1], " ", 2)
-		if len(attrs) == 2 {
-			if attrs[0] == "FID" {
-				props := strings.Split(attrs[1], " ")
-				if len(props) == 2 {
-					fid := &FidInfo{Atou32(props[0]), Atou32(props[1])}
-					media.fid_ssrcs = append(media.fid_ssrcs, fid)
-				}
-			} else if attrs[0] == "SIM" {
-				// not support
+		attrs := strings.Fields(fields[1])
+		if len(attrs) >= 2 {
+			group := &SsrcGroup{Semantics: attrs[0]}
+			for _, item := range attrs[1:] {
+				group.Ssrcs = append(group.Ssrcs, Atou32(item))
+			}
+			media.ssrc_groups = append(media.ssrc_groups, group)
+			if attrs[0] == "FID" && len(attrs) == 3 {
+				fid := &FidInfo{group.Ssrcs[0], group.Ssrcs[1]}
+				media.fid_ssrcs = append(media.fid_ssrcs, fid)
 			}
 		}
 	} else if akey == "ssrc" {
@@ -433,7 +521,7 @@ func (m *MediaSdp) parseSdp_a(line []byte, media *MediaAttr) {
 			props := strings.SplitN(attrs[1], ":", 2)
 			if len(props) == 2 {
 				if props[0] == "cname" {
-					ssrc.cname = strings.Trim(props[0], "{}")
+					ssrc.cname = strings.Trim(props[1], "{}")
 				} else if props[0] == "msid" {
 					msids := strings.Split(props[1], " ")
 					ssrc.msids = append(ssrc.msids, msids...)
@@ -445,6 +533,17 @@ func (m *MediaSdp) parseSdp_a(line []byte, media *MediaAttr) {
 			}
 			media.ssrcs = append(media.ssrcs, ssrc)
 		}
+	} else if akey == "rid" {
+		attrs := strings.SplitN(fields[1], " ", 3)
+		if len(attrs) >= 2 {
+			rid := &RidInfo{Id: attrs[0], Direction: attrs[1]}
+			if len(attrs) == 3 {
+				rid.Params = attrs[2]
+			}
+			media.rids = append(media.rids, rid)
+		}
+	} else if akey == "simulcast" {
+		media.simulcast = parseSimulcast(fields[1])
 	} else if akey == "msid" {
 		msids := strings.Split(fields[1], " ")
 		if len(msids) > 0 {
@@ -469,6 +568,28 @@ func (m *MediaSdp) parseSdp_a(line []byte, media *MediaAttr) {
 	}
 }
 
+// parseSimulcast parses "send <streams> [recv <streams>]",
+// streams: "h;~m;l" or "1,2;3", and the old "rid=" prefix(draft) is also allowed.
+func parseSimulcast(value string) *SimulcastInfo {
+	info := &SimulcastInfo{}
+	fields := strings.Fields(value)
+	for i := 0; i+1 < len(fields); i += 2 {
+		var layers [][]string
+		for _, stream := range strings.Split(strings.TrimPrefix(fields[i+1], "rid="), ";") {
+			if len(stream) > 0 {
+				layers = append(layers, strings.Split(stream, ","))
+			}
+		}
+		switch fields[i] {
+		case "send":
+			info.Send = layers
+		case "recv":
+			info.Recv = layers
+		}
+	}
+	return info
+}
+
 // Media description (sdp offer/answer)
 type MediaDesc struct {
 	Sdp        MediaSdp
@@ -493,46 +614,145 @@ func (m *MediaDesc) GetMediaType() MediaType {
 	return mt
 }
 
+// firstMedia returns the first media of audio/video/application in order.
+func (m *MediaDesc) firstMedia() *MediaAttr {
+	for _, media := range m.Sdp.medias {
+		switch media.mtype {
+		case "audio", "video", "application":
+			return media
+		}
+	}
+	Warnln("[desc] invalid media type = ", m.GetMediaType())
+	return nil
+}
+
+// GetUfrag returns ice-ufrag of the first media, or the global.
 func (m *MediaDesc) GetUfrag() string {
-	mt := m.GetMediaType()
-	if (mt & kMediaAudio) != 0 {
-		return m.Sdp.audios[0].ice_ufrag
-	} else if (mt & kMediaVideo) != 0 {
-		return m.Sdp.videos[0].ice_ufrag
-	} else if (mt & kMediaApplication) != 0 {
-		return m.Sdp.applications[0].ice_ufrag
-	} else {
-		Warnln("[desc] invalid media type = ", mt)
-		return ""
+	if media := m.firstMedia(); media != nil && len(media.ice_ufrag) > 0 {
+		return media.ice_ufrag
 	}
+	return m.Sdp.ice_ufrag
 }
 
+// GetPasswd returns ice-pwd of the first media, or the global.
 func (m *MediaDesc) GetPasswd() string {
-	mt := m.GetMediaType()
-	if (mt & kMediaAudio) != 0 {
-		return m.Sdp.audios[0].ice_pwd
-	} else if (mt & kMediaVideo) != 0 {
-		return m.Sdp.videos[0].ice_pwd
-	} else if (mt & kMediaApplication) != 0 {
-		return m.Sdp.applications[0].ice_pwd
-	} else {
-		Warnln("[desc] invalid media type = ", mt)
-		return ""
+	if media := m.firstMedia(); media != nil && len(media.ice_pwd) > 0 {
+		return media.ice_pwd
+	}
+	return m.Sdp.ice_pwd
+}
+
+// GetIceOptions returns ice-options of the first media, or the global.
+func (m *MediaDesc) GetIceOptions() string {
+	if media := m.firstMedia(); media != nil && len(media.ice_options) > 0 {
+		return media.ice_options
 	}
+	return m.Sdp.ice_options
 }
 
 func (m *MediaDesc) GetCandidates() []string {
-	mt := m.GetMediaType()
-	if (mt & kMediaAudio) != 0 {
-		return m.Sdp.audios[0].candidates
-	} else if (mt & kMediaVideo) != 0 {
-		return m.Sdp.videos[0].candidates
-	} else if (mt & kMediaApplication) != 0 {
-		return m.Sdp.applications[0].candidates
-	} else {
-		Warnln("[desc] invalid media type = ", mt)
-		return nil
+	if media := m.firstMedia(); media != nil {
+		return media.candidates
+	}
+	return nil
+}
+
+// Transceiver is one media(m-line) of Unified Plan sdp.
+type Transceiver struct {
+	Mid        string
+	Kind       string // audio/video/application
+	Direction  string // sendrecv/sendonly/recvonly/inactive
+	Rejected   bool   // port 0
+	StreamId   string // a=msid:<stream> <track>, "-" for no stream
+	TrackId    string
+	Rids       []*RidInfo
+	Simulcast  *SimulcastInfo
+	SsrcGroups []*SsrcGroup // FID/FEC-FR/SIM/..
+	Ssrcs      []uint32     // a=ssrc in order
+
+	ExtmapAllowMixed bool // a=extmap-allow-mixed of media or session
+}
+
+// IsSimulcast returns true if rids or SIM group.
+func (t *Transceiver) IsSimulcast() bool {
+	if t.Simulcast != nil && (len(t.Simulcast.Send) > 1 || len(t.Simulcast.Recv) > 1) {
+		return true
+	}
+	for _, group := range t.SsrcGroups {
+		if group.Semantics == "SIM" {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTransceivers returns all media in order of m-lines.
+func (m *MediaDesc) GetTransceivers() []*Transceiver {
+	var trans []*Transceiver
+	for _, media := range m.Sdp.medias {
+		t := &Transceiver{
+			Mid:              media.mid,
+			Kind:             media.mtype,
+			Rejected:         media.port == 0,
+			Rids:             media.rids,
+			Simulcast:        media.simulcast,
+			SsrcGroups:       media.ssrc_groups,
+			ExtmapAllowMixed: media.extmap_mixed || m.Sdp.extmap_mixed,
+		}
+		switch media.direction {
+		case kDirectionSendRecv:
+			t.Direction = "sendrecv"
+		case kDirectionSendOnly:
+			t.Direction = "sendonly"
+		case kDirectionRecvOnly:
+			t.Direction = "recvonly"
+		default:
+			t.Direction = "inactive"
+		}
+		if len(media.msids) > 0 {
+			t.StreamId = media.msids[0]
+			if len(media.msids) > 1 {
+				t.TrackId = media.msids[1]
+			}
+		} else {
+			// plan-b or old: a=ssrc:<ssrc> msid:<stream> <track>
+			for _, ssrc := range media.ssrcs {
+				if len(ssrc.msids) > 0 {
+					t.StreamId = ssrc.msids[0]
+					if len(ssrc.msids) > 1 {
+						t.TrackId = ssrc.msids[1]
+					}
+					break
+				}
+			}
+		}
+		for _, ssrc := range media.ssrcs {
+			if !containsUint32(t.Ssrcs, ssrc.ssrc) {
+				t.Ssrcs = append(t.Ssrcs, ssrc.ssrc)
+			}
+		}
+		trans = append(trans, t)
+	}
+	return trans
+}
+
+// GetTransceiver returns the media by mid, nil if not found.
+func (m *MediaDesc) GetTransceiver(mid string) *Transceiver {
+	for _, t := range m.GetTransceivers() {
+		if t.Mid == mid {
+			return t
+		}
+	}
+	return nil
+}
+
+func containsUint32(items []uint32, item uint32) bool {
+	for _, v := range items {
+		if v == item {
+			return true
+		}
 	}
+	return false
 }
 
 func (m *MediaDesc) CreateAnswer() bool {
@@ -732,7 +952,11 @@ func (m *MediaDesc) AnswerSdp() string {
 		for j := range m.Sdp.applications {
 			app := m.Sdp.applications[j]
 			if app.mid == bundle {
-				body = append(body, "m=application 9 "+app.proto+" "+app.ptypes[0])
+				mline := "m=application 9 " + app.proto
+				if len(app.ptypes) > 0 {
+					mline += " " + app.ptypes[0]
+				}
+				body = append(body, mline)
 				body = append(body, "c=IN IP4 0.0.0.0")
 				body = append(body, "a=ice-ufrag:"+app.av_ice_ufrag)
 				body = append(body, "a=ice-pwd:"+app.av_ice_pwd)
@@ -742,7 +966,9 @@ func (m *MediaDesc) AnswerSdp() string {
 				if adir := m.ParseDrection(app.direction); len(adir) > 0 {
 					body = append(body, adir)
 				}
-				if app.sctp.is_sctpmap {
+				if app.sctp == nil {
+					// no sctp port in offer
+				} else if app.sctp.is_sctpmap {
 					body = append(body, "a=sctpmap:"+Itoa(app.sctp.port)+" "+app.sctp.name+" "+Itoa(app.sctp.number))
 				} else {
 					body = append(body, "a=sctp-port:"+Itoa(app.sctp.port))
@@ -900,6 +1126,16 @@ func UpdateSdpCandidates(data []byte, candidates []string) []byte {
 	return []byte(strings.Join(sdp, sp))
 }
 
+// SetSdpIceLite adds session-level "a=ice-lite" before the first m-line if not.
+func SetSdpIceLite(data []byte) []byte {
+	desc := ParseSessionDescription(data)
+	if len(desc.Media) == 0 || desc.HasAttribute("ice-lite") {
+		return data
+	}
+	desc.AddAttribute("ice-lite", "")
+	return desc.Marshal()
+}
+
 // GetSdpCandidates to parse candidates from sdp
 func GetSdpCandidates(data []byte) []string {
 	lines := strings.Split(string(data), "\r\n")
@@ -919,73 +1155,3 @@ func GetSdpCandidates(data []byte) []string {
 	}
 	return candidates
 }
-
-// a=candidate:1 1 udp 2113937151 192.168.1.1 5000 typ host
-// a=candidate:2 1 tcp 1518280447 192.168.1.1 443 typ host tcptype passive
-type Candidate struct {
-	Foundation  string
-	ComponentId int    // 1-256, e.g., RTP-1, RTCP-2
-	Transport   string // udp/tcp
-	Priority    int    // 1-(2^31 - 1)
-	RelAddr     string // raddr
-	RelPort     string // rport
-	CandType    string // typ host/srflx/prflx/relay
-	NetType     string // network type
-}
-
-func ParseCandidates(lines []string) []Candidate {
-	var cands []Candidate
-	for _, line := range lines {
-		if !strings.HasPrefix(line, "a=candidate:") {
-			continue
-		}
-		if cand := ParseCandidate(line); cand != nil {
-			cands = append(cands, *cand)
-		}
-	}
-	return cands
-}
-
-func ParseCandidate(line string) *Candidate {
-	items := strings.Split(line, " ")
-	if len(items) < 8 {
-		Warnln("[sdp] invalid sdp candidate:", line)
-		return nil
-	}
-	foundation := ""
-	if heads := strings.Split(items[0], ":"); len(heads) == 2 {
-		foundation = heads[1]
-	}
-
-	// typ host/srflx/prflx/relay
-	candType := items[6] + " " + items[7]
-
-	// tcptype passive
-	netType := ""
-	if len(items) >= 9 {
-		netType = strings.Join(items[8:], " ")
-	}
-
-	return &Candidate{
-		foundation,
-		Atoi(items[1]), items[2], Atoi(items[3]), items[4], items[5],
-		candType,
-		netType,
-	}
-}
-
-func ParseCandidateHost(line string) string {
-	if cand := ParseCandidate(line); cand != nil {
-		return cand.RelAddr
-	} else {
-		return ""
-	}
-}
-
-func ParseCandidateIp(line string) string {
-	if host := ParseCandidateHost(line); len(host) > 0 {
-		return LookupIP(host)
-	} else {
-		return ""
-	}
-}
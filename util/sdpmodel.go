@@ -0,0 +1,273 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+)
+
+// SdpLine is one "<type>=<value>" line of sdp.
+// The Type is 0 for the invalid line, which is kept as is in Value.
+type SdpLine struct {
+	Type  byte
+	Value string
+	eol   string // "\r\n" or "\n" of the parsed line, "" for the added
+	noeol bool   // the parsed last line without newline
+}
+
+func NewSdpLine(ltype byte, value string) *SdpLine {
+	return &SdpLine{Type: ltype, Value: value}
+}
+
+// NewSdpAttribute returns "a=<name>:<value>", or "a=<name>" for empty value.
+func NewSdpAttribute(name, value string) *SdpLine {
+	if len(value) == 0 {
+		return NewSdpLine('a', name)
+	}
+	return NewSdpLine('a', name+":"+value)
+}
+
+// String returns the line without newline.
+func (l *SdpLine) String() string {
+	if l.Type == 0 {
+		return l.Value
+	}
+	return string([]byte{l.Type, '='}) + l.Value
+}
+
+// Attribute returns the name and value of "a=<name>[:<value>]".
+func (l *SdpLine) Attribute() (name, value string, ok bool) {
+	if l.Type != 'a' {
+		return "", "", false
+	}
+	if pos := strings.IndexByte(l.Value, ':'); pos >= 0 {
+		return l.Value[:pos], l.Value[pos+1:], true
+	}
+	return l.Value, "", true
+}
+
+// SdpSection is the session-level or one media-level lines in order.
+type SdpSection struct {
+	Lines []*SdpLine
+}
+
+// Get returns the value of the first line with type, e.g. 'c'.
+func (s *SdpSection) Get(ltype byte) (string, bool) {
+	for _, line := range s.Lines {
+		if line.Type == ltype {
+			return line.Value, true
+		}
+	}
+	return "", false
+}
+
+// Attribute returns the value of the first "a=<name>".
+func (s *SdpSection) Attribute(name string) (string, bool) {
+	for _, line := range s.Lines {
+		if key, value, ok := line.Attribute(); ok && key == name {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Attributes returns the values of all "a=<name>" in order.
+func (s *SdpSection) Attributes(name string) []string {
+	var values []string
+	for _, line := range s.Lines {
+		if key, value, ok := line.Attribute(); ok && key == name {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// HasAttribute returns true if any "a=<name>".
+func (s *SdpSection) HasAttribute(name string) bool {
+	_, ok := s.Attribute(name)
+	return ok
+}
+
+// AddAttribute appends "a=<name>[:<value>]".
+func (s *SdpSection) AddAttribute(name, value string) {
+	s.Lines = append(s.Lines, NewSdpAttribute(name, value))
+}
+
+// SetAttribute replaces the first "a=<name>" or appends it.
+func (s *SdpSection) SetAttribute(name, value string) {
+	for idx, line := range s.Lines {
+		if key, _, ok := line.Attribute(); ok && key == name {
+			attr := NewSdpAttribute(name, value)
+			attr.eol, attr.noeol = line.eol, line.noeol
+			s.Lines[idx] = attr
+			return
+		}
+	}
+	s.AddAttribute(name, value)
+}
+
+// RemoveAttributes removes all "a=<name>", and returns the count.
+func (s *SdpSection) RemoveAttributes(name string) int {
+	return s.RemoveLines(func(line *SdpLine) bool {
+		key, _, ok := line.Attribute()
+		return ok && key == name
+	})
+}
+
+// RemoveLines removes the lines matched, and returns the count.
+func (s *SdpSection) RemoveLines(match func(line *SdpLine) bool) int {
+	var lines []*SdpLine
+	for _, line := range s.Lines {
+		if !match(line) {
+			lines = append(lines, line)
+		}
+	}
+	count := len(s.Lines) - len(lines)
+	s.Lines = lines
+	return count
+}
+
+// InsertLine inserts line at index(bounded).
+func (s *SdpSection) InsertLine(index int, line *SdpLine) {
+	if index < 0 {
+		index = 0
+	} else if index > len(s.Lines) {
+		index = len(s.Lines)
+	}
+	s.Lines = append(s.Lines, nil)
+	copy(s.Lines[index+1:], s.Lines[index:])
+	s.Lines[index] = line
+}
+
+// isSdpType returns true for the type of "<type>=<value>", a single letter.
+func isSdpType(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// SdpMedia is one media section, the first line is "m=".
+type SdpMedia struct {
+	SdpSection
+}
+
+// fields returns "<media> <port> <proto> <fmt> ..." of m-line.
+func (m *SdpMedia) fields() []string {
+	if len(m.Lines) == 0 {
+		return nil
+	}
+	return strings.Fields(m.Lines[0].Value)
+}
+
+func (m *SdpMedia) field(idx int) string {
+	if fields := m.fields(); idx < len(fields) {
+		return fields[idx]
+	}
+	return ""
+}
+
+// Kind returns audio/video/application/...
+func (m *SdpMedia) Kind() string {
+	return m.field(0)
+}
+
+func (m *SdpMedia) Port() string {
+	return m.field(1)
+}
+
+func (m *SdpMedia) Proto() string {
+	return m.field(2)
+}
+
+// Formats returns the payload types(rtp) or formats of m-line.
+func (m *SdpMedia) Formats() []string {
+	if fields := m.fields(); len(fields) > 3 {
+		return fields[3:]
+	}
+	return nil
+}
+
+// SetFormats replaces the formats of m-line.
+func (m *SdpMedia) SetFormats(formats []string) {
+	fields := m.fields()
+	if len(fields) < 3 {
+		return
+	}
+	m.Lines[0].Value = strings.Join(append(fields[0:3:3], formats...), " ")
+}
+
+func (m *SdpMedia) Mid() string {
+	mid, _ := m.Attribute("mid")
+	return mid
+}
+
+// SessionDescription is the lossless sdp model,
+// in which all lines are kept in order and Marshal returns the same bytes of Parse.
+type SessionDescription struct {
+	SdpSection // session-level
+	Media      []*SdpMedia
+	eol        string // newline for the added lines
+}
+
+// ParseSessionDescription never fails, the invalid lines are kept as is.
+func ParseSessionDescription(data []byte) *SessionDescription {
+	d := &SessionDescription{eol: "\r\n"}
+	section := &d.SdpSection
+	for len(data) > 0 {
+		var line []byte
+		eol := ""
+		if pos := bytes.IndexByte(data, '\n'); pos >= 0 {
+			line, data = data[:pos], data[pos+1:]
+			eol = "\n"
+			if len(line) > 0 && line[len(line)-1] == '\r' {
+				line = line[:len(line)-1]
+				eol = "\r\n"
+			}
+		} else {
+			line, data = data, nil
+		}
+		item := &SdpLine{Value: string(line), eol: eol, noeol: len(eol) == 0}
+		if len(d.Lines) == 0 && len(d.Media) == 0 && len(eol) > 0 {
+			d.eol = eol
+		}
+
+		if len(line) >= 2 && line[1] == '=' && isSdpType(line[0]) {
+			item.Type = line[0]
+			item.Value = string(line[2:])
+		}
+		if item.Type == 'm' {
+			d.Media = append(d.Media, &SdpMedia{})
+			section = &d.Media[len(d.Media)-1].SdpSection
+		}
+		section.Lines = append(section.Lines, item)
+	}
+	return d
+}
+
+// AddMedia appends one media section by m-line value, e.g. "audio 9 UDP/TLS/RTP/SAVPF 111".
+func (d *SessionDescription) AddMedia(mline string) *SdpMedia {
+	m := &SdpMedia{SdpSection{Lines: []*SdpLine{NewSdpLine('m', mline)}}}
+	d.Media = append(d.Media, m)
+	return m
+}
+
+// Marshal returns the sdp, the added lines end with the newline of the parsed.
+func (d *SessionDescription) Marshal() []byte {
+	var lines []*SdpLine
+	lines = append(lines, d.Lines...)
+	for _, m := range d.Media {
+		lines = append(lines, m.Lines...)
+	}
+
+	var buf bytes.Buffer
+	for idx, line := range lines {
+		buf.WriteString(line.String())
+		if len(line.eol) > 0 {
+			buf.WriteString(line.eol)
+		} else if !line.noeol || idx+1 < len(lines) {
+			buf.WriteString(d.eol)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (d *SessionDescription) String() string {
+	return string(d.Marshal())
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"io"
 	"net"
@@ -63,18 +64,14 @@ func ReadIceTcpPacket(conn net.Conn, body []byte) (int, error) {
 	}
 
 	// read head(2bytes)
-	head := make([]byte, 2)
-	nret, err := conn.Read(head[0:2])
-	if err != nil {
-		nret = -1
-	}
-	if nret != 2 {
-		Warnln("ice-tcp read head fail, err=", err, nret)
+	var head [2]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		Warnln("ice-tcp read head fail, err=", err)
 		return 0, errors.New("ice-tcp read head fail(2bytes)")
 	}
 
 	// get body size
-	dsize := HostToNet16(BytesToUint16(head))
+	dsize := int(binary.BigEndian.Uint16(head[:]))
 	if dsize == 0 {
 		Warnln("ice-tcp empty body")
 		return 0, nil
@@ -82,21 +79,21 @@ func ReadIceTcpPacket(conn net.Conn, body []byte) (int, error) {
 	//Println("ice-tcp body size:", dsize)
 
 	// read body packet
+	var err error
 	rpos := 0
-	for rpos < int(dsize) {
-		need := int(dsize) - rpos
-		if nret, err = conn.Read(body[rpos : rpos+need]); err == nil {
+	for rpos < dsize {
+		var nret int
+		if nret, err = conn.Read(body[rpos:dsize]); err == nil {
 			rpos += nret
 		} else {
 			Warnln("ice-tcp read body err:", err)
 			if err == io.EOF {
 				break // end
 			}
-			if nerr := err.(*net.OpError); nerr != nil {
-				if nerr.Timeout() || nerr.Temporary() {
-					Warnln("ice-tcp read clear error:", nerr)
-					err = nil // clear
-				}
+			// maybe not net.Error, e.g. tls
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				Warnln("ice-tcp read clear error:", nerr)
+				err = nil // clear
 			}
 			if err != nil {
 				break
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The ChannelData message(RFC 8656): channel(2) + length(2) + data.
+const (
+	TurnChannelMin uint16 = 0x4000
+	TurnChannelMax uint16 = 0x4FFF
+
+	kTurnChannelHeaderSize int = 4
+	kMaxTurnTcpPacketSize  int = kStunHeaderSize + 65535 // > ChannelData(4 + 65535 + 3)
+)
+
+// IsChannelData returns whether a given packet is a ChannelData(the first byte is 64-79).
+func IsChannelData(data []byte) bool {
+	if len(data) < kTurnChannelHeaderSize {
+		return false
+	}
+	if data[0] < 0x40 || data[0] > 0x4F {
+		return false
+	}
+	return int(binary.BigEndian.Uint16(data[2:4])) <= len(data)-kTurnChannelHeaderSize
+}
+
+// ParseChannelData returns the channel number and data(without padding).
+func ParseChannelData(data []byte) (uint16, []byte, bool) {
+	if !IsChannelData(data) {
+		return 0, nil, false
+	}
+	size := int(binary.BigEndian.Uint16(data[2:4]))
+	return binary.BigEndian.Uint16(data[0:2]), data[4 : 4+size], true
+}
+
+// MakeChannelData makes a ChannelData, which is padded to 4 bytes for stream(tcp).
+func MakeChannelData(channel uint16, data []byte, stream bool) []byte {
+	size := kTurnChannelHeaderSize + len(data)
+	if stream {
+		size += (4 - len(data)%4) % 4
+	}
+	packet := make([]byte, size)
+	binary.BigEndian.PutUint16(packet[0:2], channel)
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(data)))
+	copy(packet[4:], data)
+	return packet
+}
+
+// IsTurnPacket returns whether a given packet is a TURN message(not Binding) or ChannelData.
+func IsTurnPacket(data []byte) bool {
+	if IsChannelData(data) {
+		return true
+	}
+	if !IsStunPacket(data) {
+		return false
+	}
+	dtype := StunMessageType(binary.BigEndian.Uint16(data[0:2]))
+	return dtype&0x3EEF != STUN_METHOD_BINDING
+}
+
+// IsTurnTcpStream checks the head(at least 8 bytes) of tcp stream,
+// which is STUN with magic cookie directly(not framed by RFC 4571).
+func IsTurnTcpStream(head []byte) bool {
+	if len(head) < 8 || head[0] > 1 {
+		return false
+	}
+	return binary.BigEndian.Uint32(head[4:8]) == kStunMagicCookie
+}
+
+// ReadTurnTcpPacket reads one STUN or ChannelData(with padding) from tcp stream.
+func ReadTurnTcpPacket(conn io.Reader, body []byte) (int, error) {
+	if len(body) < kMaxTurnTcpPacketSize {
+		return 0, errors.New("No enough size in buf for turn-tcp")
+	}
+	if _, err := io.ReadFull(conn, body[0:4]); err != nil {
+		return 0, err
+	}
+
+	size := int(binary.BigEndian.Uint16(body[2:4]))
+	if body[0] >= 0x40 && body[0] <= 0x4F {
+		size += (4 - size%4) % 4
+	} else if body[0] <= 1 {
+		size += kStunHeaderSize - 4
+	} else {
+		return 0, errors.New("invalid turn-tcp packet")
+	}
+	if _, err := io.ReadFull(conn, body[4:4+size]); err != nil {
+		return 0, err
+	}
+	return 4 + size, nil
+}
+
+// ValidateStunMessageIntegrity checks MESSAGE-INTEGRITY of STUN packet by key.
+func ValidateStunMessageIntegrity(data []byte, key string) bool {
+	if len(data) < kStunHeaderSize {
+		return false
+	}
+
+	pos := kStunHeaderSize
+	for pos+kStunAttributeHeaderSize <= len(data) {
+		attrType := StunAttributeType(binary.BigEndian.Uint16(data[pos:]))
+		attrLen := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if attrType == STUN_ATTR_MESSAGE_INTEGRITY {
+			end := pos + kStunAttributeHeaderSize + attrLen
+			if attrLen != kStunMessageIntegritySize || end > len(data) {
+				return false
+			}
+
+			// the length in header ends at MESSAGE-INTEGRITY
+			msg := make([]byte, pos)
+			copy(msg, data[0:pos])
+			binary.BigEndian.PutUint16(msg[2:4], uint16(end-kStunHeaderSize))
+
+			macFunc := hmac.New(sha1.New, []byte(key))
+			macFunc.Write(msg)
+			return hmac.Equal(macFunc.Sum(nil), data[pos+kStunAttributeHeaderSize:end])
+		}
+		pos += kStunAttributeHeaderSize + attrLen + (4-attrLen%4)%4
+	}
+	return false
+}
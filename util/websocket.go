@@ -0,0 +1,295 @@
+package util
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocket(RFC 6455) opcodes
+const (
+	WsContinuation  = 0x0
+	WsTextMessage   = 0x1
+	WsBinaryMessage = 0x2
+	WsCloseMessage  = 0x8
+	WsPingMessage   = 0x9
+	WsPongMessage   = 0xA
+)
+
+const kWsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// the max size of one websocket message
+const kMaxWsMessageSize = 4 * 1024 * 1024
+
+// WsAcceptKey returns Sec-WebSocket-Accept for Sec-WebSocket-Key.
+func WsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + kWsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// WsNewKey returns a random Sec-WebSocket-Key.
+func WsNewKey() string {
+	key := make([]byte, 16)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// IsWsUpgrade returns true if it is a websocket upgrade request.
+func IsWsUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// WsUpgrade hijacks the http conn and replies websocket handshake(server side).
+func WsUpgrade(w http.ResponseWriter, r *http.Request, protocol string) (*WsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !IsWsUpgrade(r) || len(key) == 0 {
+		return nil, errors.New("not websocket upgrade")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errNoHijacker
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + WsAcceptKey(key) + "\r\n"
+	if len(protocol) > 0 {
+		resp += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	resp += "\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return NewWsConn(conn, rw.Reader, false), nil
+}
+
+// WsDial connects to ws/wss url(client side), and returns the conn and selected protocol.
+func WsDial(rawurl, protocol string, timeout time.Duration) (*WsConn, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	host := u.Host
+	if len(u.Port()) == 0 {
+		if u.Scheme == "wss" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: timeout}
+	switch u.Scheme {
+	case "ws":
+		conn, err = dialer.Dial("tcp", host)
+	case "wss":
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, "", errors.New("unsupported websocket scheme: " + u.Scheme)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := WsNewKey()
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(protocol) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", protocol)
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != WsAcceptKey(key) {
+		conn.Close()
+		return nil, "", errors.New("websocket handshake failed: " + resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return NewWsConn(conn, br, true), resp.Header.Get("Sec-WebSocket-Protocol"), nil
+}
+
+// WsConn reads/writes websocket messages over an upgraded conn.
+// The client side masks all frames it sends.
+type WsConn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	isClient bool
+	wmtx     sync.Mutex
+
+	// the fragmented message being read, kept over control frames
+	fragOp   int
+	fragData []byte
+}
+
+func NewWsConn(conn net.Conn, br *bufio.Reader, isClient bool) *WsConn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &WsConn{conn: conn, br: br, isClient: isClient}
+}
+
+func (c *WsConn) Close() error {
+	return c.conn.Close()
+}
+
+// readFrame reads one frame and returns (fin, opcode, payload).
+func (c *WsConn) readFrame() (bool, int, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := (head[0] & 0x80) != 0
+	opcode := int(head[0] & 0x0F)
+	masked := (head[1] & 0x80) != 0
+
+	length := uint64(head[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	} else if length == 127 {
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > kMaxWsMessageSize {
+		return false, 0, nil, errors.New("websocket frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage returns one complete message (continuation frames joined).
+// Control frames are returned as soon as they arrive, even between fragments,
+// and the fragments read are kept for the next call.
+func (c *WsConn) ReadMessage() (int, []byte, error) {
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		if op >= WsCloseMessage {
+			return op, payload, nil
+		}
+		if op == WsContinuation {
+			if c.fragOp == 0 {
+				return 0, nil, errors.New("websocket unexpected continuation")
+			}
+		} else {
+			if c.fragOp != 0 {
+				return 0, nil, errors.New("websocket unfinished message")
+			}
+			c.fragOp = op
+		}
+		c.fragData = append(c.fragData, payload...)
+		if len(c.fragData) > kMaxWsMessageSize {
+			return 0, nil, errors.New("websocket message too large")
+		}
+		if fin {
+			opcode, data := c.fragOp, c.fragData
+			c.fragOp, c.fragData = 0, nil
+			return opcode, data, nil
+		}
+	}
+}
+
+// WriteMessage writes one message in a single frame.
+func (c *WsConn) WriteMessage(opcode int, data []byte) error {
+	c.wmtx.Lock()
+	defer c.wmtx.Unlock()
+
+	head := []byte{0x80 | byte(opcode), 0}
+	length := len(data)
+	if length < 126 {
+		head[1] = byte(length)
+	} else if length <= 0xFFFF {
+		head[1] = 126
+		head = append(head, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(length))
+	} else {
+		head[1] = 127
+		head = append(head, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(length))
+	}
+
+	payload := data
+	if c.isClient {
+		head[1] |= 0x80
+		var mask [4]byte
+		rand.Read(mask[:])
+		head = append(head, mask[:]...)
+		payload = make([]byte, length)
+		for i := range data {
+			payload[i] = data[i] ^ mask[i%4]
+		}
+	}
+
+	if _, err := c.conn.Write(append(head, payload...)); err != nil {
+		return err
+	}
+	return nil
+}